@@ -0,0 +1,129 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix = "links-"
+	backupSuffix = ".jsonl"
+
+	// backupTimeFormat is used in backup file names so that they sort in the
+	// order they were written.
+	backupTimeFormat = "20060102T150405Z"
+)
+
+// backupLinks writes a snapshot of all links in db, with their stats and
+// history, to a new file in dir, then removes all but the newest keep
+// snapshots. It returns the path of the new snapshot.
+//
+// The snapshot is written to a temporary file and renamed into place, so
+// dir never contains a partially written snapshot.
+func backupLinks(db LinkStore, dir string, keep int, now time.Time) (string, error) {
+	links, err := db.LoadAll()
+	if err != nil {
+		return "", err
+	}
+	opts, err := loadSnapshotOptions(db, links, true, true)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(dir, ".tmp-"+backupPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if err := writeSnapshot(f, links, opts); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeFormat)+backupSuffix)
+	if err := os.Rename(f.Name(), name); err != nil {
+		return "", err
+	}
+	if err := rotateBackups(dir, keep); err != nil {
+		return name, fmt.Errorf("rotating backups: %w", err)
+	}
+	return name, nil
+}
+
+// rotateBackups removes all but the newest keep snapshots in dir.
+// Files in dir that are not snapshots are left alone.
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string // sorted oldest first, as os.ReadDir sorts by name
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	var errs []error
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backup writes a snapshot to BackupDir and records the result in the
+// backup metrics.
+func (s *Server) backup() error {
+	now := time.Now()
+	name, err := backupLinks(s.db, s.opts.BackupDir, max(s.opts.BackupKeep, 1), now)
+	if err != nil {
+		s.metrics.backupFailures.Inc()
+		return err
+	}
+	s.metrics.backupLastSuccess.Set(float64(now.Unix()))
+	if s.opts.Verbose {
+		log.Printf("Wrote backup %s.", name)
+	}
+	return nil
+}
+
+// backupLoop will write a snapshot every BackupInterval, or daily if it is
+// zero.  This function returns when ctx is done.
+func (s *Server) backupLoop(ctx context.Context) {
+	interval := s.opts.BackupInterval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	for {
+		if err := s.backup(); err != nil {
+			log.Printf("backing up links: %v", err)
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
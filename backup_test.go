@@ -0,0 +1,132 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestBackup(t *testing.T) {
+	db := NewMemoryDB()
+	links := []*Link{
+		{Short: "who", Long: "http://who/", Owner: "foo@example.com", Tags: []string{"people"}},
+		{Short: "secret", Long: "http://secret/", Owner: "foo@example.com", Visibility: visibilityOwner},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	// a file that is not a snapshot is never rotated away
+	if err := os.WriteFile(filepath.Join(dir, "README"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	var written []string
+	for i := range 3 {
+		name, err := backupLinks(db, dir, 2, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, filepath.Base(name))
+	}
+	if want := "links-20220602T030203Z.jsonl"; written[2] != want {
+		t.Errorf("backup name = %q; want %q", written[2], want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"README", written[1], written[2]}
+	if !slices.Equal(got, want) {
+		t.Errorf("files after rotation = %q; want %q", got, want)
+	}
+
+	// the newest snapshot restores every link, including restricted ones
+	snapshot, err := os.ReadFile(filepath.Join(dir, written[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = NewMemoryDB()
+	s := newTestServer(t, db)
+	s.opts.Snapshot = snapshot
+	if err := s.restoreSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := db.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(links) {
+		t.Errorf("restored %d links; want %d", len(restored), len(links))
+	}
+	for _, link := range links {
+		got, err := db.Load(link.Short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Created, got.LastEdit = time.Time{}, time.Time{}
+		if !cmp.Equal(got, link) {
+			t.Errorf("restored %q diff (-want +got):\n%s", link.Short, cmp.Diff(link, got))
+		}
+	}
+}
+
+func TestBackupMetrics(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/"})
+
+	dir := t.TempDir()
+	s.opts.BackupDir = dir
+	before := metricValue(t, s.metrics.backupFailures)
+	if err := s.backup(); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(t, s.metrics.backupLastSuccess); got == 0 {
+		t.Error("last backup time not recorded")
+	}
+
+	// writing into a regular file fails
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.opts.BackupDir = notDir
+	if err := s.backup(); err == nil {
+		t.Error("backup into a file succeeded; want error")
+	}
+	if got := metricValue(t, s.metrics.backupFailures) - before; got != 1 {
+		t.Errorf("backup failures increased by %v; want 1", got)
+	}
+}
+
+// metricValue returns the current value of a gauge or counter.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	if pb.Gauge != nil {
+		return pb.Gauge.GetValue()
+	}
+	return pb.Counter.GetValue()
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"io/fs"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/tstime"
+	"tailscale.com/util/lru"
+)
+
+// linkCacheTTL is how long a cached Link is used before it is loaded again.
+// Writes through the cache invalidate it immediately; the TTL only bounds
+// how long edits made by other golink instances sharing a PostgreSQL
+// database take to be seen.
+const linkCacheTTL = time.Minute
+
+// linkCache is a LinkStore that caches the Links returned by Load and
+// LoadByAlias from an underlying LinkStore, so that resolving the same link
+// repeatedly does not query the database each time. Writes made through
+// the cache invalidate the entries they affect.
+type linkCache struct {
+	LinkStore // the underlying store
+
+	metrics *metrics // records hits and misses
+
+	clock tstime.Clock // allow overriding time for tests
+
+	mu    sync.Mutex
+	links lru.Cache[string, cachedLink] // by ID of the name looked up
+	gen   uint64                        // incremented by every invalidation
+}
+
+// cachedLink is a linkCache entry.
+type cachedLink struct {
+	link   *Link
+	loaded time.Time
+}
+
+// newLinkCache returns a linkCache of at most size Links in front of s,
+// which counts its hits and misses in m.
+func newLinkCache(s LinkStore, size int, m *metrics) *linkCache {
+	c := &linkCache{LinkStore: s, metrics: m}
+	c.links.MaxEntries = size
+	return c
+}
+
+// baseStore returns the LinkStore underneath s, if s is a linkCache.
+func baseStore(s LinkStore) LinkStore {
+	if c, ok := s.(*linkCache); ok {
+		return c.LinkStore
+	}
+	return s
+}
+
+// Load returns a Link by its short name.
+//
+// It returns fs.ErrNotExist if the link does not exist.
+//
+// The caller owns the returned value.
+func (c *linkCache) Load(short string) (*Link, error) {
+	return c.load(short, false)
+}
+
+// LoadByAlias returns the Link that alias resolves to.
+//
+// It returns fs.ErrNotExist if there is no such alias.
+//
+// The caller owns the returned value.
+func (c *linkCache) LoadByAlias(alias string) (*Link, error) {
+	return c.load(alias, true)
+}
+
+// load returns the Link named name, or that name is an alias of if alias
+// is true, from the cache or else from the underlying store.
+//
+// Names are either links or aliases, never both, so a cached alias also
+// tells Load that there is no link by that name, and vice versa.
+func (c *linkCache) load(name string, alias bool) (*Link, error) {
+	id := linkID(name)
+	now := tstime.DefaultClock{Clock: c.clock}.Now()
+
+	c.mu.Lock()
+	e, ok := c.links.GetOk(id)
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && now.Sub(e.loaded) < linkCacheTTL {
+		c.metrics.linkCacheHits.Inc()
+		if isAlias := linkID(e.link.Short) != id; isAlias != alias {
+			return nil, fs.ErrNotExist
+		}
+		return cloneLink(e.link), nil
+	}
+
+	c.metrics.linkCacheMisses.Inc()
+	var link *Link
+	var err error
+	if alias {
+		link, err = c.LinkStore.LoadByAlias(name)
+	} else {
+		link, err = c.LinkStore.Load(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	// if the link was written while it was being loaded, the loaded
+	// copy may already be stale
+	if c.gen == gen {
+		c.links.Set(id, cachedLink{cloneLink(link), now})
+	}
+	c.mu.Unlock()
+	return link, nil
+}
+
+// invalidate removes the link with the specified ID from the cache, along
+// with any entries for its aliases.
+func (c *linkCache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	var stale []string
+	c.links.ForEach(func(k string, e cachedLink) {
+		if k == id || linkID(e.link.Short) == id {
+			stale = append(stale, k)
+		}
+	})
+	for _, k := range stale {
+		c.links.Delete(k)
+	}
+}
+
+// invalidateAll removes every link from the cache.
+func (c *linkCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.links.Clear()
+}
+
+// Save saves a Link.
+func (c *linkCache) Save(link *Link) error {
+	defer c.invalidate(linkID(link.Short))
+	return c.LinkStore.Save(link)
+}
+
+// SaveWithRevision saves a Link and records rev, the version of the link
+// that it replaces.
+func (c *linkCache) SaveWithRevision(link *Link, rev *Revision) error {
+	defer c.invalidate(linkID(link.Short))
+	return c.LinkStore.SaveWithRevision(link, rev)
+}
+
+// Delete removes a Link using its short name.
+func (c *linkCache) Delete(short string) error {
+	defer c.invalidate(linkID(short))
+	return c.LinkStore.Delete(short)
+}
+
+// ExpireLinks marks links whose ExpiresAt is at or before now as expired.
+func (c *linkCache) ExpireLinks(now time.Time) (int, error) {
+	n, err := c.LinkStore.ExpireLinks(now)
+	if n > 0 || err != nil {
+		c.invalidateAll()
+	}
+	return n, err
+}
+
+// TrashLink moves a Link and its click stats into the trash.
+func (c *linkCache) TrashLink(short, deletedBy string, deleted time.Time) error {
+	defer c.invalidate(linkID(short))
+	return c.LinkStore.TrashLink(short, deletedBy, deleted)
+}
+
+// RestoreLink moves a Link and its click stats out of the trash.
+func (c *linkCache) RestoreLink(short string) error {
+	defer c.invalidate(linkID(short))
+	return c.LinkStore.RestoreLink(short)
+}
+
+// RenameLink renames a Link, moving its click stats and revisions to the
+// new name.
+func (c *linkCache) RenameLink(short, newShort string, keepAlias bool) error {
+	defer c.invalidate(linkID(short))
+	return c.LinkStore.RenameLink(short, newShort, keepAlias)
+}
+
+// ImportLinks saves links in a single transaction, merging them with
+// existing links of the same name as specified by mode.
+func (c *linkCache) ImportLinks(links []*Link, mode ImportMode, editor string, dryRun bool) (*ImportReport, error) {
+	if !dryRun {
+		defer c.invalidateAll()
+	}
+	return c.LinkStore.ImportLinks(links, mode, editor, dryRun)
+}
+
+// cloneLink returns a copy of link that shares no memory with it.
+func cloneLink(link *Link) *Link {
+	l := *link
+	l.Tags = slices.Clone(link.Tags)
+	l.Aliases = slices.Clone(link.Aliases)
+	l.CoOwners = slices.Clone(link.CoOwners)
+	return &l
+}
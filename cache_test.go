@@ -0,0 +1,137 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/xsrftoken"
+	"tailscale.com/tstest"
+)
+
+func TestLinkCache(t *testing.T) {
+	base := NewMemoryDB()
+	m := newMetrics()
+	cache := newLinkCache(base, 10, m)
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)})
+	cache.clock = clock
+	s := newTestServer(t, cache)
+	cache.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Aliases: []string{"whois"}})
+
+	resolve := func(t *testing.T, short string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/"+short, nil))
+		if w.Code != http.StatusFound {
+			return ""
+		}
+		return w.Header().Get("Location")
+	}
+	post := func(t *testing.T, path, short string, form url.Values) {
+		t.Helper()
+		if form == nil {
+			form = make(url.Values)
+		}
+		form.Set("xsrf", xsrftoken.Generate(s.xsrfKey, "foo@example.com", short))
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s = %d; want %d\n%s", path, w.Code, http.StatusOK, w.Body)
+		}
+	}
+	check := func(t *testing.T, short, want string) {
+		t.Helper()
+		if got := resolve(t, short); got != want {
+			t.Errorf("resolve(%q) = %q; want %q", short, got, want)
+		}
+	}
+
+	t.Run("hits and misses", func(t *testing.T) {
+		hits, misses := metricValue(t, m.linkCacheHits), metricValue(t, m.linkCacheMisses)
+		check(t, "who", "http://who/")
+		check(t, "who", "http://who/")
+		check(t, "whois", "http://who/")
+		check(t, "whois", "http://who/")
+		// who: miss, hit; whois: miss loading it as a link, then miss loading
+		// it as an alias; whois again: hit that tells Load it is an alias,
+		// then hit loading the alias
+		if got, want := metricValue(t, m.linkCacheHits)-hits, 3.0; got != want {
+			t.Errorf("cache hits = %v; want %v", got, want)
+		}
+		if got, want := metricValue(t, m.linkCacheMisses)-misses, 3.0; got != want {
+			t.Errorf("cache misses = %v; want %v", got, want)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		check(t, "who", "http://who/")
+		post(t, "/", "who", url.Values{"short": {"who"}, "long": {"http://who/new"}, "aliases": {"whois"}})
+		check(t, "who", "http://who/new")
+		check(t, "whois", "http://who/new")
+	})
+
+	t.Run("remove alias", func(t *testing.T) {
+		check(t, "whois", "http://who/new")
+		post(t, "/", "who", url.Values{"short": {"who"}, "long": {"http://who/new"}, "aliases": {""}})
+		check(t, "whois", "")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		check(t, "who", "http://who/new")
+		if _, err := cache.ImportLinks([]*Link{{Short: "who", Long: "http://who/imported", Owner: "foo@example.com"}}, ImportOverwrite, "", false); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "who", "http://who/imported")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		check(t, "who", "http://who/imported")
+		post(t, "/.delete/who", "who", nil)
+		check(t, "who", "")
+		if err := cache.RestoreLink("who"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "who", "http://who/imported")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		check(t, "who", "http://who/imported")
+		if err := cache.RenameLink("who", "whom", false); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "who", "")
+		check(t, "whom", "http://who/imported")
+		if err := cache.RenameLink("whom", "who", true); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "who", "http://who/imported")
+		check(t, "whom", "http://who/imported")
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		check(t, "who", "http://who/imported")
+		// writes that bypass the cache, such as by another golink instance,
+		// are seen once the cached link expires
+		base.Save(&Link{Short: "who", Long: "http://who/elsewhere", Owner: "foo@example.com"})
+		check(t, "who", "http://who/imported")
+		clock.Advance(linkCacheTTL)
+		check(t, "who", "http://who/elsewhere")
+	})
+
+	t.Run("caller owns links", func(t *testing.T) {
+		link, err := cache.Load("who")
+		if err != nil {
+			t.Fatal(err)
+		}
+		link.Long = "http://mutated/"
+		check(t, "who", "http://who/elsewhere")
+	})
+}
@@ -6,11 +6,16 @@
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -26,12 +31,303 @@ type Link struct {
 	Created  time.Time
 	LastEdit time.Time // when the link was last edited
 	Owner    string    // user@domain
+
+	// CoOwners are additional owners who can edit the link. Each is either
+	// a user login or a group of the form "group:name", matching the groups
+	// granted to users by the golink peer capability. They are sorted.
+	CoOwners []string `json:",omitempty"`
+
+	// Description is free text explaining what the link is for.
+	Description string
+
+	// Tags are labels used to group related links, such as "team:infra".
+	// They are stored lowercase and sorted.
+	Tags []string `json:",omitempty"`
+
+	// Aliases are additional short names that resolve to this link.
+	// They are sorted by their normalized ID.
+	Aliases []string `json:",omitempty"`
+
+	// ExpiresAt is when the link stops resolving. The zero value means
+	// the link never expires.
+	ExpiresAt time.Time `json:",omitzero"`
+
+	// Expired is set by the periodic expiry sweep (see ExpireLinks) once
+	// ExpiresAt has passed.
+	Expired bool `json:",omitempty"`
+
+	// Visibility restricts who can see and resolve the link. It is empty
+	// for public links, "owner" for links only visible to their owners,
+	// or "access:name" for links visible to users granted access to name
+	// by the golink peer capability.
+	Visibility string `json:",omitempty"`
+}
+
+// IsExpired reports whether the link is expired at the specified time.
+func (l *Link) IsExpired(now time.Time) bool {
+	return l.Expired || !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// Revision is a previous version of a Link, recorded each time the link is
+// edited.
+type Revision struct {
+	ID      int64     // unique revision ID
+	Short   string    // short name of the link at the time of the edit
+	Long    string    // destination before the edit
+	Owner   string    // owner before the edit
+	Editor  string    // user who made the edit
+	Created time.Time // when the edit was made
+
+	// LinkID is the normalized ID of the link the revision belongs to, if
+	// the link has been renamed since the edit. It is empty if the ID is
+	// that of Short.
+	LinkID string `json:",omitempty"`
+}
+
+// revisionLinkID returns the normalized ID of the link rev belongs to.
+func revisionLinkID(rev *Revision) string {
+	if rev.LinkID != "" {
+		return rev.LinkID
+	}
+	return linkID(rev.Short)
+}
+
+// TrashedLink is a deleted Link, kept in the trash until it is restored or
+// purged.
+type TrashedLink struct {
+	Link
+	DeletedBy string    // user who deleted the link
+	Deleted   time.Time // when the link was deleted
+}
+
+// LinkMatch is a Link returned by a search, along with how well it matched.
+type LinkMatch struct {
+	*Link
+	Score float64 // relevance to the search terms; higher is better
 }
 
 // ClickStats is the number of clicks a set of links have received in a given
 // time period. It is keyed by link short name, with values of total clicks.
 type ClickStats map[string]int
 
+// StatsRecord is a single stored click stats entry: the number of clicks
+// recorded for a link ID at a point in time.
+type StatsRecord struct {
+	ID      string // normalized link ID, as returned by linkID
+	Created time.Time
+	Clicks  int
+}
+
+// LinkHealth is the result of the most recent check of a link's destination
+// by the health checker.
+type LinkHealth struct {
+	ID      string        // normalized link ID, as returned by linkID
+	Long    string        // the link's destination when it was checked
+	URL     string        // the URL that was requested
+	Status  int           // HTTP status of the response, or 0 if there was none
+	Error   string        `json:",omitempty"` // why there was no response
+	Latency time.Duration // how long the response took to arrive
+	Checked time.Time     // when the check was made
+}
+
+// Broken reports whether the check found the link's destination to be
+// broken: either it could not be reached, or it responded with an error
+// other than one asking for credentials the checker does not have or for
+// it to slow down.
+func (h *LinkHealth) Broken() bool {
+	switch h.Status {
+	case 0:
+		return true
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired, http.StatusTooManyRequests:
+		return false
+	}
+	return h.Status >= 400
+}
+
+// ErrNameInUse is returned when saving a link whose name or aliases are
+// already used by another link.
+var ErrNameInUse = errors.New("name is already in use by another link")
+
+// LinkStore is the interface implemented by golink storage backends.
+//
+// Implementations identify links by their normalized ID (see linkID),
+// so lookups are case-insensitive and ignore hyphens.
+type LinkStore interface {
+	// LoadAll returns all stored Links.
+	LoadAll() ([]*Link, error)
+
+	// Load returns a Link by its short name.
+	// It returns fs.ErrNotExist if the link does not exist.
+	Load(short string) (*Link, error)
+
+	// Save saves a Link, replacing any existing link with the same ID.
+	// The link's aliases replace any it previously had. It returns an
+	// error wrapping ErrNameInUse if the link's name is an alias of
+	// another link, or one of its aliases is the name or alias of another
+	// link.
+	Save(link *Link) error
+
+	// Delete removes a Link and its aliases using its short name.
+	Delete(short string) error
+
+	// LoadByAlias returns the Link that alias resolves to.
+	// It returns fs.ErrNotExist if there is no such alias.
+	LoadByAlias(alias string) (*Link, error)
+
+	// GetLinksByOwner returns all Links owned by the specified owner.
+	GetLinksByOwner(owner string) ([]*Link, error)
+
+	// GetLinksByTag returns all Links with the specified tag.
+	GetLinksByTag(tag string) ([]*Link, error)
+
+	// LoadTags returns the number of links with each tag.
+	LoadTags() (map[string]int, error)
+
+	// ExpireLinks marks links whose ExpiresAt is at or before now as
+	// expired, returning the number of newly expired links.
+	ExpireLinks(now time.Time) (int, error)
+
+	// GetExpiredLinks returns all Links that have been marked expired.
+	GetExpiredLinks() ([]*Link, error)
+
+	// SearchLinks returns the Links matching all of the specified terms,
+	// most relevant first. Terms are matched case-insensitively against
+	// the start of words in link names, destinations, and descriptions.
+	SearchLinks(terms []string) ([]LinkMatch, error)
+
+	// LoadStats returns click stats for links, keyed by canonical short name.
+	LoadStats() (ClickStats, error)
+
+	// LoadStatsRecords returns all stored click stats entries, ordered by
+	// creation time and then link ID.
+	LoadStatsRecords() ([]StatsRecord, error)
+
+	// SaveStats records incremental click stats for links.
+	SaveStats(stats ClickStats) error
+
+	// SaveStatsRecords stores click stats entries as they are, such as
+	// those read from a snapshot.
+	SaveStatsRecords(records []StatsRecord) error
+
+	// DeleteStats deletes click stats for a link.
+	DeleteStats(short string) error
+
+	// CompactStats rolls up click stats entries into coarser buckets,
+	// preserving the total number of clicks for each link. Entries created
+	// before hourlyBefore are combined into one entry per link per hour,
+	// and entries created before dailyBefore into one per link per day.
+	CompactStats(hourlyBefore, dailyBefore time.Time) error
+
+	// SaveRevision records a previous version of a link.
+	// The revision's ID is assigned by the store.
+	SaveRevision(rev *Revision) error
+
+	// SaveWithRevision saves a Link and records rev, the version of the
+	// link that it replaces, in a single transaction, so that an edit is
+	// never saved without its history or recorded without being saved.
+	// The revision's ID is assigned by the store.
+	SaveWithRevision(link *Link, rev *Revision) error
+
+	// LoadRevisions returns the recorded revisions of a link, newest first.
+	LoadRevisions(short string) ([]*Revision, error)
+
+	// TrashLink moves a Link and its click stats into the trash, freeing
+	// its aliases for use by other links. Any link with the same ID already in the trash is replaced.
+	// It returns fs.ErrNotExist if the link does not exist.
+	TrashLink(short, deletedBy string, deleted time.Time) error
+
+	// LoadTrash returns all links in the trash.
+	LoadTrash() ([]*TrashedLink, error)
+
+	// RestoreLink moves a Link and its click stats out of the trash.
+	// Aliases that have since been taken by another link are dropped.
+	// It returns fs.ErrNotExist if the link is not in the trash,
+	// or fs.ErrExist if its name has since been used by another link.
+	RestoreLink(short string) error
+
+	// PurgeTrash permanently removes links deleted before the specified
+	// time from the trash, returning the number of links removed.
+	PurgeTrash(before time.Time) (int, error)
+
+	// RenameLink renames a Link, moving its click stats and revisions to
+	// the new name in a single transaction. If keepAlias is true, the old
+	// name is left behind as an alias of the renamed link.
+	// It returns fs.ErrNotExist if the link does not exist, or an error
+	// wrapping ErrNameInUse if newShort is the name or alias of another
+	// link.
+	RenameLink(short, newShort string, keepAlias bool) error
+
+	// SaveLinkHealth records the result of checking the destination of
+	// the link with ID h.ID, replacing any previous result. It does nothing
+	// if the link no longer exists.
+	SaveLinkHealth(h *LinkHealth) error
+
+	// LoadLinkHealth returns the most recent health check result of each
+	// link that has been checked, keyed by link ID.
+	LoadLinkHealth() (map[string]*LinkHealth, error)
+
+	// ImportLinks saves links in a single transaction, merging them with
+	// existing links of the same name as specified by mode, and reports
+	// what was done with each link. The previous version of each link that
+	// is replaced is recorded as a revision by editor. If any link cannot
+	// be saved, or if dryRun is true, nothing is saved.
+	ImportLinks(links []*Link, mode ImportMode, editor string, dryRun bool) (*ImportReport, error)
+}
+
+var _ LinkStore = (*SQLiteDB)(nil)
+
+// ImportMode specifies how imported links are merged with existing links of
+// the same name.
+type ImportMode string
+
+const (
+	ImportSkipExisting ImportMode = "skip-existing" // keep existing links
+	ImportOverwrite    ImportMode = "overwrite"     // replace existing links
+	ImportNewer        ImportMode = "newer"         // replace existing links last edited before the imported link
+)
+
+// ParseImportMode returns the ImportMode named s.
+// The empty string is ImportSkipExisting.
+func ParseImportMode(s string) (ImportMode, error) {
+	switch m := ImportMode(s); m {
+	case "":
+		return ImportSkipExisting, nil
+	case ImportSkipExisting, ImportOverwrite, ImportNewer:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown import mode %q; want %q, %q, or %q", s, ImportSkipExisting, ImportOverwrite, ImportNewer)
+}
+
+// ImportReport lists the short names of imported links by what was done
+// with them. On a dry run, it lists what would have been done.
+type ImportReport struct {
+	DryRun  bool
+	Created []string
+	Changed []string
+	Skipped []string
+
+	// Errors lists rows of the imported file that were rejected before
+	// the links were passed to the store.
+	Errors []ImportError `json:",omitempty"`
+}
+
+// importLink reports whether an imported link should be saved given the
+// last edit time of the existing link with the same ID, if there is one,
+// and records the decision in r.
+func (r *ImportReport) importLink(mode ImportMode, link *Link, exists bool, lastEdit time.Time) bool {
+	switch {
+	case !exists:
+		r.Created = append(r.Created, link.Short)
+		return true
+	case mode == ImportOverwrite,
+		mode == ImportNewer && link.LastEdit.Unix() > lastEdit.Unix():
+		r.Changed = append(r.Changed, link.Short)
+		return true
+	}
+	r.Skipped = append(r.Skipped, link.Short)
+	return false
+}
+
 // linkID returns the normalized ID for a link short name.
 func linkID(short string) string {
 	id := url.PathEscape(strings.ToLower(short))
@@ -39,34 +335,165 @@ func linkID(short string) string {
 	return id
 }
 
+// cleanTags returns tags lowercased, trimmed, deduplicated, and sorted,
+// in the form they are stored. It returns nil if there are no tags.
+func cleanTags(tags []string) []string {
+	var out []string
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// cleanAliases returns the aliases of the link named short in the form
+// they are stored: trimmed, deduplicated by ID, and sorted by ID. Aliases
+// with the same ID as the link itself are dropped.
+func cleanAliases(short string, aliases []string) []string {
+	seen := map[string]bool{linkID(short): true}
+	var out []string
+	for _, a := range aliases {
+		a = strings.TrimSpace(a)
+		if a == "" || seen[linkID(a)] {
+			continue
+		}
+		seen[linkID(a)] = true
+		out = append(out, a)
+	}
+	slices.SortFunc(out, func(a, b string) int {
+		return strings.Compare(linkID(a), linkID(b))
+	})
+	return out
+}
+
+// cleanCoOwners returns the co-owners of a link owned by owner in the form
+// they are stored: trimmed, deduplicated, and sorted. The owner itself is
+// dropped, as are empty entries.
+func cleanCoOwners(owner string, coOwners []string) []string {
+	var out []string
+	for _, o := range coOwners {
+		o = strings.TrimSpace(o)
+		if o != "" && o != owner && !slices.Contains(out, o) {
+			out = append(out, o)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// linkColumns are the columns of the Links and Trash tables read by scanLink.
+const linkColumns = "Short, Long, Created, LastEdit, Owner, Description, ExpiresAt, Expired, Visibility"
+
+// scanLink scans linkColumns from row into a new Link, followed by any
+// additional columns into extra.
+func scanLink(row interface{ Scan(...any) error }, extra ...any) (*Link, error) {
+	link := new(Link)
+	var created, lastEdit, expires int64
+	dest := append([]any{&link.Short, &link.Long, &created, &lastEdit, &link.Owner, &link.Description, &expires, &link.Expired, &link.Visibility}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	link.Created = time.Unix(created, 0).UTC()
+	link.LastEdit = time.Unix(lastEdit, 0).UTC()
+	if expires != 0 {
+		link.ExpiresAt = time.Unix(expires, 0).UTC()
+	}
+	return link, nil
+}
+
+// expiresUnix returns the stored form of an ExpiresAt time,
+// where 0 means the link never expires.
+func expiresUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 // SQLiteDB stores Links in a SQLite database.
+//
+// The database is opened in WAL mode, so reads run concurrently with each
+// other and with writes, each in its own transaction. Writes are serialized
+// by mu, as SQLite only allows one writer at a time.
 type SQLiteDB struct {
 	db *sql.DB
-	mu sync.RWMutex
+	mu sync.Mutex // held while writing
 
 	clock tstime.Clock // allow overriding time for tests
 }
 
-//go:embed schema.sql
-var sqlSchema string
-
 // NewSQLiteDB returns a new SQLiteDB that stores links in a SQLite database stored at f.
+// The database schema is migrated to the latest version if needed.
 func NewSQLiteDB(f string) (*SQLiteDB, error) {
-	db, err := sql.Open("sqlite", f)
+	db, err := sql.Open("sqlite", sqliteDSN(f))
 	if err != nil {
 		return nil, err
 	}
+	if sqliteInMemory(f) {
+		// each connection to an in-memory database gets its own database
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(sqliteMaxConns)
+		db.SetMaxIdleConns(sqliteMaxConns)
+	}
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
-	if _, err = db.Exec(sqlSchema); err != nil {
+	if err := migrateSQLite(context.Background(), db); err != nil {
 		return nil, err
 	}
 
 	return &SQLiteDB{db: db}, nil
 }
 
+// sqliteBusyTimeout is how long a connection waits for a lock held by
+// another connection, such as a writer in another process, before failing
+// with SQLITE_BUSY.
+const sqliteBusyTimeout = 5 * time.Second
+
+// sqliteMaxConns is the size of the SQLiteDB connection pool.
+var sqliteMaxConns = max(4, runtime.GOMAXPROCS(0))
+
+// sqliteDSN returns the data source name used to open the SQLite database
+// at f. Every connection uses WAL journaling, so that readers are not
+// blocked by a writer, and waits for locks rather than failing. Read-write
+// transactions take the write lock when they begin, so that two of them
+// cannot deadlock trying to upgrade their read locks.
+func sqliteDSN(f string) string {
+	params := url.Values{
+		"_pragma": {
+			fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeout.Milliseconds()),
+			"journal_mode(WAL)",
+		},
+		"_txlock": {"immediate"},
+	}
+	sep := "?"
+	if strings.Contains(f, "?") {
+		sep = "&"
+	}
+	return f + sep + params.Encode()
+}
+
+// sqliteInMemory reports whether f names an in-memory SQLite database.
+func sqliteInMemory(f string) bool {
+	return f == ":memory:" || strings.Contains(f, "mode=memory")
+}
+
+// read calls f in a read-only transaction, which sees a consistent snapshot
+// of the database without blocking, or being blocked by, writes.
+func (s *SQLiteDB) read(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(context.TODO(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
 // Now returns the current time.
 func (s *SQLiteDB) Now() time.Time {
 	return tstime.DefaultClock{Clock: s.clock}.Now()
@@ -76,26 +503,35 @@ func (s *SQLiteDB) Now() time.Time {
 //
 // The caller owns the returned values.
 func (s *SQLiteDB) LoadAll() ([]*Link, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.queryLinks("SELECT " + linkColumns + " FROM Links")
+}
 
+// queryLinks returns the Links selected by query, which selects
+// linkColumns, along with their details.
+func (s *SQLiteDB) queryLinks(query string, args ...any) ([]*Link, error) {
 	var links []*Link
-	rows, err := s.db.Query("SELECT Short, Long, Created, LastEdit, Owner FROM Links")
-	if err != nil {
-		return nil, err
-	}
-	for rows.Next() {
-		link := new(Link)
-		var created, lastEdit int64
-		err := rows.Scan(&link.Short, &link.Long, &created, &lastEdit, &link.Owner)
+	err := s.read(func(tx *sql.Tx) error {
+		rows, err := tx.Query(query, args...)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			link, err := scanLink(rows)
+			if err != nil {
+				return err
+			}
+			links = append(links, link)
+		}
+		if err := rows.Err(); err != nil {
+			return err
 		}
-		link.Created = time.Unix(created, 0).UTC()
-		link.LastEdit = time.Unix(lastEdit, 0).UTC()
-		links = append(links, link)
+		return attachDetails(tx, links, linkDetails, "")
+	})
+	if err != nil {
+		return nil, err
 	}
-	return links, rows.Err()
+	return links, nil
 }
 
 // Load returns a Link by its short name.
@@ -104,21 +540,31 @@ func (s *SQLiteDB) LoadAll() ([]*Link, error) {
 //
 // The caller owns the returned value.
 func (s *SQLiteDB) Load(short string) (*Link, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	var link *Link
+	err := s.read(func(tx *sql.Tx) error {
+		var err error
+		link, err = loadSQLiteLink(tx, linkID(short))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
 
-	link := new(Link)
-	var created, lastEdit int64
-	row := s.db.QueryRow("SELECT Short, Long, Created, LastEdit, Owner FROM Links WHERE ID = ?1 LIMIT 1", linkID(short))
-	err := row.Scan(&link.Short, &link.Long, &created, &lastEdit, &link.Owner)
+// loadSQLiteLink returns the Link with the specified ID, along with its details.
+// It returns fs.ErrNotExist if the link does not exist.
+func loadSQLiteLink(tx *sql.Tx, id string) (*Link, error) {
+	link, err := scanLink(tx.QueryRow("SELECT "+linkColumns+" FROM Links WHERE ID = ?1 LIMIT 1", id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = fs.ErrNotExist
 		}
 		return nil, err
 	}
-	link.Created = time.Unix(created, 0).UTC()
-	link.LastEdit = time.Unix(lastEdit, 0).UTC()
+	if err := attachDetails(tx, []*Link{link}, linkDetails, id); err != nil {
+		return nil, err
+	}
 	return link, nil
 }
 
@@ -127,7 +573,43 @@ func (s *SQLiteDB) Save(link *Link) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	result, err := s.db.Exec("INSERT OR REPLACE INTO Links (ID, Short, Long, Created, LastEdit, Owner) VALUES (?, ?, ?, ?, ?, ?)", linkID(link.Short), link.Short, link.Long, link.Created.Unix(), link.LastEdit.Unix(), link.Owner)
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveLink(tx, link); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveWithRevision saves a Link and records rev, the version of the link
+// that it replaces, in a single transaction.
+func (s *SQLiteDB) SaveWithRevision(link *Link, rev *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveLink(tx, link); err != nil {
+		return err
+	}
+	if err := saveRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveLink saves a Link and its details in tx.
+func saveLink(tx *sql.Tx, link *Link) error {
+	id := linkID(link.Short)
+	result, err := tx.Exec("INSERT OR REPLACE INTO Links (ID, "+linkColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", id, link.Short, link.Long, link.Created.Unix(), link.LastEdit.Unix(), link.Owner, link.Description, expiresUnix(link.ExpiresAt), link.Expired, link.Visibility)
 	if err != nil {
 		return err
 	}
@@ -138,6 +620,43 @@ func (s *SQLiteDB) Save(link *Link) error {
 	if rows != 1 {
 		return fmt.Errorf("expected to affect 1 row, affected %d", rows)
 	}
+	if _, err := tx.Exec("DELETE FROM LinkTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	for _, tag := range cleanTags(link.Tags) {
+		if _, err := tx.Exec("INSERT INTO LinkTags (ID, Tag) VALUES (?, ?)", id, tag); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM CoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	for _, owner := range cleanCoOwners(link.Owner, link.CoOwners) {
+		if _, err := tx.Exec("INSERT INTO CoOwners (ID, Owner) VALUES (?, ?)", id, owner); err != nil {
+			return err
+		}
+	}
+
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM Aliases WHERE ID = ? AND LinkID != ?", id, id).Scan(&n); err != nil {
+		return err
+	} else if n > 0 {
+		return fmt.Errorf("%q is an alias: %w", link.Short, ErrNameInUse)
+	}
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	for _, alias := range cleanAliases(link.Short, link.Aliases) {
+		aid := linkID(alias)
+		if err := tx.QueryRow("SELECT (SELECT count(*) FROM Links WHERE ID = ?1) + (SELECT count(*) FROM Aliases WHERE ID = ?1)", aid).Scan(&n); err != nil {
+			return err
+		} else if n > 0 {
+			return fmt.Errorf("alias %q: %w", alias, ErrNameInUse)
+		}
+		if _, err := tx.Exec("INSERT INTO Aliases (ID, Alias, LinkID) VALUES (?, ?, ?)", aid, alias, id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -146,7 +665,14 @@ func (s *SQLiteDB) Delete(short string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	result, err := s.db.Exec("DELETE FROM Links WHERE ID = ?", linkID(short))
+	id := linkID(short)
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM Links WHERE ID = ?", id)
 	if err != nil {
 		return err
 	}
@@ -157,7 +683,43 @@ func (s *SQLiteDB) Delete(short string) error {
 	if rows != 1 {
 		return fmt.Errorf("expected to affect 1 row, affected %d", rows)
 	}
-	return nil
+	if _, err := tx.Exec("DELETE FROM LinkTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM CoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM LinkHealth WHERE ID = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadByAlias returns the Link that alias resolves to.
+//
+// It returns fs.ErrNotExist if there is no such alias.
+//
+// The caller owns the returned value.
+func (s *SQLiteDB) LoadByAlias(alias string) (*Link, error) {
+	var link *Link
+	err := s.read(func(tx *sql.Tx) error {
+		var id string
+		err := tx.QueryRow("SELECT LinkID FROM Aliases WHERE ID = ?", linkID(alias)).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fs.ErrNotExist
+		} else if err != nil {
+			return err
+		}
+		link, err = loadSQLiteLink(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
 }
 
 // LoadStats returns click stats for links.
@@ -171,13 +733,11 @@ func (s *SQLiteDB) LoadStats() (ClickStats, error) {
 		linkmap[linkID(link.Short)] = link.Short
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	rows, err := s.db.Query("SELECT ID, sum(Clicks) FROM Stats GROUP BY ID")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	stats := make(map[string]int)
 	for rows.Next() {
 		var id string
@@ -214,38 +774,771 @@ func (s *SQLiteDB) SaveStats(stats ClickStats) error {
 	return tx.Commit()
 }
 
-// DeleteStats deletes click stats for a link.
-func (s *SQLiteDB) DeleteStats(short string) error {
+// SaveStatsRecords stores click stats entries as they are.
+func (s *SQLiteDB) SaveStatsRecords(records []StatsRecord) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec("DELETE FROM Stats WHERE ID = ?", linkID(short))
+	tx, err := s.db.BeginTx(context.TODO(), nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	for _, r := range records {
+		if _, err := tx.Exec("INSERT INTO Stats (ID, Created, Clicks) VALUES (?, ?, ?)", r.ID, r.Created.Unix(), r.Clicks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// GetLinksByOwner returns all Links owned by the specified owner.
-func (s *SQLiteDB) GetLinksByOwner(owner string) ([]*Link, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	var links []*Link
-	rows, err := s.db.Query("SELECT Short, Long, Created, LastEdit, Owner FROM Links WHERE LOWER(Owner) = LOWER(?)", owner)
+// LoadStatsRecords returns all stored click stats entries, ordered by
+// creation time and then link ID.
+func (s *SQLiteDB) LoadStatsRecords() ([]StatsRecord, error) {
+	rows, err := s.db.Query("SELECT ID, Created, Clicks FROM Stats ORDER BY Created, ID")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var records []StatsRecord
 	for rows.Next() {
-		link := new(Link)
-		var created, lastEdit int64
-		err := rows.Scan(&link.Short, &link.Long, &created, &lastEdit, &link.Owner)
-		if err != nil {
+		var r StatsRecord
+		var created int64
+		if err := rows.Scan(&r.ID, &created, &r.Clicks); err != nil {
 			return nil, err
 		}
-		link.Created = time.Unix(created, 0).UTC()
-		link.LastEdit = time.Unix(lastEdit, 0).UTC()
-		links = append(links, link)
+		r.Created = time.Unix(created, 0).UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// CompactStats rolls up click stats entries into hourly and daily buckets.
+func (s *SQLiteDB) CompactStats(hourlyBefore, dailyBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rollupStats(tx, hourlyBefore, time.Hour); err != nil {
+		return err
+	}
+	if err := rollupStats(tx, dailyBefore, 24*time.Hour); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollupStats combines the Stats entries created before the specified time
+// into one entry per link per bucket of the specified size.
+// Buckets that already consist of a single aligned entry are left untouched.
+func rollupStats(tx *sql.Tx, before time.Time, bucket time.Duration) error {
+	size := int64(bucket / time.Second)
+	cutoff := statsBucket(before.Unix(), size) // never split a bucket
+
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{"CREATE TEMP TABLE StatsRollup (ID TEXT, Created INTEGER, Clicks INTEGER)", nil},
+		{`INSERT INTO temp.StatsRollup (ID, Created, Clicks)
+			SELECT ID, Created - Created % ?1, sum(Clicks) FROM Stats
+			WHERE Created < ?2
+			GROUP BY 1, 2
+			HAVING count(*) > 1 OR min(Created) % ?1 != 0`, []any{size, cutoff}},
+		{"DELETE FROM Stats WHERE Created < ?2 AND (ID, Created - Created % ?1) IN (SELECT ID, Created FROM temp.StatsRollup)", []any{size, cutoff}},
+		{"INSERT INTO Stats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM temp.StatsRollup", nil},
+		{"DROP TABLE temp.StatsRollup", nil},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsBucket returns the start of the bucket of the specified size,
+// in seconds, that contains the unix time t.
+func statsBucket(t, size int64) int64 {
+	return t - t%size
+}
+
+// DeleteStats deletes click stats for a link.
+func (s *SQLiteDB) DeleteStats(short string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM Stats WHERE ID = ?", linkID(short))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetLinksByOwner returns all Links owned by the specified owner.
+func (s *SQLiteDB) GetLinksByOwner(owner string) ([]*Link, error) {
+	return s.queryLinks("SELECT "+linkColumns+" FROM Links WHERE LOWER(Owner) = LOWER(?)", owner)
+}
+
+// GetLinksByTag returns all Links with the specified tag.
+func (s *SQLiteDB) GetLinksByTag(tag string) ([]*Link, error) {
+	return s.queryLinks("SELECT "+linkColumns+" FROM Links WHERE ID IN (SELECT ID FROM LinkTags WHERE Tag = ?)", strings.ToLower(tag))
+}
+
+// LoadTags returns the number of links with each tag.
+func (s *SQLiteDB) LoadTags() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT Tag, count(*) FROM LinkTags GROUP BY Tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		tags[tag] = n
+	}
+	return tags, rows.Err()
+}
+
+// ExpireLinks marks links whose ExpiresAt is at or before now as expired,
+// returning the number of newly expired links.
+func (s *SQLiteDB) ExpireLinks(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.db.Exec("UPDATE Links SET Expired = 1 WHERE ExpiresAt > 0 AND ExpiresAt <= ? AND NOT Expired", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// GetExpiredLinks returns all Links that have been marked expired.
+func (s *SQLiteDB) GetExpiredLinks() ([]*Link, error) {
+	return s.queryLinks("SELECT " + linkColumns + " FROM Links WHERE Expired")
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// detailTables names the tables holding the tags, aliases, and co-owners
+// of links.
+type detailTables struct {
+	tags, aliases, coOwners string
+}
+
+var (
+	linkDetails  = detailTables{"LinkTags", "Aliases", "CoOwners"}
+	trashDetails = detailTables{"TrashTags", "TrashAliases", "TrashCoOwners"}
+)
+
+// attachDetails sets the Tags, Aliases, and CoOwners of each link from the
+// specified tables. If id is not empty, only the details of the link with
+// that ID are loaded.
+func attachDetails(q queryer, links []*Link, tables detailTables, id string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	where, args := "", []any(nil)
+	if id != "" {
+		where, args = " WHERE ID = ?", []any{id}
+	}
+	tags, err := loadDetails(q, "SELECT ID, Tag FROM "+tables.tags+where+" ORDER BY ID, Tag", args...)
+	if err != nil {
+		return err
+	}
+	coOwners, err := loadDetails(q, "SELECT ID, Owner FROM "+tables.coOwners+where+" ORDER BY ID, Owner", args...)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		where = " WHERE LinkID = ?"
+	}
+	aliases, err := loadDetails(q, "SELECT LinkID, Alias FROM "+tables.aliases+where+" ORDER BY LinkID, ID", args...)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		link.Tags = tags[linkID(link.Short)]
+		link.Aliases = aliases[linkID(link.Short)]
+		link.CoOwners = coOwners[linkID(link.Short)]
+	}
+	return nil
+}
+
+// loadDetails runs query, which returns pairs of link ID and value, and
+// returns the values grouped by link ID.
+func loadDetails(q queryer, query string, args ...any) (map[string][]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	details := make(map[string][]string)
+	for rows.Next() {
+		var id, v string
+		if err := rows.Scan(&id, &v); err != nil {
+			return nil, err
+		}
+		details[id] = append(details[id], v)
+	}
+	return details, rows.Err()
+}
+
+// searchRank is the FTS5 rank function used by SearchLinks. It weights
+// matches in link names highest, then descriptions, then destinations.
+const searchRank = "bm25(LinkSearch, 10.0, 10.0, 1.0, 2.0)"
+
+// SearchLinks returns the Links matching all of the specified terms,
+// most relevant first.
+//
+// The caller owns the returned values.
+func (s *SQLiteDB) SearchLinks(terms []string) ([]LinkMatch, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var matches []LinkMatch
+	err := s.read(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT "+linkColumns+", Score FROM (SELECT ID, -"+searchRank+" AS Score FROM LinkSearch WHERE LinkSearch MATCH ?) JOIN Links USING (ID) ORDER BY Score DESC", ftsQuery(terms))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var score float64
+			link, err := scanLink(rows, &score)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, LinkMatch{Link: link, Score: score})
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		links := make([]*Link, len(matches))
+		for i, m := range matches {
+			links[i] = m.Link
+		}
+		return attachDetails(tx, links, linkDetails, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// ftsQuery returns an FTS5 query matching all terms as prefixes.
+// Each term is quoted so that FTS5 operators in user input are not
+// interpreted.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SaveRevision records a previous version of a link.
+func (s *SQLiteDB) SaveRevision(rev *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveRevision records a previous version of a link in tx.
+func saveRevision(tx *sql.Tx, rev *Revision) error {
+	result, err := tx.Exec("INSERT INTO History (ID, Short, Long, Owner, Editor, Created) VALUES (?, ?, ?, ?, ?, ?)", revisionLinkID(rev), rev.Short, rev.Long, rev.Owner, rev.Editor, rev.Created.Unix())
+	if err != nil {
+		return err
+	}
+	rev.ID, err = result.LastInsertId()
+	return err
+}
+
+// LoadRevisions returns the recorded revisions of a link, newest first.
+func (s *SQLiteDB) LoadRevisions(short string) ([]*Revision, error) {
+	id := linkID(short)
+	rows, err := s.db.Query("SELECT RevID, Short, Long, Owner, Editor, Created FROM History WHERE ID = ? ORDER BY RevID DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRevisions(rows, id)
+}
+
+// scanRevisions returns the revisions of the link with the specified ID
+// selected by rows, which selects RevID, Short, Long, Owner, Editor, and
+// Created from History.
+func scanRevisions(rows *sql.Rows, id string) ([]*Revision, error) {
+	var revs []*Revision
+	for rows.Next() {
+		rev := new(Revision)
+		var created int64
+		if err := rows.Scan(&rev.ID, &rev.Short, &rev.Long, &rev.Owner, &rev.Editor, &created); err != nil {
+			return nil, err
+		}
+		rev.Created = time.Unix(created, 0).UTC()
+		if linkID(rev.Short) != id {
+			rev.LinkID = id // made before the link was renamed
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+// TrashLink moves a Link and its click stats into the trash.
+func (s *SQLiteDB) TrashLink(short, deletedBy string, deleted time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := linkID(short)
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM TrashStats WHERE ID = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT OR REPLACE INTO Trash (ID, "+linkColumns+", DeletedBy, Deleted) SELECT ID, "+linkColumns+", ?, ? FROM Links WHERE ID = ?", deletedBy, deleted.Unix(), id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fs.ErrNotExist
+	}
+	if _, err := tx.Exec("INSERT INTO TrashStats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM Stats WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Stats WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO TrashTags (ID, Tag) SELECT ID, Tag FROM LinkTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM LinkTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashAliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO TrashAliases (ID, Alias, LinkID) SELECT ID, Alias, LinkID FROM Aliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashCoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO TrashCoOwners (ID, Owner) SELECT ID, Owner FROM CoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM CoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM LinkHealth WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Links WHERE ID = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadTrash returns all links in the trash.
+//
+// The caller owns the returned values.
+func (s *SQLiteDB) LoadTrash() ([]*TrashedLink, error) {
+	var links []*TrashedLink
+	err := s.read(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT " + linkColumns + ", DeletedBy, Deleted FROM Trash")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			link := new(TrashedLink)
+			var deleted int64
+			l, err := scanLink(rows, &link.DeletedBy, &deleted)
+			if err != nil {
+				return err
+			}
+			link.Link = *l
+			link.Deleted = time.Unix(deleted, 0).UTC()
+			links = append(links, link)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		trashed := make([]*Link, len(links))
+		for i, link := range links {
+			trashed[i] = &link.Link
+		}
+		return attachDetails(tx, trashed, trashDetails, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// RestoreLink moves a Link and its click stats out of the trash.
+func (s *SQLiteDB) RestoreLink(short string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := linkID(short)
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT count(*) FROM Links WHERE ID = ?", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		err = tx.QueryRow("SELECT count(*) FROM Aliases WHERE ID = ?", id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+	}
+	if exists > 0 {
+		return fs.ErrExist
+	}
+	result, err := tx.Exec("INSERT INTO Links (ID, "+linkColumns+") SELECT ID, "+linkColumns+" FROM Trash WHERE ID = ?", id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fs.ErrNotExist
+	}
+	if _, err := tx.Exec("INSERT INTO Stats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM TrashStats WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashStats WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO LinkTags (ID, Tag) SELECT ID, Tag FROM TrashTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashTags WHERE ID = ?", id); err != nil {
+		return err
+	}
+	// aliases that have since been taken by other links are not restored
+	if _, err := tx.Exec("INSERT OR IGNORE INTO Aliases (ID, Alias, LinkID) SELECT ID, Alias, LinkID FROM TrashAliases WHERE LinkID = ? AND ID NOT IN (SELECT ID FROM Links)", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashAliases WHERE LinkID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO CoOwners (ID, Owner) SELECT ID, Owner FROM TrashCoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashCoOwners WHERE ID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Trash WHERE ID = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash permanently removes links deleted before the specified time
+// from the trash, returning the number of links removed.
+func (s *SQLiteDB) PurgeTrash(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM TrashStats WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < ?)", before.Unix()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashTags WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < ?)", before.Unix()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashAliases WHERE LinkID IN (SELECT ID FROM Trash WHERE Deleted < ?)", before.Unix()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM TrashCoOwners WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < ?)", before.Unix()); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM Trash WHERE Deleted < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), tx.Commit()
+}
+
+// RenameLink renames a Link, moving its click stats and revisions to the
+// new name.
+func (s *SQLiteDB) RenameLink(short, newShort string, keepAlias bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, newID := linkID(short), linkID(newShort)
+	var oldShort string
+	err = tx.QueryRow("SELECT Short FROM Links WHERE ID = ?", id).Scan(&oldShort)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fs.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	if newID == id {
+		// only the spelling of the name changed
+		_, err := tx.Exec("UPDATE Links SET Short = ? WHERE ID = ?", newShort, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	var n int
+	if err := tx.QueryRow("SELECT (SELECT count(*) FROM Links WHERE ID = ?1) + (SELECT count(*) FROM Aliases WHERE ID = ?1 AND LinkID != ?2)", newID, id).Scan(&n); err != nil {
+		return err
+	} else if n > 0 {
+		return fmt.Errorf("%q: %w", newShort, ErrNameInUse)
+	}
+	// the new name may have been an alias of the link itself
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE ID = ?", newID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Links SET ID = ?, Short = ? WHERE ID = ?", newID, newShort, id); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"UPDATE LinkTags SET ID = ?1 WHERE ID = ?2",
+		"UPDATE CoOwners SET ID = ?1 WHERE ID = ?2",
+		"UPDATE Aliases SET LinkID = ?1 WHERE LinkID = ?2",
+		"UPDATE Stats SET ID = ?1 WHERE ID = ?2",
+		"UPDATE History SET ID = ?1 WHERE ID = ?2",
+		"UPDATE LinkHealth SET ID = ?1 WHERE ID = ?2",
+	} {
+		if _, err := tx.Exec(query, newID, id); err != nil {
+			return err
+		}
+	}
+	if keepAlias {
+		if _, err := tx.Exec("INSERT INTO Aliases (ID, Alias, LinkID) VALUES (?, ?, ?)", id, oldShort, newID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveLinkHealth records the result of checking the destination of a link.
+func (s *SQLiteDB) SaveLinkHealth(h *LinkHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO LinkHealth (ID, Long, URL, Status, Error, Latency, Checked)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7 WHERE EXISTS (SELECT 1 FROM Links WHERE ID = ?1)`,
+		h.ID, h.Long, h.URL, h.Status, h.Error, h.Latency.Milliseconds(), h.Checked.Unix())
+	return err
+}
+
+// LoadLinkHealth returns the most recent health check result of each link.
+func (s *SQLiteDB) LoadLinkHealth() (map[string]*LinkHealth, error) {
+	rows, err := s.db.Query("SELECT ID, Long, URL, Status, Error, Latency, Checked FROM LinkHealth")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLinkHealth(rows)
+}
+
+// scanLinkHealth returns the results selected by rows, which selects ID,
+// Long, URL, Status, Error, Latency, and Checked from LinkHealth.
+func scanLinkHealth(rows *sql.Rows) (map[string]*LinkHealth, error) {
+	health := make(map[string]*LinkHealth)
+	for rows.Next() {
+		h := new(LinkHealth)
+		var latency, checked int64
+		if err := rows.Scan(&h.ID, &h.Long, &h.URL, &h.Status, &h.Error, &latency, &checked); err != nil {
+			return nil, err
+		}
+		h.Latency = time.Duration(latency) * time.Millisecond
+		h.Checked = time.Unix(checked, 0).UTC()
+		health[h.ID] = h
+	}
+	return health, rows.Err()
+}
+
+// Backup writes a consistent copy of the entire database, including stats,
+// history and trash, to a new SQLite database file at path.
+//
+// The copy is made with VACUUM INTO in a single read transaction, so other
+// requests continue to be served while it runs.
+func (s *SQLiteDB) Backup(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// RestoreSQLiteDB replaces the SQLite database at dst with a copy of the
+// database backup at src, as written by SQLiteDB.Backup.
+//
+// The backup is checked before anything is replaced: it must be an intact
+// SQLite database with a schema version no newer than this binary supports.
+// Older backups are migrated when dst is next opened with NewSQLiteDB.
+// RestoreSQLiteDB must not be called while dst is open.
+func RestoreSQLiteDB(src, dst string) error {
+	if err := checkSQLiteBackup(src); err != nil {
+		return fmt.Errorf("invalid backup %q: %w", src, err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), ".tmp-"+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name()) // no-op after a successful rename
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// A journal left over from the old database would be applied to the
+	// restored one, so remove any before replacing the database file.
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// checkSQLiteBackup reports whether the file at path is an intact SQLite
+// database that this binary can open.
+func checkSQLiteBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	version, err := dbSchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d; upgrade golink", version, schemaVersion)
+	}
+	return nil
+}
+
+// ImportLinks saves links in a single transaction, merging them with
+// existing links of the same name as specified by mode.
+func (s *SQLiteDB) ImportLinks(links []*Link, mode ImportMode, editor string, dryRun bool) (*ImportReport, error) {
+	if _, err := ParseImportMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	report := &ImportReport{DryRun: dryRun}
+	for _, link := range links {
+		prev := &Revision{Editor: editor, Created: now}
+		var lastEdit int64
+		err := tx.QueryRow("SELECT Short, Long, Owner, LastEdit FROM Links WHERE ID = ?", linkID(link.Short)).Scan(&prev.Short, &prev.Long, &prev.Owner, &lastEdit)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		exists := err == nil
+		if !report.importLink(mode, link, exists, time.Unix(lastEdit, 0)) {
+			continue
+		}
+		if err := saveLink(tx, link); err != nil {
+			return nil, fmt.Errorf("importing %q: %w", link.Short, err)
+		}
+		if exists {
+			if err := saveRevision(tx, prev); err != nil {
+				return nil, fmt.Errorf("importing %q: %w", link.Short, err)
+			}
+		}
+	}
+	if dryRun {
+		return report, nil
 	}
-	return links, rows.Err()
+	return report, tx.Commit()
 }
@@ -4,23 +4,221 @@
 package golink
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"path"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"tailscale.com/tstest"
 )
 
-// Test saving, loading, and deleting links for SQLiteDB.
-func Test_SQLiteDB_SaveLoadDeleteLinks(t *testing.T) {
+// storeTests is the conformance suite that every LinkStore implementation
+// must pass. Each test is handed a new, empty store and a clock that
+// controls the store's notion of the current time.
+var storeTests = []struct {
+	name string
+	fn   func(t *testing.T, db LinkStore, clock *tstest.Clock)
+}{
+	{"SaveLoadDeleteLinks", testSaveLoadDeleteLinks},
+	{"SaveLoadDeleteStats", testSaveLoadDeleteStats},
+	{"LoadStatsRecords", testLoadStatsRecords},
+	{"CompactStats", testCompactStats},
+	{"GetLinksByOwner", testGetLinksByOwner},
+	{"SearchLinks", testSearchLinks},
+	{"Tags", testTags},
+	{"Aliases", testAliases},
+	{"RestoreTakenAliases", testRestoreTakenAliases},
+	{"ExpireLinks", testExpireLinks},
+	{"CoOwners", testCoOwners},
+	{"Revisions", testRevisions},
+	{"SaveWithRevision", testSaveWithRevision},
+	{"Trash", testTrash},
+	{"RenameLink", testRenameLink},
+	{"LinkHealth", testLinkHealth},
+	{"ImportLinks", testImportLinks},
+}
+
+// runStoreTests runs storeTests against stores returned by newStore.
+func runStoreTests(t *testing.T, newStore func(t *testing.T, clock *tstest.Clock) LinkStore) {
+	for _, tt := range storeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := tstest.NewClock(tstest.ClockOpts{
+				Start: time.Date(2022, 06, 02, 1, 2, 3, 4, time.UTC),
+			})
+			tt.fn(t, newStore(t, clock), clock)
+		})
+	}
+}
+
+func Test_SQLiteDB(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, clock *tstest.Clock) LinkStore {
+		db, err := NewSQLiteDB(path.Join(t.TempDir(), "links.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.clock = clock
+		return db
+	})
+}
+
+func Test_MemoryDB(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, clock *tstest.Clock) LinkStore {
+		db := NewMemoryDB()
+		db.clock = clock
+		return db
+	})
+}
+
+// Test that SQLiteDB reads are neither blocked by nor see an uncommitted
+// write.
+func TestSQLiteDBConcurrentReads(t *testing.T) {
 	db, err := NewSQLiteDB(path.Join(t.TempDir(), "links.db"))
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	var mode string
+	if err := db.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q; want %q", mode, "wal")
+	}
+	if err := db.Save(&Link{Short: "who", Long: "http://who/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the write lock, as a long stats flush would
+	tx, err := db.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE Links SET Long = ? WHERE ID = ?", "http://who/new", linkID("who")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *Link)
+	go func() {
+		link, err := db.Load("who")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- link
+	}()
+	select {
+	case link := <-done:
+		if link != nil && link.Long != "http://who/" {
+			t.Errorf("Load during write = %q; want %q", link.Long, "http://who/")
+		}
+	case <-time.After(sqliteBusyTimeout / 2):
+		t.Fatal("Load blocked by write transaction")
 	}
 
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	link, err := db.Load("who")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Long != "http://who/new" {
+		t.Errorf("Load after write = %q; want %q", link.Long, "http://who/new")
+	}
+}
+
+// Test that RestoreSQLiteDB replaces a database with a backup, and leaves
+// it untouched if the backup is unusable.
+func TestRestoreSQLiteDB(t *testing.T) {
+	dir := t.TempDir()
+	newDB := func(name, short string) string {
+		f := path.Join(dir, name)
+		db, err := NewSQLiteDB(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.db.Close()
+		if err := db.Save(&Link{Short: short, Long: "http://" + short + "/"}); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	dst := newDB("links.db", "current")
+	backupDB := newDB("source.db", "restored")
+
+	// back up while the source database is open
+	src, err := NewSQLiteDB(backupDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := path.Join(dir, "backup.db")
+	if err := src.Backup(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := path.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	newer := path.Join(dir, "newer.db")
+	if err := src.Backup(context.Background(), newer); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := sql.Open("sqlite", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	loadShorts := func() []string {
+		db, err := NewSQLiteDB(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.db.Close()
+		links, err := db.LoadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var shorts []string
+		for _, link := range links {
+			shorts = append(shorts, link.Short)
+		}
+		return shorts
+	}
+
+	for _, bad := range []string{garbage, newer, path.Join(dir, "missing.db")} {
+		if err := RestoreSQLiteDB(bad, dst); err == nil {
+			t.Errorf("RestoreSQLiteDB(%q) succeeded; want error", path.Base(bad))
+		}
+	}
+	if got, want := loadShorts(), []string{"current"}; !slices.Equal(got, want) {
+		t.Errorf("links after failed restores = %q; want %q", got, want)
+	}
+
+	if err := RestoreSQLiteDB(backup, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loadShorts(), []string{"restored"}; !slices.Equal(got, want) {
+		t.Errorf("links after restore = %q; want %q", got, want)
+	}
+}
+
+// Test saving, loading, and deleting links.
+func testSaveLoadDeleteLinks(t *testing.T, db LinkStore, _ *tstest.Clock) {
 	links := []*Link{
 		{Short: "short", Long: "long"},
-		{Short: "Foo.Bar", Long: "long"},
+		{Short: "Foo.Bar", Long: "long", Description: "a link with a description"},
 	}
 
 	for _, link := range links {
@@ -37,7 +235,15 @@ func Test_SQLiteDB_SaveLoadDeleteLinks(t *testing.T) {
 		}
 	}
 
-	got, err := db.LoadAll()
+	// links are loaded by their normalized ID
+	got, err := db.Load("FOO.bar")
+	if err != nil {
+		t.Error(err)
+	} else if got.Short != "Foo.Bar" {
+		t.Errorf("db.Load(%q) got short %q, want %q", "FOO.bar", got.Short, "Foo.Bar")
+	}
+
+	all, err := db.LoadAll()
 	if err != nil {
 		t.Error(err)
 	}
@@ -45,8 +251,8 @@ func Test_SQLiteDB_SaveLoadDeleteLinks(t *testing.T) {
 	sortLinks := cmpopts.SortSlices(func(a, b *Link) bool {
 		return a.Short < b.Short
 	})
-	if !cmp.Equal(got, links, sortLinks) {
-		t.Errorf("db.LoadAll got %v, want %v", got, links)
+	if !cmp.Equal(all, links, sortLinks) {
+		t.Errorf("db.LoadAll got %v, want %v", all, links)
 	}
 
 	for _, link := range links {
@@ -54,24 +260,24 @@ func Test_SQLiteDB_SaveLoadDeleteLinks(t *testing.T) {
 			t.Error(err)
 		}
 	}
+	if err := db.Delete("short"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.Delete of missing link = %v; want error that is not fs.ErrNotExist", err)
+	} else if want := "expected to affect 1 row, affected 0"; err.Error() != want {
+		t.Errorf("db.Delete of missing link = %q; want %q", err, want)
+	}
 
-	got, err = db.LoadAll()
+	all, err = db.LoadAll()
 	if err != nil {
 		t.Error(err)
 	}
 	want := []*Link(nil)
-	if !cmp.Equal(got, want) {
-		t.Errorf("db.LoadAll got %v, want %v", got, want)
+	if !cmp.Equal(all, want) {
+		t.Errorf("db.LoadAll got %v, want %v", all, want)
 	}
 }
 
-// Test saving, loading, and deleting stats for SQLiteDB.
-func Test_SQLiteDB_SaveLoadDeleteStats(t *testing.T) {
-	db, err := NewSQLiteDB(path.Join(t.TempDir(), "links.db"))
-	if err != nil {
-		t.Error(err)
-	}
-
+// Test saving, loading, and deleting stats.
+func testSaveLoadDeleteStats(t *testing.T, db LinkStore, _ *tstest.Clock) {
 	// preload some links
 	links := []*Link{
 		{Short: "a"},
@@ -126,13 +332,117 @@ func Test_SQLiteDB_SaveLoadDeleteStats(t *testing.T) {
 	}
 }
 
-// Test GetLinksByOwner functionality
-func Test_SQLiteDB_GetLinksByOwner(t *testing.T) {
-	db, err := NewSQLiteDB(path.Join(t.TempDir(), "links.db"))
+// Test that individual stats records are returned in order.
+func testLoadStatsRecords(t *testing.T, db LinkStore, clock *tstest.Clock) {
+	if err := db.SaveStats(ClickStats{"b": 1, "A": 2}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if err := db.SaveStats(ClickStats{"a": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	want := []StatsRecord{
+		{ID: "a", Created: start, Clicks: 2},
+		{ID: "b", Created: start, Clicks: 1},
+		{ID: "a", Created: start.Add(time.Minute), Clicks: 3},
+	}
+	got, err := db.LoadStatsRecords()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("db.LoadStatsRecords diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	// saved records keep their creation time
+	restored := []StatsRecord{
+		{ID: "c", Created: start.Add(-time.Hour), Clicks: 4},
+		{ID: "a", Created: start.Add(30 * time.Second), Clicks: 5},
+	}
+	if err := db.SaveStatsRecords(restored); err != nil {
+		t.Fatal(err)
+	}
+	want = []StatsRecord{restored[0], want[0], want[1], restored[1], want[2]}
+	got, err = db.LoadStatsRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("db.LoadStatsRecords after SaveStatsRecords diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+// Test rolling up stats into hourly and daily buckets.
+func testCompactStats(t *testing.T, db LinkStore, clock *tstest.Clock) {
+	for _, link := range []*Link{{Short: "a"}, {Short: "b"}} {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
 	}
 
+	start := clock.Now()
+	saves := []struct {
+		after time.Duration
+		stats ClickStats
+	}{
+		{0, ClickStats{"a": 1, "b": 2}},
+		{time.Minute, ClickStats{"a": 3}},
+		{2 * time.Hour, ClickStats{"a": 4}},
+		{72 * time.Hour, ClickStats{"a": 5}},
+		{72*time.Hour + time.Minute, ClickStats{"a": 6}},
+		{96 * time.Hour, ClickStats{"a": 7}},
+	}
+	for _, save := range saves {
+		clock.AdvanceTo(start.Add(save.after))
+		if err := db.SaveStats(save.stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hourly rollup happens first, so that rows before the daily cutoff
+	// end up in a single daily bucket.
+	hourlyBefore := start.Add(73 * time.Hour) // 2022-06-05 02:02
+	dailyBefore := start.Add(48 * time.Hour)  // 2022-06-04 01:02
+	want := []StatsRecord{
+		{ID: "a", Created: time.Date(2022, 06, 02, 0, 0, 0, 0, time.UTC), Clicks: 8},
+		{ID: "b", Created: time.Date(2022, 06, 02, 0, 0, 0, 0, time.UTC), Clicks: 2},
+		{ID: "a", Created: time.Date(2022, 06, 05, 1, 0, 0, 0, time.UTC), Clicks: 11},
+		{ID: "a", Created: time.Date(2022, 06, 06, 1, 2, 3, 0, time.UTC), Clicks: 7},
+	}
+	for i := range 2 { // compacting is idempotent
+		if err := db.CompactStats(hourlyBefore, dailyBefore); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.LoadStatsRecords()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Errorf("run %d: db.LoadStatsRecords diff (-want +got):\n%s", i, cmp.Diff(want, got))
+		}
+	}
+
+	stats, err := db.LoadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ClickStats{"a": 26, "b": 2}); !cmp.Equal(stats, want) {
+		t.Errorf("db.LoadStats = %v; want %v", stats, want)
+	}
+
+	// the zero time disables rollup
+	if err := db.CompactStats(time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.LoadStatsRecords(); !cmp.Equal(got, want) {
+		t.Errorf("db.LoadStatsRecords after disabled rollup diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+// Test GetLinksByOwner functionality
+func testGetLinksByOwner(t *testing.T, db LinkStore, _ *tstest.Clock) {
 	// preload some links with owner
 	links := []*Link{
 		{Short: "a", Owner: "foo@bar.com"},
@@ -147,7 +457,7 @@ func Test_SQLiteDB_GetLinksByOwner(t *testing.T) {
 	want := []*Link{
 		{Short: "a", Owner: "foo@bar.com"},
 	}
-	got, err := db.GetLinksByOwner("foo@bar.com")
+	got, err := db.GetLinksByOwner("FOO@bar.com")
 	if err != nil {
 		t.Error(err)
 	}
@@ -165,3 +475,871 @@ func Test_SQLiteDB_GetLinksByOwner(t *testing.T) {
 		t.Errorf("db.GetLinksByOwner got %v; want empty slice", got)
 	}
 }
+
+// Test searching links, and that the search index follows saves and deletes.
+func testSearchLinks(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	links := []*Link{
+		{Short: "meeting-notes", Long: "http://docs/notes"},
+		{Short: "wiki", Long: "http://wiki.example.com/"},
+		{Short: "oncall", Long: "http://wiki.example.com/oncall", Description: "Who is on call this week"},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(terms ...string) []string {
+		t.Helper()
+		matches, err := db.SearchLinks(terms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var shorts []string
+		for _, m := range matches {
+			if m.Score <= 0 {
+				t.Errorf("SearchLinks(%q): %q has score %v; want > 0", terms, m.Short, m.Score)
+			}
+			shorts = append(shorts, m.Short)
+		}
+		return shorts
+	}
+
+	tests := []struct {
+		terms []string
+		want  []string
+	}{
+		{[]string{"wiki"}, []string{"wiki", "oncall"}}, // name match ranks first
+		{[]string{"WIKI", "oncall"}, []string{"oncall"}},
+		{[]string{"meet"}, []string{"meeting-notes"}},
+		{[]string{"notes"}, []string{"meeting-notes"}},
+		{[]string{"week"}, []string{"oncall"}}, // description match
+		{[]string{"nothing"}, nil},
+		{[]string{`"wiki`, "OR", "docs"}, nil}, // query syntax is not interpreted
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := search(tt.terms...); !cmp.Equal(got, tt.want) {
+			t.Errorf("SearchLinks(%q) = %q; want %q", tt.terms, got, tt.want)
+		}
+	}
+
+	// edits are reflected in the index
+	if err := db.Save(&Link{Short: "Wiki", Long: "http://intranet/"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := search("intranet"), []string{"Wiki"}; !cmp.Equal(got, want) {
+		t.Errorf("after edit: SearchLinks(intranet) = %q; want %q", got, want)
+	}
+	if got, want := search("example"), []string{"oncall"}; !cmp.Equal(got, want) {
+		t.Errorf("after edit: SearchLinks(example) = %q; want %q", got, want)
+	}
+
+	// deleted and trashed links are removed from the index
+	if err := db.Delete("oncall"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashLink("meetingnotes", "foo@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("oncall"); len(got) != 0 {
+		t.Errorf("after delete: SearchLinks(oncall) = %q; want none", got)
+	}
+	if got := search("notes"); len(got) != 0 {
+		t.Errorf("after trash: SearchLinks(notes) = %q; want none", got)
+	}
+	if err := db.RestoreLink("meeting-notes"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := search("notes"), []string{"meeting-notes"}; !cmp.Equal(got, want) {
+		t.Errorf("after restore: SearchLinks(notes) = %q; want %q", got, want)
+	}
+}
+
+// Test saving, loading, and finding links by tag.
+func testTags(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	links := []*Link{
+		{Short: "a", Tags: []string{"oncall", "Team:Infra", "oncall "}},
+		{Short: "b", Tags: []string{"team:infra"}},
+		{Short: "c"},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tags are stored lowercase, deduplicated, and sorted
+	got, err := db.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"oncall", "team:infra"}; !cmp.Equal(got.Tags, want) {
+		t.Errorf("db.Load tags = %q; want %q", got.Tags, want)
+	}
+
+	shorts := func(links []*Link) []string {
+		var s []string
+		for _, l := range links {
+			s = append(s, l.Short)
+		}
+		slices.Sort(s)
+		return s
+	}
+	byTag, err := db.GetLinksByTag("TEAM:infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shorts(byTag), []string{"a", "b"}; !cmp.Equal(got, want) {
+		t.Errorf("db.GetLinksByTag = %q; want %q", got, want)
+	}
+	all, err := db.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range all {
+		if l.Short == "b" && !cmp.Equal(l.Tags, []string{"team:infra"}) {
+			t.Errorf("db.LoadAll tags of b = %q; want %q", l.Tags, []string{"team:infra"})
+		}
+	}
+
+	counts, err := db.LoadTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"oncall": 1, "team:infra": 2}; !cmp.Equal(counts, want) {
+		t.Errorf("db.LoadTags = %v; want %v", counts, want)
+	}
+
+	// saving replaces tags, and deleting removes them
+	if err := db.Save(&Link{Short: "a", Tags: []string{"deprecated"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	counts, err = db.LoadTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"deprecated": 1}; !cmp.Equal(counts, want) {
+		t.Errorf("db.LoadTags after edits = %v; want %v", counts, want)
+	}
+
+	// tags are kept in the trash and restored with the link
+	if err := db.TrashLink("a", "foo@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := db.LoadTags(); len(counts) != 0 {
+		t.Errorf("db.LoadTags after trashing = %v; want empty", counts)
+	}
+	trash, err := db.LoadTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || !cmp.Equal(trash[0].Tags, []string{"deprecated"}) {
+		t.Errorf("db.LoadTrash = %v; want a with tag deprecated", trash)
+	}
+	if err := db.RestoreLink("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Load("a"); err != nil {
+		t.Fatal(err)
+	} else if !cmp.Equal(got.Tags, []string{"deprecated"}) {
+		t.Errorf("restored tags = %q; want %q", got.Tags, []string{"deprecated"})
+	}
+}
+
+// Test saving, resolving, and removing link aliases.
+func testAliases(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	k8s := &Link{Short: "kubernetes", Long: "http://k8s/", Aliases: []string{"kube", "K8s", "k-8-s", "Kubernetes"}}
+	if err := db.Save(k8s); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Link{Short: "wiki", Long: "http://wiki/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// aliases are deduplicated by ID, and the link's own name is dropped
+	got, err := db.Load("kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"K8s", "kube"}; !cmp.Equal(got.Aliases, want) {
+		t.Errorf("db.Load aliases = %q; want %q", got.Aliases, want)
+	}
+
+	// aliases resolve by their normalized ID, but are not links themselves
+	for _, alias := range []string{"k8s", "K-8S", "KUBE"} {
+		link, err := db.LoadByAlias(alias)
+		if err != nil {
+			t.Errorf("db.LoadByAlias(%q): %v", alias, err)
+		} else if link.Short != "kubernetes" {
+			t.Errorf("db.LoadByAlias(%q) = %q; want %q", alias, link.Short, "kubernetes")
+		}
+	}
+	if _, err := db.LoadByAlias("wiki"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.LoadByAlias of link name = %v; want fs.ErrNotExist", err)
+	}
+	if _, err := db.Load("k8s"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.Load of alias = %v; want fs.ErrNotExist", err)
+	}
+
+	// names and aliases may only be used once
+	conflicts := []*Link{
+		{Short: "k8s"}, // link named after an alias
+		{Short: "other", Aliases: []string{"Kube"}},    // alias of another link
+		{Short: "other", Aliases: []string{"w-i-k-i"}}, // alias named after a link
+	}
+	for _, link := range conflicts {
+		if err := db.Save(link); !errors.Is(err, ErrNameInUse) {
+			t.Errorf("db.Save(%+v) = %v; want ErrNameInUse", link, err)
+		}
+	}
+	if _, err := db.Load("other"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("failed save created link: %v", err)
+	}
+
+	// saving replaces aliases, freeing removed ones
+	if err := db.Save(&Link{Short: "kubernetes", Long: "http://k8s/", Aliases: []string{"k8s"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LoadByAlias("kube"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.LoadByAlias of removed alias = %v; want fs.ErrNotExist", err)
+	}
+	if err := db.Save(&Link{Short: "kube"}); err != nil {
+		t.Errorf("saving link named after removed alias: %v", err)
+	}
+
+	// trashing frees aliases, and restoring brings back those still free
+	if err := db.TrashLink("kubernetes", "foo@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LoadByAlias("k8s"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.LoadByAlias after trash = %v; want fs.ErrNotExist", err)
+	}
+	trash, err := db.LoadTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || !cmp.Equal(trash[0].Aliases, []string{"k8s"}) {
+		t.Errorf("db.LoadTrash = %v; want kubernetes with alias k8s", trash)
+	}
+	if err := db.RestoreLink("kubernetes"); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := db.LoadByAlias("k8s"); err != nil || link.Short != "kubernetes" {
+		t.Errorf("db.LoadByAlias after restore = %v, %v; want kubernetes", link, err)
+	}
+
+	// deleting removes aliases
+	if err := db.Delete("kubernetes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LoadByAlias("k8s"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.LoadByAlias after delete = %v; want fs.ErrNotExist", err)
+	}
+}
+
+// Test that restoring a link from the trash skips aliases taken since.
+func testRestoreTakenAliases(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	if err := db.Save(&Link{Short: "a", Aliases: []string{"b", "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashLink("a", "foo@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Link{Short: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Link{Short: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Link{Short: "d", Aliases: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreLink("a"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("db.RestoreLink over alias = %v; want fs.ErrExist", err)
+	}
+	if err := db.Save(&Link{Short: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreLink("a"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c"}; !cmp.Equal(got.Aliases, want) {
+		t.Errorf("restored aliases = %q; want %q", got.Aliases, want)
+	}
+}
+
+// Test marking links expired once they pass their expiration time.
+func testExpireLinks(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	links := []*Link{
+		{Short: "forever"},
+		{Short: "soon", ExpiresAt: start.Add(time.Hour)},
+		{Short: "later", ExpiresAt: start.Add(24 * time.Hour)},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := db.Load("soon"); err != nil {
+		t.Fatal(err)
+	} else if !cmp.Equal(got, links[1]) {
+		t.Errorf("db.Load diff (-want +got):\n%s", cmp.Diff(links[1], got))
+	}
+
+	expire := func(now time.Time, wantN int, wantShorts ...string) {
+		t.Helper()
+		n, err := db.ExpireLinks(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != wantN {
+			t.Errorf("db.ExpireLinks(%v) = %d; want %d", now, n, wantN)
+		}
+		expired, err := db.GetExpiredLinks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, link := range expired {
+			if !link.Expired {
+				t.Errorf("expired link %q has Expired = false", link.Short)
+			}
+			got = append(got, link.Short)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, wantShorts) {
+			t.Errorf("db.GetExpiredLinks = %q; want %q", got, wantShorts)
+		}
+	}
+	expire(start, 0)
+	expire(start.Add(time.Hour), 1, "soon")
+	expire(start.Add(2*time.Hour), 0, "soon") // already marked
+	expire(start.Add(48*time.Hour), 1, "later", "soon")
+
+	// renewing a link clears the mark
+	if err := db.Save(&Link{Short: "soon", ExpiresAt: start.Add(72 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	expire(start.Add(48*time.Hour), 0, "later")
+}
+
+// Test saving and replacing the co-owners of links.
+func testCoOwners(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	link := &Link{
+		Short:    "a",
+		Owner:    "foo@example.com",
+		CoOwners: []string{"group:infra", " bar@example.com", "foo@example.com", "group:infra"},
+	}
+	if err := db.Save(link); err != nil {
+		t.Fatal(err)
+	}
+
+	// co-owners are stored deduplicated and sorted, without the owner
+	got, err := db.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bar@example.com", "group:infra"}; !cmp.Equal(got.CoOwners, want) {
+		t.Errorf("db.Load co-owners = %q; want %q", got.CoOwners, want)
+	}
+
+	got.CoOwners = []string{"baz@example.com"}
+	if err := db.Save(got); err != nil {
+		t.Fatal(err)
+	}
+	all, err := db.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || !cmp.Equal(all[0].CoOwners, []string{"baz@example.com"}) {
+		t.Errorf("db.LoadAll after replacing co-owners = %v; want co-owners %q", all, "baz@example.com")
+	}
+
+	got.CoOwners = nil
+	if err := db.Save(got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.Load("a"); got.CoOwners != nil {
+		t.Errorf("db.Load after removing co-owners = %q; want none", got.CoOwners)
+	}
+}
+
+// Test saving and loading link revisions.
+func testRevisions(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	revs := []*Revision{
+		{Short: "a", Long: "http://a/1", Owner: "foo@example.com", Editor: "foo@example.com", Created: start},
+		{Short: "b", Long: "http://b/1", Owner: "bar@example.com", Editor: "bar@example.com", Created: start},
+		{Short: "A", Long: "http://a/2", Owner: "foo@example.com", Editor: "admin@example.com", Created: start.Add(time.Minute)},
+	}
+	for _, rev := range revs {
+		if err := db.SaveRevision(rev); err != nil {
+			t.Fatal(err)
+		}
+		if rev.ID == 0 {
+			t.Errorf("db.SaveRevision did not assign an ID to %v", rev)
+		}
+	}
+
+	// revisions are loaded by normalized ID, newest first
+	want := []*Revision{revs[2], revs[0]}
+	got, err := db.LoadRevisions("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("db.LoadRevisions diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	got, err = db.LoadRevisions("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("db.LoadRevisions(%q) = %v; want empty", "c", got)
+	}
+}
+
+// Test saving an edit along with the version it replaces.
+func testSaveWithRevision(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	created := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	for _, link := range []*Link{
+		{Short: "a", Long: "http://a/1", Owner: "foo@example.com", Created: created, LastEdit: created},
+		{Short: "b", Long: "http://b/", Created: created, LastEdit: created},
+	} {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edited := created.Add(time.Minute)
+	link := &Link{Short: "a", Long: "http://a/2", Owner: "foo@example.com", Created: created, LastEdit: edited}
+	rev := &Revision{Short: "a", Long: "http://a/1", Owner: "foo@example.com", Editor: "admin@example.com", Created: edited}
+	if err := db.SaveWithRevision(link, rev); err != nil {
+		t.Fatal(err)
+	}
+	if rev.ID == 0 {
+		t.Errorf("db.SaveWithRevision did not assign an ID to %v", rev)
+	}
+	if got, err := db.Load("a"); err != nil || got.Long != "http://a/2" {
+		t.Errorf("db.Load after db.SaveWithRevision = %v, %v; want long %q", got, err, "http://a/2")
+	}
+	if revs, err := db.LoadRevisions("a"); err != nil || !cmp.Equal(revs, []*Revision{rev}) {
+		t.Errorf("db.LoadRevisions after db.SaveWithRevision = %v, %v; want %v", revs, err, []*Revision{rev})
+	}
+
+	// an edit that cannot be saved is not recorded
+	link = &Link{Short: "a", Long: "http://a/3", Owner: "foo@example.com", Aliases: []string{"b"}, Created: created, LastEdit: edited}
+	failed := &Revision{Short: "a", Long: "http://a/2", Owner: "foo@example.com", Editor: "admin@example.com", Created: edited}
+	if err := db.SaveWithRevision(link, failed); !errors.Is(err, ErrNameInUse) {
+		t.Errorf("db.SaveWithRevision with taken alias = %v; want ErrNameInUse", err)
+	}
+	if got, err := db.Load("a"); err != nil || got.Long != "http://a/2" {
+		t.Errorf("db.Load after failed db.SaveWithRevision = %v, %v; want long %q", got, err, "http://a/2")
+	}
+	if revs, err := db.LoadRevisions("a"); err != nil || len(revs) != 1 {
+		t.Errorf("db.LoadRevisions after failed db.SaveWithRevision = %v, %v; want 1 revision", revs, err)
+	}
+}
+
+// Test moving links and their stats into and out of the trash.
+func testTrash(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	links := []*Link{
+		{Short: "a", Long: "http://a/", Owner: "foo@example.com", Description: "the letter a", Visibility: "owner"},
+		{Short: "B-c", Long: "http://bc/", Owner: "bar@example.com", CoOwners: []string{"group:infra", " foo@example.com", "bar@example.com", "foo@example.com"}},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// co-owners are cleaned as when the link is loaded
+	links[1].CoOwners = []string{"foo@example.com", "group:infra"}
+	if err := db.SaveStats(ClickStats{"a": 1, "b-c": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	if err := db.TrashLink("A", "admin@example.com", start); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashLink("bc", "bar@example.com", start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashLink("a", "admin@example.com", start); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.TrashLink of missing link = %v; want fs.ErrNotExist", err)
+	}
+
+	if _, err := db.Load("a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.Load of trashed link = %v; want fs.ErrNotExist", err)
+	}
+	if stats, _ := db.LoadStats(); len(stats) != 0 {
+		t.Errorf("db.LoadStats after trashing = %v; want empty", stats)
+	}
+
+	want := []*TrashedLink{
+		{Link: *links[0], DeletedBy: "admin@example.com", Deleted: start},
+		{Link: *links[1], DeletedBy: "bar@example.com", Deleted: start.Add(time.Hour)},
+	}
+	got, err := db.LoadTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortTrash := cmpopts.SortSlices(func(a, b *TrashedLink) bool {
+		return a.Short < b.Short
+	})
+	if !cmp.Equal(got, want, sortTrash) {
+		t.Errorf("db.LoadTrash diff (-want +got):\n%s", cmp.Diff(want, got, sortTrash))
+	}
+
+	// restoring brings back the link and its stats
+	if err := db.RestoreLink("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Load("a"); err != nil {
+		t.Errorf("db.Load of restored link: %v", err)
+	} else if !cmp.Equal(got, links[0]) {
+		t.Errorf("db.Load of restored link diff (-want +got):\n%s", cmp.Diff(links[0], got))
+	}
+	if stats, _ := db.LoadStats(); !cmp.Equal(stats, ClickStats{"a": 1}) {
+		t.Errorf("db.LoadStats after restore = %v; want %v", stats, ClickStats{"a": 1})
+	}
+	if err := db.RestoreLink("does-not-exist"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.RestoreLink of link not in trash = %v; want fs.ErrNotExist", err)
+	}
+
+	// restoring fails if the name has been reused
+	if err := db.Save(&Link{Short: "bc", Long: "http://new/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreLink("B-c"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("db.RestoreLink over existing link = %v; want fs.ErrExist", err)
+	}
+	if err := db.Delete("bc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// purging only removes links deleted before the cutoff
+	n, err := db.PurgeTrash(start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("db.PurgeTrash removed %d links; want 0", n)
+	}
+	n, err = db.PurgeTrash(start.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("db.PurgeTrash removed %d links; want 1", n)
+	}
+	if got, _ := db.LoadTrash(); len(got) != 0 {
+		t.Errorf("db.LoadTrash after purge = %v; want empty", got)
+	}
+	if err := db.RestoreLink("bc"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.RestoreLink of purged link = %v; want fs.ErrNotExist", err)
+	}
+}
+
+// Test renaming links along with their stats, history, and aliases.
+func testRenameLink(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	start := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	link := &Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Tags: []string{"people"}, Aliases: []string{"whois", "w"}, CoOwners: []string{"bar@example.com"}}
+	for _, l := range []*Link{link, {Short: "other", Long: "http://other/", Aliases: []string{"taken"}}} {
+		if err := db.Save(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveStats(ClickStats{"who": 3, "other": 1}); err != nil {
+		t.Fatal(err)
+	}
+	rev := &Revision{Short: "who", Long: "http://who/old", Owner: "foo@example.com", Editor: "foo@example.com", Created: start}
+	if err := db.SaveRevision(rev); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"other", "Taken"} {
+		if err := db.RenameLink("who", name, false); !errors.Is(err, ErrNameInUse) {
+			t.Errorf("db.RenameLink to %q = %v; want ErrNameInUse", name, err)
+		}
+	}
+	if err := db.RenameLink("nobody", "somebody", false); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.RenameLink of missing link = %v; want fs.ErrNotExist", err)
+	}
+
+	// renaming to one of the link's own aliases replaces the alias
+	if err := db.RenameLink("WHO", "who-is", true); err != nil {
+		t.Fatal(err)
+	}
+	want := &Link{Short: "who-is", Long: "http://who/", Owner: "foo@example.com", Tags: []string{"people"}, Aliases: []string{"w", "who"}, CoOwners: []string{"bar@example.com"}}
+	got, err := db.Load("whois")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("db.Load of renamed link diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got, err := db.LoadByAlias("who"); err != nil || got.Short != "who-is" {
+		t.Errorf("db.LoadByAlias of old name = %v, %v; want %q", got, err, "who-is")
+	}
+	if _, err := db.Load("who"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.Load of old name = %v; want fs.ErrNotExist", err)
+	}
+	if stats, _ := db.LoadStats(); !cmp.Equal(stats, ClickStats{"who-is": 3, "other": 1}) {
+		t.Errorf("db.LoadStats after rename = %v; want %v", stats, ClickStats{"who-is": 3, "other": 1})
+	}
+	if tagged, _ := db.GetLinksByTag("people"); len(tagged) != 1 || tagged[0].Short != "who-is" {
+		t.Errorf("db.GetLinksByTag after rename = %v; want %q", tagged, "who-is")
+	}
+	if matches, _ := db.SearchLinks([]string{"who"}); len(matches) != 1 || matches[0].Short != "who-is" {
+		t.Errorf("db.SearchLinks after rename = %v; want %q", matches, "who-is")
+	}
+
+	// revisions keep the name the link had when they were made
+	wantRevs := []*Revision{{ID: rev.ID, Short: "who", Long: "http://who/old", Owner: "foo@example.com", Editor: "foo@example.com", Created: start, LinkID: "whois"}}
+	revs, err := db.LoadRevisions("who-is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(revs, wantRevs) {
+		t.Errorf("db.LoadRevisions after rename diff (-want +got):\n%s", cmp.Diff(wantRevs, revs))
+	}
+	if revs, _ := db.LoadRevisions("who"); len(revs) != 0 {
+		t.Errorf("db.LoadRevisions of old name = %v; want empty", revs)
+	}
+
+	// without keepAlias, the old name is freed
+	if err := db.RenameLink("who-is", "people", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LoadByAlias("who-is"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("db.LoadByAlias of old name = %v; want fs.ErrNotExist", err)
+	}
+	if got, err := db.LoadByAlias("who"); err != nil || got.Short != "people" {
+		t.Errorf("db.LoadByAlias of earlier alias = %v, %v; want %q", got, err, "people")
+	}
+
+	// changing only the spelling of the name keeps everything in place
+	if err := db.RenameLink("people", "People", true); err != nil {
+		t.Fatal(err)
+	}
+	got, err = db.Load("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"w", "who"}; got.Short != "People" || !cmp.Equal(got.Aliases, want) {
+		t.Errorf("db.Load after respelling = %q with aliases %q; want %q with aliases %q", got.Short, got.Aliases, "People", want)
+	}
+	if stats, _ := db.LoadStats(); !cmp.Equal(stats, ClickStats{"People": 3, "other": 1}) {
+		t.Errorf("db.LoadStats after respelling = %v; want %v", stats, ClickStats{"People": 3, "other": 1})
+	}
+}
+
+func testLinkHealth(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	checked := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	for _, link := range []*Link{
+		{Short: "who", Long: "http://who/"},
+		{Short: "wiki", Long: "http://wiki/"},
+	} {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, h := range []*LinkHealth{
+		{ID: "who", Long: "http://who/", URL: "http://who/", Status: 404, Latency: 25 * time.Millisecond, Checked: checked.Add(-time.Hour)},
+		{ID: "who", Long: "http://who/", URL: "http://who/", Status: 200, Latency: 20 * time.Millisecond, Checked: checked},
+		{ID: "wiki", Long: "http://wiki/", URL: "http://wiki/", Error: "connection refused", Checked: checked},
+		{ID: "deleted", Long: "http://deleted/", URL: "http://deleted/", Status: 200, Checked: checked},
+	} {
+		if err := db.SaveLinkHealth(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the latest result of links that exist is kept
+	want := map[string]*LinkHealth{
+		"who":  {ID: "who", Long: "http://who/", URL: "http://who/", Status: 200, Latency: 20 * time.Millisecond, Checked: checked},
+		"wiki": {ID: "wiki", Long: "http://wiki/", URL: "http://wiki/", Error: "connection refused", Checked: checked},
+	}
+	got, err := db.LoadLinkHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("db.LoadLinkHealth diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	// results move with renamed links and are dropped with deleted ones
+	if err := db.RenameLink("who", "whom", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashLink("wiki", "foo@example.com", checked); err != nil {
+		t.Fatal(err)
+	}
+	got, err = db.LoadLinkHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := got["whom"]; len(got) != 1 || h == nil || h.ID != "whom" || h.Status != 200 {
+		t.Errorf("db.LoadLinkHealth after rename and trash = %v; want only the result for whom", got)
+	}
+	if err := db.Delete("whom"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.LoadLinkHealth(); len(got) != 0 {
+		t.Errorf("db.LoadLinkHealth after delete = %v; want empty", got)
+	}
+}
+
+// Test importing links with each merge mode, and that failed and dry-run
+// imports leave the store unchanged.
+func testImportLinks(t *testing.T, db LinkStore, _ *tstest.Clock) {
+	older := time.Date(2022, 06, 01, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(24 * time.Hour)
+
+	longs := func() map[string]string {
+		t.Helper()
+		links, err := db.LoadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, link := range links {
+			m[link.Short] = link.Long
+		}
+		return m
+	}
+	reset := func() {
+		t.Helper()
+		links, err := db.LoadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range links {
+			if err := db.Delete(link.Short); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, link := range []*Link{
+			{Short: "stale", Long: "http://stale/old", LastEdit: older},
+			{Short: "fresh", Long: "http://fresh/old", LastEdit: newer, Aliases: []string{"f"}},
+		} {
+			if err := db.Save(link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	imported := []*Link{
+		{Short: "stale", Long: "http://stale/new", LastEdit: newer},
+		{Short: "Fresh", Long: "http://fresh/new", LastEdit: older},
+		{Short: "created", Long: "http://created/", LastEdit: older},
+	}
+	initial := map[string]string{"stale": "http://stale/old", "fresh": "http://fresh/old"}
+
+	tests := []struct {
+		mode  ImportMode
+		want  *ImportReport
+		longs map[string]string
+	}{
+		{
+			mode:  ImportSkipExisting,
+			want:  &ImportReport{Created: []string{"created"}, Skipped: []string{"stale", "Fresh"}},
+			longs: map[string]string{"stale": "http://stale/old", "fresh": "http://fresh/old", "created": "http://created/"},
+		},
+		{
+			mode:  ImportOverwrite,
+			want:  &ImportReport{Created: []string{"created"}, Changed: []string{"stale", "Fresh"}},
+			longs: map[string]string{"stale": "http://stale/new", "Fresh": "http://fresh/new", "created": "http://created/"},
+		},
+		{
+			mode:  ImportNewer,
+			want:  &ImportReport{Created: []string{"created"}, Changed: []string{"stale"}, Skipped: []string{"Fresh"}},
+			longs: map[string]string{"stale": "http://stale/new", "fresh": "http://fresh/old", "created": "http://created/"},
+		},
+	}
+	// revisions returns the recorded revisions of the stale link.
+	revisions := func() []*Revision {
+		t.Helper()
+		revs, err := db.LoadRevisions("stale")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revs
+	}
+	for _, tt := range tests {
+		for _, dryRun := range []bool{true, false} {
+			reset()
+			before := len(revisions())
+			got, err := db.ImportLinks(imported, tt.mode, "admin@example.com", dryRun)
+			if err != nil {
+				t.Fatalf("db.ImportLinks(%q, dryRun=%v): %v", tt.mode, dryRun, err)
+			}
+			want := *tt.want
+			want.DryRun = dryRun
+			if diff := cmp.Diff(&want, got); diff != "" {
+				t.Errorf("db.ImportLinks(%q, dryRun=%v) report diff (-want +got):\n%s", tt.mode, dryRun, diff)
+			}
+			wantLongs := tt.longs
+			if dryRun {
+				wantLongs = initial
+			}
+			if diff := cmp.Diff(wantLongs, longs()); diff != "" {
+				t.Errorf("links after db.ImportLinks(%q, dryRun=%v) diff (-want +got):\n%s", tt.mode, dryRun, diff)
+			}
+
+			// the replaced version of a changed link is kept in its history
+			revs := revisions()
+			if dryRun || !slices.Contains(want.Changed, "stale") {
+				if len(revs) != before {
+					t.Errorf("db.ImportLinks(%q, dryRun=%v) recorded %d revisions; want none", tt.mode, dryRun, len(revs)-before)
+				}
+				continue
+			}
+			if len(revs) != before+1 {
+				t.Fatalf("db.ImportLinks(%q) recorded %d revisions; want 1", tt.mode, len(revs)-before)
+			}
+			if rev := revs[0]; rev.Short != "stale" || rev.Long != "http://stale/old" || rev.Editor != "admin@example.com" {
+				t.Errorf("db.ImportLinks(%q) recorded revision %+v; want old long by admin", tt.mode, rev)
+			}
+		}
+	}
+
+	// a link that cannot be saved fails the whole import
+	reset()
+	conflict := []*Link{
+		{Short: "created", Long: "http://created/"},
+		{Short: "stale", Long: "http://stale/new"},
+		{Short: "other", Aliases: []string{"F"}},
+	}
+	before := len(revisions())
+	if _, err := db.ImportLinks(conflict, ImportOverwrite, "admin@example.com", false); !errors.Is(err, ErrNameInUse) {
+		t.Errorf("db.ImportLinks with taken alias = %v; want ErrNameInUse", err)
+	}
+	if n := len(revisions()) - before; n != 0 {
+		t.Errorf("failed import recorded %d revisions; want none", n)
+	}
+	if diff := cmp.Diff(initial, longs()); diff != "" {
+		t.Errorf("links after failed import diff (-want +got):\n%s", diff)
+	}
+	if link, err := db.LoadByAlias("f"); err != nil || link.Short != "fresh" {
+		t.Errorf("db.LoadByAlias after failed import = %v, %v; want %q", link, err, "fresh")
+	}
+
+	if _, err := db.ImportLinks(imported, "merge", "", false); err == nil {
+		t.Error("db.ImportLinks with unknown mode succeeded; want error")
+	}
+}
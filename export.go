@@ -0,0 +1,209 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Export formats written by serveExport.
+const (
+	exportJSONL     = "jsonl"     // golink snapshots, read by restoreSnapshot; see snapshotVersion
+	exportCSV       = "csv"       // spreadsheets
+	exportBookmarks = "bookmarks" // Netscape bookmark files, for importing into browsers
+	exportYAML      = "yaml"
+)
+
+// exportMediaTypes maps the media types accepted by serveExport's content
+// negotiation to export formats. Bookmarks are HTML, which browsers accept
+// for every page, so they must be requested with the format parameter.
+var exportMediaTypes = map[string]string{
+	"application/jsonl":    exportJSONL,
+	"application/x-ndjson": exportJSONL,
+	"text/csv":             exportCSV,
+	"application/yaml":     exportYAML,
+	"application/x-yaml":   exportYAML,
+	"text/yaml":            exportYAML,
+}
+
+// exportHeaders are the Content-Type and download file name of each export
+// format. JSON lines are served inline, as they always have been.
+var exportHeaders = map[string]struct{ contentType, filename string }{
+	exportCSV:       {"text/csv; charset=utf-8", "golinks.csv"},
+	exportBookmarks: {"text/html; charset=utf-8", "golinks-bookmarks.html"},
+	exportYAML:      {"application/yaml; charset=utf-8", "golinks.yaml"},
+}
+
+// exportFormat returns the format requested by r, either by the "format"
+// parameter or else by its Accept header, defaulting to JSON lines.
+func exportFormat(r *http.Request) (string, error) {
+	if format := r.FormValue("format"); format != "" {
+		switch format {
+		case exportJSONL, exportCSV, exportBookmarks, exportYAML:
+			return format, nil
+		}
+		return "", fmt.Errorf("unknown export format %q; want %q, %q, %q, or %q", format, exportJSONL, exportCSV, exportBookmarks, exportYAML)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if format, ok := exportMediaTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+	return exportJSONL, nil
+}
+
+// writeExport writes links sorted by short name to w in format. Bookmarks
+// link to go links at hostname where needed. If
+// opts.Clicks is non-nil, the total number of clicks for each link is
+// included. Stats and revisions are only written to JSON lines snapshots.
+func writeExport(w io.Writer, format, hostname string, links []*Link, opts snapshotOptions) error {
+	links = sortedLinks(links)
+	switch format {
+	case exportCSV:
+		return writeCSV(w, links, opts.Clicks)
+	case exportBookmarks:
+		return writeBookmarks(w, hostname, links)
+	case exportYAML:
+		return writeYAML(w, links, opts.Clicks)
+	}
+	return writeSnapshot(w, links, opts)
+}
+
+// writeCSV writes links as CSV with a header row. Tags, aliases, and
+// co-owners are comma-separated within their fields, and times are in RFC
+// 3339 format.
+func writeCSV(w io.Writer, links []*Link, clicks ClickStats) error {
+	cw := csv.NewWriter(w)
+	header := []string{"short", "long", "owner", "description", "tags", "aliases", "coowners", "created", "lastedit", "expires", "visibility"}
+	if clicks != nil {
+		header = append(header, "clicks")
+	}
+	cw.Write(header)
+	for _, link := range links {
+		var expires string
+		if !link.ExpiresAt.IsZero() {
+			expires = link.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		record := []string{
+			link.Short,
+			link.Long,
+			link.Owner,
+			link.Description,
+			strings.Join(link.Tags, ","),
+			strings.Join(link.Aliases, ","),
+			strings.Join(link.CoOwners, ","),
+			link.Created.UTC().Format(time.RFC3339),
+			link.LastEdit.UTC().Format(time.RFC3339),
+			expires,
+			link.Visibility,
+		}
+		if clicks != nil {
+			record = append(record, strconv.Itoa(clicks[link.Short]))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeBookmarks writes links as a Netscape bookmark file, which browsers
+// can import. Each link's short name is its bookmark keyword, so typing it
+// in the address bar goes straight to the destination. Links whose
+// destination is a template bookmark the go link at hostname instead.
+func writeBookmarks(w io.Writer, hostname string, links []*Link) error {
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+	fmt.Fprintf(&sb, "    <DT><H3>%s</H3>\n    <DL><p>\n", html.EscapeString(hostname))
+	for _, link := range links {
+		href := link.Long
+		if strings.Contains(href, "{{") {
+			href = "http://" + hostname + "/" + link.Short
+		}
+		title := link.Description
+		if title == "" {
+			title = link.Short
+		}
+		fmt.Fprintf(&sb, `        <DT><A HREF="%s" ADD_DATE="%d" LAST_MODIFIED="%d" SHORTCUTURL="%s"`,
+			html.EscapeString(href), link.Created.Unix(), link.LastEdit.Unix(), html.EscapeString(link.Short))
+		if len(link.Tags) > 0 {
+			fmt.Fprintf(&sb, ` TAGS="%s"`, html.EscapeString(strings.Join(link.Tags, ",")))
+		}
+		fmt.Fprintf(&sb, ">%s</A>\n", html.EscapeString(title))
+	}
+	sb.WriteString("    </DL><p>\n</DL><p>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// yamlLink is the YAML form of a Link.
+type yamlLink struct {
+	Short       string    `yaml:"short"`
+	Long        string    `yaml:"long"`
+	Owner       string    `yaml:"owner,omitempty"`
+	CoOwners    []string  `yaml:"coowners,omitempty"`
+	Description string    `yaml:"description,omitempty"`
+	Tags        []string  `yaml:"tags,omitempty"`
+	Aliases     []string  `yaml:"aliases,omitempty"`
+	Created     time.Time `yaml:"created"`
+	LastEdit    time.Time `yaml:"lastedit"`
+	ExpiresAt   string    `yaml:"expires,omitempty"`
+	Expired     bool      `yaml:"expired,omitempty"`
+	Visibility  string    `yaml:"visibility,omitempty"`
+	Clicks      *int      `yaml:"clicks,omitempty"`
+}
+
+// writeYAML writes links as a YAML list.
+func writeYAML(w io.Writer, links []*Link, clicks ClickStats) error {
+	out := make([]yamlLink, len(links))
+	for i, link := range links {
+		out[i] = yamlLink{
+			Short:       link.Short,
+			Long:        link.Long,
+			Owner:       link.Owner,
+			CoOwners:    link.CoOwners,
+			Description: link.Description,
+			Tags:        link.Tags,
+			Aliases:     link.Aliases,
+			Created:     link.Created.UTC(),
+			LastEdit:    link.LastEdit.UTC(),
+			Expired:     link.Expired,
+			Visibility:  link.Visibility,
+		}
+		if !link.ExpiresAt.IsZero() {
+			out[i].ExpiresAt = link.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		if clicks != nil {
+			n := clicks[link.Short]
+			out[i].Clicks = &n
+		}
+	}
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.yaml.in/yaml/v2"
+)
+
+func TestServeExportFormats(t *testing.T) {
+	created := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Description: "Find people", Tags: []string{"people"}, Created: created, LastEdit: created})
+	db.Save(&Link{Short: "search", Long: "http://search/?q={{.Path}}", Owner: "foo@example.com", Created: created, LastEdit: created})
+	s.stats.dirty = ClickStats{"who": 3}
+
+	export := func(t *testing.T, query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/.export"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("negotiation", func(t *testing.T) {
+		tests := []struct {
+			query, accept   string
+			wantContentType string
+		}{
+			{"", "", ""},
+			{"", "text/html,application/xhtml+xml,*/*;q=0.8", ""}, // browsers get the default
+			{"", "text/csv", "text/csv; charset=utf-8"},
+			{"", "application/yaml, text/csv", "application/yaml; charset=utf-8"},
+			{"?format=csv", "application/yaml", "text/csv; charset=utf-8"},
+			{"?format=bookmarks", "", "text/html; charset=utf-8"},
+			{"?format=jsonl", "text/csv", ""},
+		}
+		for _, tt := range tests {
+			w := export(t, tt.query, tt.accept)
+			if w.Code != http.StatusOK {
+				t.Fatalf("serveExport(%q, Accept %q) = %d; want %d", tt.query, tt.accept, w.Code, http.StatusOK)
+			}
+			got := w.Header().Get("Content-Type")
+			if tt.wantContentType == "" {
+				if strings.Contains(got, "csv") || strings.Contains(got, "yaml") || strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+					t.Errorf("serveExport(%q, Accept %q) Content-Type = %q; want JSON lines", tt.query, tt.accept, got)
+				}
+			} else if got != tt.wantContentType {
+				t.Errorf("serveExport(%q, Accept %q) Content-Type = %q; want %q", tt.query, tt.accept, got, tt.wantContentType)
+			}
+		}
+		if w := export(t, "?format=xml", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("serveExport(format=xml) = %d; want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("jsonl clicks", func(t *testing.T) {
+		w := export(t, "?clicks=true", "")
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 3 || !strings.HasSuffix(lines[1], `,"Clicks":0}`) || !strings.HasSuffix(lines[2], `,"Clicks":3}`) {
+			t.Errorf("serveExport(clicks) = %s; want Clicks of 0 and 3", w.Body)
+		}
+		// snapshots with clicks can still be imported
+		rows, errs, err := readImport(importJSONL, w.Body)
+		if err != nil || len(errs) > 0 || len(rows) != 2 {
+			t.Errorf("readImport(export with clicks) = %d links, %v, %v; want 2 links", len(rows), errs, err)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		w := export(t, "?format=csv&clicks=1", "")
+		want := `short,long,owner,description,tags,aliases,coowners,created,lastedit,expires,visibility,clicks
+search,http://search/?q={{.Path}},foo@example.com,,,,,2022-06-02T01:02:03Z,2022-06-02T01:02:03Z,,,0
+who,http://who/,foo@example.com,Find people,people,,,2022-06-02T01:02:03Z,2022-06-02T01:02:03Z,,,3
+`
+		if diff := cmp.Diff(want, w.Body.String()); diff != "" {
+			t.Errorf("serveExport(csv) diff (-want +got):\n%s", diff)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="golinks.csv"` {
+			t.Errorf("Content-Disposition = %q", got)
+		}
+	})
+
+	t.Run("bookmarks", func(t *testing.T) {
+		w := export(t, "?format=bookmarks", "")
+		rows, errs, err := readImport(importBookmarks, w.Body)
+		if err != nil || len(errs) > 0 {
+			t.Fatalf("readImport(bookmarks export) = %v, %v", errs, err)
+		}
+		want := []*Link{
+			// templates can't be bookmarked, so the go link is used instead
+			{Short: "search", Long: "http://go/search", Description: "search", Created: created, LastEdit: created},
+			{Short: "who", Long: "http://who/", Description: "Find people", Tags: []string{"people"}, Created: created, LastEdit: created},
+		}
+		if diff := cmp.Diff(want, importRowLinks(rows), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("bookmarks round trip diff (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		w := export(t, "?clicks=1", "application/yaml")
+		var got []yamlLink
+		if err := yaml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("parsing YAML export: %v\n%s", err, w.Body)
+		}
+		three, zero := 3, 0
+		want := []yamlLink{
+			{Short: "search", Long: "http://search/?q={{.Path}}", Owner: "foo@example.com", Created: created, LastEdit: created, Clicks: &zero},
+			{Short: "who", Long: "http://who/", Owner: "foo@example.com", Description: "Find people", Tags: []string{"people"}, Created: created, LastEdit: created, Clicks: &three},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("serveExport(yaml) diff (-want +got):\n%s", diff)
+		}
+	})
+}
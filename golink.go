@@ -5,7 +5,6 @@
 package golink
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -16,19 +15,24 @@
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	texttemplate "text/template"
 	"time"
+	"unicode"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -58,66 +62,282 @@
 	secHeaderName = "Sec-Golink"
 )
 
-var (
-	verbose           = flag.Bool("verbose", false, "be verbose")
-	controlURL        = flag.String("control-url", ipn.DefaultControlURL, "the URL base of the control plane (i.e. coordination server)")
-	sqlitefile        = flag.String("sqlitedb", "", "path of SQLite database to store links")
-	dev               = flag.String("dev-listen", "", "if non-empty, listen on this addr and run in dev mode; auto-set sqlitedb if empty and don't use tsnet")
-	useHTTPS          = flag.Bool("https", true, "serve golink over HTTPS if enabled on tailnet")
-	snapshot          = flag.String("snapshot", "", "file path of snapshot file")
-	hostname          = flag.String("hostname", defaultHostname, "service name")
-	configDir         = flag.String("config-dir", "", `tsnet configuration directory ("" to use default)`)
-	resolveFromBackup = flag.String("resolve-from-backup", "", "resolve a link from snapshot file and exit")
-	allowUnknownUsers = flag.Bool("allow-unknown-users", false, "allow unknown users to save links")
-	readonly          = flag.Bool("readonly", false, "start golink server in read-only mode")
-	advertiseTags     = flag.String("advertise-tags", os.Getenv("TS_ADVERTISE_TAGS"), "comma-separated list of ACL tags to advertise (e.g. tag:golink)")
-	serviceName       = flag.String("register-as-service", envknob.String("TS_SERVICE_NAME"), "register as a Tailscale Service (e.g., svc:golink); requires tagged node")
-)
+// LastSnapshot is the data snapshot (as returned by the /.export handler)
+// that will be loaded on startup by Run if --snapshot is not set.
+//
+// Deprecated: Programs that embed golink should set Options.Snapshot.
+var LastSnapshot []byte
+
+//go:embed static tmpl/*.html tmpl/*.xml
+var embeddedFS embed.FS
+
+// Options configure a Server.
+type Options struct {
+	// Store holds the links that are served. It is required.
+	Store LinkStore
 
-var stats struct {
-	mu     sync.Mutex
-	clicks ClickStats // short link -> number of times visited
+	// Hostname is the name golink is served at, such as "go". It is used to
+	// render links in the UI, and links to it are resolved as go links.
+	// If empty, "go" is used.
+	Hostname string
 
-	// dirty identifies short link clicks that have not yet been stored.
-	dirty ClickStats
+	// LocalClient identifies the tailnet users making requests and looks up
+	// link owners. It is required to serve requests unless Dev is true.
+	LocalClient *local.Client
+
+	// Dev runs the server in dev mode, in which every request is made by
+	// foo@example.com and all users exist.
+	Dev bool
+
+	// ReadOnly prevents links from being created, edited, or deleted.
+	ReadOnly bool
+
+	// AllowUnknownUsers allows users that cannot be identified, such as
+	// those connecting through a subnet router, to save links.
+	AllowUnknownUsers bool
+
+	// ServiceName is the Tailscale Service golink is registered as, if any,
+	// in which case the identity headers tsnet adds to requests are trusted.
+	ServiceName string
+
+	// XSRFKey is the secret used to generate XSRF tokens. If empty, a random
+	// key is used.
+	XSRFKey string
+
+	// Snapshot is a snapshot as returned by /.export. NewServer adds its
+	// links that don't already exist to Store.
+	Snapshot []byte
+
+	// TrashRetention is how long deleted links are kept in the trash before
+	// being purged. If zero, they are kept forever.
+	TrashRetention time.Duration
+
+	// StatsHourlyAfter and StatsDailyAfter are the ages after which click
+	// stats are rolled up into hourly and daily totals. If zero, stats are
+	// not rolled up.
+	StatsHourlyAfter time.Duration
+	StatsDailyAfter  time.Duration
+
+	// BackupDir, if non-empty, is a directory that a snapshot of all links
+	// is written to every BackupInterval, or daily if that is zero. All but
+	// the newest BackupKeep snapshots, and at least one, are removed.
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	// LinkCacheSize is the number of links to cache in memory for
+	// resolving. If zero, links are not cached.
+	LinkCacheSize int
+
+	// HealthCheckInterval is how often the destination of every link is
+	// checked, with broken destinations reported by /.broken. If zero,
+	// destinations are not checked.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is how long a destination has to respond to a
+	// check before it is considered broken. If zero, 10 seconds is used.
+	HealthCheckTimeout time.Duration
+
+	// HealthCheckClient makes the requests that check destinations. If
+	// nil, http.DefaultClient is used.
+	HealthCheckClient *http.Client
+
+	// Registerer registers the server's Prometheus metrics. If it is also
+	// a prometheus.Gatherer, such as a *prometheus.Registry, the metrics it
+	// gathers are served at /.metrics. If nil, a new registry is used, so
+	// that each Server reports only its own metrics.
+	Registerer prometheus.Registerer
+
+	// Verbose logs the work done by the background loops.
+	Verbose bool
 }
 
-var (
-	clickCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "golink_clicks_total",
-			Help: "Total number of clicks for a recognized GoLink",
-		},
-		[]string{"path"},
-	)
-	clickNotFound = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "golink_not_found_total",
-			Help: "Total number of clicks for a GoLink doesn't exist",
-		},
-		[]string{"path"},
-	)
-	totalLinkCount = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "golinks_total",
-			Help: "Total number of GoLinks being served",
-		},
-	)
-)
+// A Server serves the go links in a LinkStore.
+//
+// Its Handler serves go links at the root of its URL space, so it can be
+// served on its own, as Run does, or mounted at "/" or at a host pattern
+// such as "go/" in a ServeMux with other routes. Multiple Servers can run in
+// the same process, each with its own store.
+type Server struct {
+	opts Options
+	db   LinkStore // opts.Store, behind a linkCache if enabled
 
-// LastSnapshot is the data snapshot (as returned by the /.export handler)
-// that will be loaded on startup.
-var LastSnapshot []byte
+	xsrfKey string
 
-//go:embed static tmpl/*.html tmpl/*.xml
-var embeddedFS embed.FS
+	metrics  *metrics
+	gatherer prometheus.Gatherer // serves /.metrics, if not nil
+
+	// currentUser returns the user that made a request. It is a field so
+	// that tests can override it; by default it is lookupUser.
+	currentUser func(*http.Request) (user, error)
+
+	// whoisFunc looks up the peer with an IP address. It is a field so that
+	// tests can override it; by default it calls LocalClient.WhoIs.
+	whoisFunc func(ctx context.Context, ip string) (*apitype.WhoIsResponse, error)
+
+	stats struct {
+		mu     sync.Mutex
+		clicks ClickStats // short link -> number of times visited
+
+		// dirty identifies short link clicks that have not yet been stored.
+		dirty ClickStats
+	}
+
+	// homeTmpl is the template used by the http://go/ index page where you can
+	// create or edit links.
+	homeTmpl *template.Template
+
+	// detailTmpl is the template used by the link detail page to view or edit links.
+	detailTmpl *template.Template
+
+	// successTmpl is the template used when a link is successfully created or updated.
+	successTmpl *template.Template
+
+	// helpTmpl is the template used by the http://go/.help page
+	helpTmpl *template.Template
+
+	// deleteTmpl is the template used after a link has been deleted.
+	deleteTmpl *template.Template
+
+	// opensearchTmpl is the template used by the http://go/.opensearch page
+	opensearchTmpl *template.Template
+
+	// searchTmpl is the template used by the http://go/.search and
+	// http://go/.all pages
+	searchTmpl *template.Template
+
+	// historyTmpl is the template used by the http://go/.history page
+	historyTmpl *template.Template
+
+	// trashTmpl is the template used by the http://go/.trash page
+	trashTmpl *template.Template
+
+	// tagsTmpl is the template used by the http://go/.tags page
+	tagsTmpl *template.Template
+
+	// expiredTmpl is the template used when resolving an expired link
+	expiredTmpl *template.Template
+
+	// expiredLinksTmpl is the template used by the http://go/.expired page
+	expiredLinksTmpl *template.Template
+
+	// brokenTmpl is the template used by the http://go/.broken page
+	brokenTmpl *template.Template
+}
+
+// NewServer returns a Server configured by opts. It restores opts.Snapshot
+// and loads the click stats from opts.Store, but does not start the
+// background loops; call Start to do that.
+func NewServer(opts Options) (*Server, error) {
+	if opts.Store == nil {
+		return nil, errors.New("Options.Store is required")
+	}
+	if opts.Hostname == "" {
+		opts.Hostname = defaultHostname
+	}
+	if opts.XSRFKey == "" {
+		b := make([]byte, 24)
+		rand.Read(b)
+		opts.XSRFKey = base64.StdEncoding.EncodeToString(b)
+	}
+
+	s := &Server{
+		opts:    opts,
+		db:      opts.Store,
+		xsrfKey: opts.XSRFKey,
+		metrics: newMetrics(),
+	}
+	reg := opts.Registerer
+	if reg == nil {
+		reg = prometheus.NewRegistry()
+	}
+	if err := s.metrics.register(reg); err != nil {
+		return nil, fmt.Errorf("registering metrics: %w", err)
+	}
+	s.gatherer, _ = reg.(prometheus.Gatherer)
+	if opts.LinkCacheSize > 0 {
+		s.db = newLinkCache(opts.Store, opts.LinkCacheSize, s.metrics)
+	}
+	s.currentUser = s.lookupUser
+	s.whoisFunc = func(ctx context.Context, ip string) (*apitype.WhoIsResponse, error) {
+		return s.opts.LocalClient.WhoIs(ctx, ip)
+	}
+	s.initTemplates()
+
+	if err := s.restoreSnapshot(); err != nil {
+		log.Printf("restoring snapshot: %v", err)
+	}
+	if err := s.initStats(); err != nil {
+		log.Printf("initializing stats: %v", err)
+	}
+	if err := s.initMetricsData(); err != nil {
+		log.Printf("initializing metrics data: %v", err)
+	}
+	return s, nil
+}
+
+// Start starts the background loops that flush click stats, purge the
+// trash, roll up old click stats, expire links, write backups, and check
+// link destinations. They run until ctx is done, when any unsaved click
+// stats are flushed.
+func (s *Server) Start(ctx context.Context) {
+	// flush stats periodically
+	go s.flushStatsLoop(ctx)
+
+	// purge expired links from the trash periodically
+	go s.purgeTrashLoop(ctx)
+
+	// roll up old click stats periodically
+	go s.compactStatsLoop(ctx)
+
+	// mark links that have passed their expiration time
+	go s.expireLinksLoop(ctx)
 
-// db stores short links.
-var db *SQLiteDB
+	// write snapshot backups periodically
+	if s.opts.BackupDir != "" {
+		go s.backupLoop(ctx)
+	}
 
-var localClient *local.Client
+	// check link destinations periodically
+	if s.opts.HealthCheckInterval > 0 {
+		go s.healthCheckLoop(ctx)
+	}
+}
 
+// Run parses the command line flags and runs the golink server they
+// describe until it fails.
 func Run() error {
+	var (
+		verbose           = flag.Bool("verbose", false, "be verbose")
+		controlURL        = flag.String("control-url", ipn.DefaultControlURL, "the URL base of the control plane (i.e. coordination server)")
+		sqlitefile        = flag.String("sqlitedb", "", "path of SQLite database to store links")
+		postgresDSN       = flag.String("postgres-dsn", "", "if non-empty, store links in the PostgreSQL database with this connection string instead of SQLite")
+		copyToPostgres    = flag.Bool("copy-to-postgres", false, "copy the links in --sqlitedb into the empty --postgres-dsn database and exit")
+		dev               = flag.String("dev-listen", "", "if non-empty, listen on this addr and run in dev mode; auto-set sqlitedb if empty and don't use tsnet")
+		useHTTPS          = flag.Bool("https", true, "serve golink over HTTPS if enabled on tailnet")
+		snapshot          = flag.String("snapshot", "", "file path of snapshot file")
+		hostname          = flag.String("hostname", defaultHostname, "service name")
+		configDir         = flag.String("config-dir", "", `tsnet configuration directory ("" to use default)`)
+		resolveFromBackup = flag.String("resolve-from-backup", "", "resolve a link from snapshot file and exit")
+		allowUnknownUsers = flag.Bool("allow-unknown-users", false, "allow unknown users to save links")
+		readonly          = flag.Bool("readonly", false, "start golink server in read-only mode")
+		advertiseTags     = flag.String("advertise-tags", os.Getenv("TS_ADVERTISE_TAGS"), "comma-separated list of ACL tags to advertise (e.g. tag:golink)")
+		serviceName       = flag.String("register-as-service", envknob.String("TS_SERVICE_NAME"), "register as a Tailscale Service (e.g., svc:golink); requires tagged node")
+		trashRetention    = flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted links are kept in the trash before being purged")
+		statsHourlyAfter  = flag.Duration("stats-hourly-after", 7*24*time.Hour, "age after which per-minute click stats are rolled up into hourly totals (0 to disable)")
+		statsDailyAfter   = flag.Duration("stats-daily-after", 90*24*time.Hour, "age after which click stats are rolled up into daily totals (0 to disable)")
+		backupDir         = flag.String("backup-dir", "", "if non-empty, periodically write snapshots of all links to this directory")
+		backupInterval    = flag.Duration("backup-interval", 24*time.Hour, "how often to write a snapshot to --backup-dir")
+		backupKeep        = flag.Int("backup-keep", 7, "number of snapshots to keep in --backup-dir")
+		importFile        = flag.String("import", "", "file path of links to import into the database before exiting; see --import-format")
+		importFormat      = flag.String("import-format", "", `format of the --import file: "jsonl" (as returned by /.export), "csv", "bookmarks", or "json"; chosen by file extension if empty`)
+		importMode        = flag.String("import-mode", string(ImportSkipExisting), `how --import merges links that already exist: "skip-existing", "overwrite", or "newer"`)
+		importDryRun      = flag.Bool("import-dry-run", false, "report what --import would do without saving any links")
+		restoreDB         = flag.String("restore-db", "", "file path of a database backup (as returned by /.backup) to replace --sqlitedb with on startup")
+		linkCacheSize     = flag.Int("link-cache-size", 10000, "number of links to cache in memory for resolving (0 to disable)")
+		healthInterval    = flag.Duration("health-check-interval", 0, "how often to check that link destinations respond, reporting broken ones at /.broken (0 to disable)")
+		healthTimeout     = flag.Duration("health-check-timeout", defaultHealthCheckTimeout, "how long a link destination has to respond to a health check")
+	)
 	flag.Parse()
 
 	hostinfo.SetApp("golink")
@@ -132,62 +352,103 @@ func Run() error {
 		}
 	}
 
-	if *sqlitefile == "" {
-		if devMode() {
-			tmpdir, err := os.MkdirTemp("", "golink_dev_*")
-			if err != nil {
-				return err
-			}
-			*sqlitefile = filepath.Join(tmpdir, "golink.db")
-			log.Printf("Dev mode temp db: %s", *sqlitefile)
-		} else {
-			return errors.New("--sqlitedb is required")
-		}
+	if *copyToPostgres {
+		return copySQLiteToPostgres(*sqlitefile, *postgresDSN)
 	}
 
-	var err error
-	if db, err = NewSQLiteDB(*sqlitefile); err != nil {
-		return fmt.Errorf("NewSQLiteDB(%q): %w", *sqlitefile, err)
+	if *postgresDSN != "" && *restoreDB != "" {
+		return errors.New("--restore-db is only supported with --sqlitedb")
 	}
 
-	if *snapshot != "" {
-		if LastSnapshot != nil {
-			log.Printf("LastSnapshot already set; ignoring --snapshot")
-		} else {
-			var err error
-			LastSnapshot, err = os.ReadFile(*snapshot)
-			if err != nil {
-				log.Fatalf("error reading snapshot file %q: %v", *snapshot, err)
+	var db LinkStore
+	if *postgresDSN != "" && *resolveFromBackup == "" {
+		postgresDB, err := NewPostgresDB(*postgresDSN)
+		if err != nil {
+			return fmt.Errorf("NewPostgresDB: %w", err)
+		}
+		db = postgresDB
+	} else {
+		if *sqlitefile == "" {
+			if *dev != "" {
+				tmpdir, err := os.MkdirTemp("", "golink_dev_*")
+				if err != nil {
+					return err
+				}
+				*sqlitefile = filepath.Join(tmpdir, "golink.db")
+				log.Printf("Dev mode temp db: %s", *sqlitefile)
+			} else {
+				return errors.New("--sqlitedb or --postgres-dsn is required")
 			}
 		}
+
+		if *restoreDB != "" {
+			if *sqlitefile == ":memory:" {
+				return errors.New("--restore-db cannot be used with --resolve-from-backup")
+			}
+			if err := RestoreSQLiteDB(*restoreDB, *sqlitefile); err != nil {
+				return fmt.Errorf("restoring database: %w", err)
+			}
+			log.Printf("Restored %s from %s", *sqlitefile, *restoreDB)
+		}
+
+		sqliteDB, err := NewSQLiteDB(*sqlitefile)
+		if err != nil {
+			return fmt.Errorf("NewSQLiteDB(%q): %w", *sqlitefile, err)
+		}
+		db = sqliteDB
+	}
+
+	if *importFile != "" {
+		return importLinksFromFile(db, *hostname, *importFile, *importFormat, *importMode, *importDryRun)
+	}
+
+	opts := Options{
+		Store:               db,
+		Hostname:            *hostname,
+		Dev:                 *dev != "",
+		ReadOnly:            *readonly,
+		AllowUnknownUsers:   *allowUnknownUsers,
+		ServiceName:         *serviceName,
+		TrashRetention:      *trashRetention,
+		StatsHourlyAfter:    *statsHourlyAfter,
+		StatsDailyAfter:     *statsDailyAfter,
+		BackupDir:           *backupDir,
+		BackupInterval:      *backupInterval,
+		BackupKeep:          *backupKeep,
+		LinkCacheSize:       *linkCacheSize,
+		HealthCheckInterval: *healthInterval,
+		HealthCheckTimeout:  *healthTimeout,
+		Registerer:          prometheus.DefaultRegisterer,
+		Verbose:             *verbose,
 	}
-	if err := restoreLastSnapshot(); err != nil {
-		log.Printf("restoring snapshot: %v", err)
-	}
-	if err := initStats(); err != nil {
-		log.Printf("initializing stats: %v", err)
-	}
-	if err := initMetricsData(); err != nil {
-		log.Printf("initializing metrics data: %v", err)
+	if *snapshot != "" {
+		var err error
+		opts.Snapshot, err = os.ReadFile(*snapshot)
+		if err != nil {
+			log.Fatalf("error reading snapshot file %q: %v", *snapshot, err)
+		}
+	} else {
+		opts.Snapshot = LastSnapshot
 	}
 
 	// if link specified on command line, resolve and exit
 	if flag.NArg() > 0 {
+		s, err := NewServer(opts)
+		if err != nil {
+			return err
+		}
 		u, err := url.Parse(flag.Arg(0))
 		if err != nil {
 			log.Fatal(err)
 		}
-		dst, err := resolveLink(u)
+		dst, err := s.resolveLink(u)
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Println(dst.String())
-		os.Exit(0)
+		return nil
 	}
 
-	// flush stats periodically
-	go flushStatsLoop()
-
 	if *dev != "" {
 		// override default hostname for dev mode
 		if *hostname == defaultHostname {
@@ -195,12 +456,17 @@ func Run() error {
 				if h == "" {
 					h = "localhost"
 				}
-				*hostname = fmt.Sprintf("%s:%s", h, p)
+				opts.Hostname = fmt.Sprintf("%s:%s", h, p)
 			}
 		}
 
+		s, err := NewServer(opts)
+		if err != nil {
+			return err
+		}
+		s.Start(context.Background())
 		log.Printf("Running in dev mode on %s ...", *dev)
-		log.Fatal(http.ListenAndServe(*dev, serveHandler()))
+		log.Fatal(http.ListenAndServe(*dev, s.Handler()))
 	}
 
 	if *hostname == "" {
@@ -228,7 +494,7 @@ func Run() error {
 		return err
 	}
 
-	localClient, _ = srv.LocalClient()
+	localClient, _ := srv.LocalClient()
 out:
 	for {
 		upCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -248,7 +514,15 @@ func Run() error {
 	enableTLS := *useHTTPS && status.Self.HasCap(tailcfg.CapabilityHTTPS) && len(srv.CertDomains()) > 0
 	fqdn := strings.TrimSuffix(status.Self.DNSName, ".")
 
-	httpHandler := serveHandler()
+	opts.LocalClient = localClient
+	// check destinations over the tailnet, where most of them are
+	opts.HealthCheckClient = srv.HTTPClient()
+	s, err := NewServer(opts)
+	if err != nil {
+		return err
+	}
+	s.Start(context.Background())
+	httpHandler := s.Handler()
 
 	// Service registration mode: use ListenService instead of standard listeners
 	if *serviceName != "" {
@@ -304,33 +578,6 @@ func Run() error {
 	return nil
 }
 
-var (
-	// homeTmpl is the template used by the http://go/ index page where you can
-	// create or edit links.
-	homeTmpl *template.Template
-
-	// detailTmpl is the template used by the link detail page to view or edit links.
-	detailTmpl *template.Template
-
-	// successTmpl is the template used when a link is successfully created or updated.
-	successTmpl *template.Template
-
-	// helpTmpl is the template used by the http://go/.help page
-	helpTmpl *template.Template
-
-	// allTmpl is the template used by the http://go/.all page
-	allTmpl *template.Template
-
-	// deleteTmpl is the template used after a link has been deleted.
-	deleteTmpl *template.Template
-
-	// opensearchTmpl is the template used by the http://go/.opensearch page
-	opensearchTmpl *template.Template
-
-	// searchTmpl is the template used by the http://go/.search page
-	searchTmpl *template.Template
-)
-
 type visitData struct {
 	Short     string
 	NumClicks int
@@ -346,13 +593,13 @@ type searchResult struct {
 // searchResults annotates links with their current click counts (read from the
 // live in-memory counter, the same source the home page uses), preserving the
 // historical alphabetical ordering by short name.
-func searchResults(links []*Link) []searchResult {
-	stats.mu.Lock()
+func (s *Server) searchResults(links []*Link) []searchResult {
+	s.stats.mu.Lock()
 	results := make([]searchResult, len(links))
 	for i, link := range links {
-		results[i] = searchResult{Link: link, NumClicks: stats.clicks[link.Short]}
+		results[i] = searchResult{Link: link, NumClicks: s.stats.clicks[link.Short]}
 	}
-	stats.mu.Unlock()
+	s.stats.mu.Unlock()
 
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].Short < results[j].Short
@@ -360,6 +607,30 @@ func searchResults(links []*Link) []searchResult {
 	return results
 }
 
+// rankedSearchResults annotates search matches with their current click
+// counts and orders them by relevance, boosted by popularity so that
+// frequently used links appear above equally relevant but unused ones.
+func (s *Server) rankedSearchResults(matches []LinkMatch) []searchResult {
+	s.stats.mu.Lock()
+	results := make([]searchResult, len(matches))
+	scores := make(map[string]float64, len(matches))
+	for i, m := range matches {
+		clicks := s.stats.clicks[m.Short]
+		results[i] = searchResult{Link: m.Link, NumClicks: clicks}
+		scores[m.Short] = m.Score * (1 + math.Log1p(float64(clicks)))
+	}
+	s.stats.mu.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		si, sj := scores[results[i].Short], scores[results[j].Short]
+		if si != sj {
+			return si > sj
+		}
+		return results[i].Short < results[j].Short
+	})
+	return results
+}
+
 // homeData is the data used by homeTmpl.
 type homeData struct {
 	Short    string
@@ -377,41 +648,39 @@ type deleteData struct {
 	XSRF  string
 }
 
-var xsrfKey string
-
-func init() {
-	homeTmpl = newTemplate("base.html", "home.html")
-	detailTmpl = newTemplate("base.html", "detail.html")
-	successTmpl = newTemplate("base.html", "success.html")
-	helpTmpl = newTemplate("base.html", "help.html")
-	deleteTmpl = newTemplate("base.html", "delete.html")
-	opensearchTmpl = newTemplate("opensearch.xml")
-	searchTmpl = newTemplate("base.html", "search.html")
-
-	b := make([]byte, 24)
-	rand.Read(b)
-	xsrfKey = base64.StdEncoding.EncodeToString(b)
-
-	initMetrics()
-}
-
-var tmplFuncs = template.FuncMap{
-	// go is a template function that returns the hostname of the golink service.
-	// This is used throughout the UI to render links, but does not impact link resolution.
-	"go": func() string {
-		if devMode() {
-			// in dev mode, just use "go" instead of "localhost:8080"
-			return defaultHostname
-		}
-		return *hostname
-	},
+// initTemplates parses the templates used by s.
+func (s *Server) initTemplates() {
+	funcs := template.FuncMap{
+		// go is a template function that returns the hostname of the golink service.
+		// This is used throughout the UI to render links, but does not impact link resolution.
+		"go": func() string {
+			if s.opts.Dev {
+				// in dev mode, just use "go" instead of "localhost:8080"
+				return defaultHostname
+			}
+			return s.opts.Hostname
+		},
+	}
+	s.homeTmpl = newTemplate(funcs, "base.html", "home.html")
+	s.detailTmpl = newTemplate(funcs, "base.html", "detail.html")
+	s.successTmpl = newTemplate(funcs, "base.html", "success.html")
+	s.helpTmpl = newTemplate(funcs, "base.html", "help.html")
+	s.deleteTmpl = newTemplate(funcs, "base.html", "delete.html")
+	s.opensearchTmpl = newTemplate(funcs, "opensearch.xml")
+	s.searchTmpl = newTemplate(funcs, "base.html", "search.html")
+	s.historyTmpl = newTemplate(funcs, "base.html", "history.html")
+	s.trashTmpl = newTemplate(funcs, "base.html", "trash.html")
+	s.tagsTmpl = newTemplate(funcs, "base.html", "tags.html")
+	s.expiredTmpl = newTemplate(funcs, "base.html", "expired.html")
+	s.expiredLinksTmpl = newTemplate(funcs, "base.html", "expiredlinks.html")
+	s.brokenTmpl = newTemplate(funcs, "base.html", "broken.html")
 }
 
 // newTemplate creates a new template with the specified files in the tmpl directory.
 // The first file name is used as the template name,
-// and tmplFuncs are registered as available funcs.
+// and funcs are registered as available funcs.
 // This func panics if unable to parse files.
-func newTemplate(files ...string) *template.Template {
+func newTemplate(funcs template.FuncMap, files ...string) *template.Template {
 	if len(files) == 0 {
 		return nil
 	}
@@ -419,81 +688,209 @@ func newTemplate(files ...string) *template.Template {
 	for _, f := range files {
 		tf = append(tf, "tmpl/"+f)
 	}
-	t := template.New(files[0]).Funcs(tmplFuncs)
+	t := template.New(files[0]).Funcs(funcs)
 	return template.Must(t.ParseFS(embeddedFS, tf...))
 }
 
-// initMetrics initializes Prometheus Metrics
-func initMetrics() {
-	prometheus.MustRegister(clickCounter)
-	prometheus.MustRegister(clickNotFound)
-	prometheus.MustRegister(totalLinkCount)
-}
-
 // initMetricsData set metrics to what is represented in the DB
-func initMetricsData() error {
-	// Set the totalLinkCount metric to what is saved in the DB
-	var count float64
-	err := db.db.QueryRow("SELECT COUNT(DISTINCT id) FROM Links").Scan(&count)
+func (s *Server) initMetricsData() error {
+	// Set the totalLinks metric to what is saved in the DB
+	links, err := s.db.LoadAll()
+	if err != nil {
+		return err
+	}
+	s.metrics.totalLinks.Set(float64(len(links)))
+
+	// Set the brokenLinks metric from the last health checks
+	health, err := s.db.LoadLinkHealth()
 	if err != nil {
 		return err
 	}
-	totalLinkCount.Set(count)
+	s.metrics.brokenLinks.Set(float64(len(brokenLinks(links, health, time.Now()))))
 
 	return nil
 }
 
 // initStats initializes the in-memory stats counter with counts from db.
-func initStats() error {
-	stats.mu.Lock()
-	defer stats.mu.Unlock()
+func (s *Server) initStats() error {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
 
-	clicks, err := db.LoadStats()
+	clicks, err := s.db.LoadStats()
 	if err != nil {
 		return err
 	}
 
-	stats.clicks = clicks
-	stats.dirty = make(ClickStats)
+	s.stats.clicks = clicks
+	s.stats.dirty = make(ClickStats)
 
 	return nil
 }
 
 // flushStats writes any pending link stats to db.
-func flushStats() error {
-	stats.mu.Lock()
-	defer stats.mu.Unlock()
+func (s *Server) flushStats() error {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
 
-	if len(stats.dirty) == 0 {
+	if len(s.stats.dirty) == 0 {
 		return nil
 	}
 
-	if err := db.SaveStats(stats.dirty); err != nil {
+	if err := s.db.SaveStats(s.stats.dirty); err != nil {
 		return err
 	}
-	stats.dirty = make(ClickStats)
+	s.stats.dirty = make(ClickStats)
 	return nil
 }
 
-// flushStatsLoop will flush stats every minute.  This function never returns.
-func flushStatsLoop() {
+// flushStatsLoop will flush stats every minute, and once more when ctx is
+// done.  This function returns when ctx is done.
+func (s *Server) flushStatsLoop(ctx context.Context) {
 	for {
-		if err := flushStats(); err != nil {
+		if err := s.flushStats(); err != nil {
 			log.Printf("flushing stats: %v", err)
 		}
-		time.Sleep(time.Minute)
+		if !sleep(ctx, time.Minute) {
+			if err := s.flushStats(); err != nil {
+				log.Printf("flushing stats: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// compactStats rolls up stored click stats older than StatsHourlyAfter and
+// StatsDailyAfter into hourly and daily totals.
+func (s *Server) compactStats() error {
+	now := time.Now()
+	var hourlyBefore, dailyBefore time.Time // zero time disables rollup
+	if s.opts.StatsHourlyAfter > 0 {
+		hourlyBefore = now.Add(-s.opts.StatsHourlyAfter)
+	}
+	if s.opts.StatsDailyAfter > 0 {
+		dailyBefore = now.Add(-s.opts.StatsDailyAfter)
+	}
+	return s.db.CompactStats(hourlyBefore, dailyBefore)
+}
+
+// compactStatsLoop will compact stats every hour.  This function returns
+// when ctx is done.
+func (s *Server) compactStatsLoop(ctx context.Context) {
+	for {
+		if err := s.compactStats(); err != nil {
+			log.Printf("compacting stats: %v", err)
+		}
+		if !sleep(ctx, time.Hour) {
+			return
+		}
 	}
 }
 
 // deleteLinkStats removes the link stats from memory.
-func deleteLinkStats(link *Link) {
-	totalLinkCount.Dec()
-	stats.mu.Lock()
-	delete(stats.clicks, link.Short)
-	delete(stats.dirty, link.Short)
-	stats.mu.Unlock()
+// Stored stats are moved to the trash along with the link by db.TrashLink.
+func (s *Server) deleteLinkStats(link *Link) {
+	s.metrics.totalLinks.Dec()
+	s.stats.mu.Lock()
+	delete(s.stats.clicks, link.Short)
+	delete(s.stats.dirty, link.Short)
+	s.stats.mu.Unlock()
+}
+
+// renameLinkStats moves the in-memory stats of a renamed link to its new
+// short name.
+func (s *Server) renameLinkStats(short, newShort string) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	for _, stats := range []ClickStats{s.stats.clicks, s.stats.dirty} {
+		if n, ok := stats[short]; ok {
+			delete(stats, short)
+			stats[newShort] += n
+		}
+	}
+}
+
+// restoreLinkStats reloads the stats for a link restored from the trash.
+func (s *Server) restoreLinkStats(link *Link) error {
+	s.metrics.totalLinks.Inc()
+	clicks, err := s.db.LoadStats()
+	if err != nil {
+		return err
+	}
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if n := clicks[link.Short]; n > 0 {
+		if s.stats.clicks == nil {
+			s.stats.clicks = make(ClickStats)
+		}
+		s.stats.clicks[link.Short] = n
+	}
+	return nil
+}
+
+// purgeTrash permanently removes links that have been in the trash for
+// longer than the TrashRetention period.
+func (s *Server) purgeTrash() error {
+	if s.opts.TrashRetention <= 0 {
+		return nil
+	}
+	n, err := s.db.PurgeTrash(time.Now().Add(-s.opts.TrashRetention))
+	if err != nil {
+		return err
+	}
+	if n > 0 && s.opts.Verbose {
+		log.Printf("Purged %v links from the trash.", n)
+	}
+	return nil
+}
+
+// purgeTrashLoop will purge the trash every hour.  This function returns
+// when ctx is done.
+func (s *Server) purgeTrashLoop(ctx context.Context) {
+	for {
+		if err := s.purgeTrash(); err != nil {
+			log.Printf("purging trash: %v", err)
+		}
+		if !sleep(ctx, time.Hour) {
+			return
+		}
+	}
+}
+
+// expireLinks marks links that have passed their expiration time as expired.
+func (s *Server) expireLinks() error {
+	n, err := s.db.ExpireLinks(time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 && s.opts.Verbose {
+		log.Printf("Expired %v links.", n)
+	}
+	return nil
+}
+
+// expireLinksLoop will mark expired links every minute.  This function
+// returns when ctx is done.
+func (s *Server) expireLinksLoop(ctx context.Context) {
+	for {
+		if err := s.expireLinks(); err != nil {
+			log.Printf("expiring links: %v", err)
+		}
+		if !sleep(ctx, time.Minute) {
+			return
+		}
+	}
+}
 
-	db.DeleteStats(link.Short)
+// sleep pauses for d, and reports whether it did so before ctx was done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // redirectHandler returns the http.Handler for serving all plaintext HTTP
@@ -530,18 +927,31 @@ func HSTS(h http.Handler) http.Handler {
 	})
 }
 
-// serverHandler returns the main http.Handler for serving all requests.
-func serveHandler() http.Handler {
+// Handler returns the main http.Handler for serving all requests.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/.detail/", serveDetail)
-	mux.HandleFunc("/.export", serveExport)
-	mux.HandleFunc("/.export-stats", serveExportStats)
-	mux.HandleFunc("/.help", serveHelp)
-	mux.HandleFunc("/.opensearch", serveOpenSearch)
-	mux.HandleFunc("/.all", serveAll)
-	mux.HandleFunc("/.delete/", serveDelete)
-	mux.HandleFunc("/.search", serveSearch)
-	mux.Handle("/.metrics", promhttp.Handler())
+	mux.HandleFunc("/.detail/", s.serveDetail)
+	mux.HandleFunc("/.export", s.serveExport)
+	mux.HandleFunc("/.export-stats", s.serveExportStats)
+	mux.HandleFunc("/.backup", s.serveBackup)
+	mux.HandleFunc("/.import", s.serveImport)
+	mux.HandleFunc("/.help", s.serveHelp)
+	mux.HandleFunc("/.opensearch", s.serveOpenSearch)
+	mux.HandleFunc("/.all", s.serveAll)
+	mux.HandleFunc("/.delete/", s.serveDelete)
+	mux.HandleFunc("/.rename/", s.serveRename)
+	mux.HandleFunc("/.search", s.serveSearch)
+	mux.HandleFunc("/.tags", s.serveTags)
+	mux.HandleFunc("/.graph", s.serveGraph)
+	mux.HandleFunc("/.expired", s.serveExpiredLinks)
+	mux.HandleFunc("/.broken", s.serveBroken)
+	mux.HandleFunc("/.history/", s.serveHistory)
+	mux.HandleFunc("/.revert/", s.serveRevert)
+	mux.HandleFunc("/.trash", s.serveTrash)
+	mux.HandleFunc("/.restore/", s.serveRestore)
+	if s.gatherer != nil {
+		mux.Handle("/.metrics", promhttp.HandlerFor(s.gatherer, promhttp.HandlerOpts{}))
+	}
 	mux.Handle("/.static/", http.StripPrefix("/.", http.FileServer(http.FS(embeddedFS))))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -549,24 +959,44 @@ func serveHandler() http.Handler {
 		// Serve go links directly without passing through the ServeMux,
 		// which sometimes modifies the request URL path, which we don't want.
 		if !strings.HasPrefix(r.URL.Path, "/.") {
-			serveGo(w, r)
+			s.serveGo(w, r)
 			return
 		}
 		mux.ServeHTTP(w, r)
 	})
 }
 
-func serveHome(w http.ResponseWriter, r *http.Request, short string) {
-	var clicks []visitData
+func (s *Server) serveHome(w http.ResponseWriter, r *http.Request, short string) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	links, err := s.db.LoadAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// popular links the current user cannot see are left out
+	hidden := make(map[string]bool)
+	for _, link := range links {
+		if !canViewLink(link, cu) {
+			hidden[link.Short] = true
+		}
+	}
 
-	stats.mu.Lock()
-	for short, numClicks := range stats.clicks {
+	var clicks []visitData
+	s.stats.mu.Lock()
+	for short, numClicks := range s.stats.clicks {
+		if hidden[short] {
+			continue
+		}
 		clicks = append(clicks, visitData{
 			Short:     short,
 			NumClicks: numClicks,
 		})
 	}
-	stats.mu.Unlock()
+	s.stats.mu.Unlock()
 
 	sort.Slice(clicks, func(i, j int) bool {
 		if clicks[i].NumClicks != clicks[j].NumClicks {
@@ -579,9 +1009,9 @@ func serveHome(w http.ResponseWriter, r *http.Request, short string) {
 	}
 
 	var long string
-	if short != "" && localClient != nil {
+	if short != "" && s.opts.LocalClient != nil {
 		// if a peer exists with the short name, suggest it as the long URL
-		st, err := localClient.Status(r.Context())
+		st, err := s.opts.LocalClient.Status(r.Context())
 		if err == nil {
 			for _, p := range st.Peer {
 				if host, _, ok := strings.Cut(p.DNSName, "."); ok && host == short {
@@ -592,52 +1022,64 @@ func serveHome(w http.ResponseWriter, r *http.Request, short string) {
 		}
 	}
 
-	cu, err := currentUser(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	homeTmpl.Execute(w, homeData{
+	s.homeTmpl.Execute(w, homeData{
 		Short:    short,
 		Long:     long,
 		Clicks:   clicks,
-		XSRF:     xsrftoken.Generate(xsrfKey, cu.login, newShortName),
-		ReadOnly: *readonly,
+		XSRF:     xsrftoken.Generate(s.xsrfKey, cu.login, newShortName),
+		ReadOnly: s.opts.ReadOnly,
 		User:     cu.login,
 	})
 }
 
-func serveAll(w http.ResponseWriter, _ *http.Request) {
-	if err := flushStats(); err != nil {
+func (s *Server) serveAll(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.flushStats(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	links, err := db.LoadAll()
+	links, err := s.db.LoadAll()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	searchTmpl.Execute(w, searchResults(links))
+	s.searchTmpl.Execute(w, s.searchResults(visibleLinks(links, cu)))
+}
+
+// helpData is the data used by the helpTmpl template.
+type helpData struct {
+	MaxChainDepth int
+
+	// HealthCheckInterval is how often link destinations are checked, or
+	// zero if they are not.
+	HealthCheckInterval time.Duration
 }
 
-func serveHelp(w http.ResponseWriter, _ *http.Request) {
-	helpTmpl.Execute(w, nil)
+func (s *Server) serveHelp(w http.ResponseWriter, _ *http.Request) {
+	s.helpTmpl.Execute(w, helpData{
+		MaxChainDepth:       maxChainDepth,
+		HealthCheckInterval: s.opts.HealthCheckInterval,
+	})
 }
 
-func serveOpenSearch(w http.ResponseWriter, _ *http.Request) {
+func (s *Server) serveOpenSearch(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
-	opensearchTmpl.Execute(w, nil)
+	s.opensearchTmpl.Execute(w, nil)
 }
 
-func serveGo(w http.ResponseWriter, r *http.Request) {
+func (s *Server) serveGo(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/" {
 		switch r.Method {
 		case "GET":
-			serveHome(w, r, "")
+			s.serveHome(w, r, "")
 		case "POST":
-			serveSave(w, r)
+			s.serveSave(w, r)
 		}
 		return
 	}
@@ -650,54 +1092,69 @@ func serveGo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	link, err := db.Load(short)
+	link, err := s.loadLink(short)
 	if errors.Is(err, fs.ErrNotExist) {
 		// Trim common punctuation from the end and try again.
 		// This catches auto-linking and copy/paste issues that include punctuation.
-		if s := strings.TrimRight(short, ".,()[]{}"); short != s {
-			short = s
-			link, err = db.Load(short)
+		if trimmed := strings.TrimRight(short, ".,()[]{}"); short != trimmed {
+			short = trimmed
+			link, err = s.loadLink(short)
 		}
 	}
 
 	if errors.Is(err, fs.ErrNotExist) {
-		clickNotFound.WithLabelValues(short).Inc()
+		s.metrics.notFound.WithLabelValues(short).Inc()
 		w.WriteHeader(http.StatusNotFound)
-		serveHome(w, r, short)
+		s.serveHome(w, r, short)
 		return
 	}
 	if err != nil {
-		clickNotFound.WithLabelValues(short).Inc()
+		s.metrics.notFound.WithLabelValues(short).Inc()
 		log.Printf("serving %q: %v", short, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	clickCounter.WithLabelValues(link.Short).Inc()
-
-	stats.mu.Lock()
-	if stats.clicks == nil {
-		stats.clicks = make(ClickStats)
-	}
-	stats.clicks[link.Short]++
-	if stats.dirty == nil {
-		stats.dirty = make(ClickStats)
-	}
-	stats.dirty[link.Short]++
-	stats.mu.Unlock()
-
-	cu, _ := currentUser(r)
-	env := expandEnv{Now: time.Now().UTC(), Path: remainder, user: cu.login, query: r.URL.Query()}
-	target, err := expandLink(link.Long, env)
+	// Each link in a chain of go links is checked as if it had been
+	// requested itself, and counted once the whole chain has resolved.
+	cu, _ := s.currentUser(r)
+	now := time.Now()
+	env := expandEnv{Now: now.UTC(), Path: remainder, user: cu.login, query: r.URL.Query()}
+	target, chain, err := s.resolveChain(link, env, func(l *Link) error {
+		if !canViewLink(l, cu) {
+			return fs.ErrPermission
+		}
+		if l.IsExpired(now) {
+			return errLinkExpired
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("expanding %q: %v", link.Long, err)
-		if errors.Is(err, errNoUser) {
+		last := chain[len(chain)-1]
+		var chainErr *chainError
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			if len(chain) > 1 {
+				short = last.Short
+			}
+			http.Error(w, fmt.Sprintf("you do not have access to %s/%s", s.opts.Hostname, short), http.StatusForbidden)
+		case errors.Is(err, errLinkExpired):
+			s.serveExpiredLink(w, r, last)
+		case errors.As(err, &chainErr):
+			log.Printf("resolving %q: %v", link.Short, err)
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+		case errors.Is(err, errNoUser):
+			log.Printf("expanding %q: %v", last.Long, err)
 			http.Error(w, "link requires a valid user", http.StatusUnauthorized)
-			return
+		default:
+			log.Printf("expanding %q: %v", last.Long, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	for _, l := range chain {
+		s.countClick(l)
+	}
 
 	// http.Redirect always cleans the redirect URL, which we don't always want.
 	// Instead, manually set status and Location header.
@@ -705,6 +1162,197 @@ func serveGo(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusFound)
 }
 
+// countClick records a click on link.
+func (s *Server) countClick(link *Link) {
+	s.metrics.clicks.WithLabelValues(link.Short).Inc()
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if s.stats.clicks == nil {
+		s.stats.clicks = make(ClickStats)
+	}
+	s.stats.clicks[link.Short]++
+	if s.stats.dirty == nil {
+		s.stats.dirty = make(ClickStats)
+	}
+	s.stats.dirty[link.Short]++
+}
+
+// maxChainDepth is the most links followed to resolve a link whose
+// destination is another go link, including the link itself.
+const maxChainDepth = 10
+
+// errLinkExpired is returned when resolving a link that has expired.
+var errLinkExpired = errors.New("link has expired")
+
+// chainError is returned when following a chain of go links never reaches a
+// destination outside of golink.
+type chainError struct {
+	Chain []string // short names of the links followed, in order
+	Cycle bool     // whether the last link is one already in the chain
+}
+
+func (e *chainError) Error() string {
+	chain := strings.Join(e.Chain, " -> ")
+	if e.Cycle {
+		return "link cycle: " + chain
+	}
+	return fmt.Sprintf("link chain longer than %d links: %s", maxChainDepth, chain)
+}
+
+// resolveChain expands the destination of link with env and, while that
+// destination is another go link on this server (see chainedShort), follows
+// it to the destination of that link. It returns the final destination
+// along with the links followed, starting with link.
+//
+// If visit is not nil, it is called with each link before it is expanded,
+// and any error it returns stops resolution. Chains that revisit a link or
+// are longer than maxChainDepth fail with a *chainError. Destinations that
+// name a go link that does not exist are returned as they are. link itself
+// need not have been saved, so that a new destination can be checked before
+// it is.
+func (s *Server) resolveChain(link *Link, env expandEnv, visit func(*Link) error) (*url.URL, []*Link, error) {
+	return s.resolveChainWith(link, env, visit, s.loadLink)
+}
+
+// resolveChainWith is like resolveChain, but looks up the links in the chain
+// with load, which returns an error wrapping fs.ErrNotExist for links that
+// do not exist.
+func (s *Server) resolveChainWith(link *Link, env expandEnv, visit func(*Link) error, load func(string) (*Link, error)) (*url.URL, []*Link, error) {
+	var chain []*Link
+	seen := make(map[string]*Link) // by link ID
+	for {
+		chain = append(chain, link)
+		if id := linkID(link.Short); seen[id] != nil {
+			return nil, chain, &chainError{Chain: chainShorts(chain), Cycle: true}
+		} else if len(chain) > maxChainDepth {
+			return nil, chain, &chainError{Chain: chainShorts(chain)}
+		} else {
+			seen[id] = link
+		}
+		if visit != nil {
+			if err := visit(link); err != nil {
+				return nil, chain, err
+			}
+		}
+		dst, err := expandLink(link.Long, env)
+		if err != nil {
+			return nil, chain, err
+		}
+		short, remainder, ok := s.chainedShort(dst)
+		if !ok {
+			return dst, chain, nil
+		}
+		// links already in the chain may not have been saved yet
+		next := seen[linkID(short)]
+		if next == nil {
+			next, err = load(short)
+			if errors.Is(err, fs.ErrNotExist) {
+				return dst, chain, nil
+			} else if err != nil {
+				return nil, chain, err
+			}
+		}
+		// the next link sees the request the browser would make when
+		// redirected to dst
+		env.Path = remainder
+		env.query = dst.Query()
+		link = next
+	}
+}
+
+// chainShorts returns the short names of the links in chain.
+func chainShorts(chain []*Link) []string {
+	shorts := make([]string, len(chain))
+	for i, link := range chain {
+		shorts[i] = link.Short
+	}
+	return shorts
+}
+
+// chainedShort returns the short name and remaining path of the go link
+// that dst points to, if dst is a link on this server: either a URL with
+// the Hostname option as its host, or a URL without a scheme or host, such
+// as "go/name" or "/name". If the Hostname option includes a port, as it
+// does in dev mode, dst must use the same port.
+func (s *Server) chainedShort(dst *url.URL) (short, remainder string, ok bool) {
+	host := dst.Hostname()
+	if strings.Contains(s.opts.Hostname, ":") {
+		host = dst.Host
+	}
+	path := dst.Path
+	switch {
+	case dst.Scheme == "" && dst.Host == "":
+		path = strings.TrimPrefix(path, s.opts.Hostname+"/")
+	case strings.EqualFold(host, s.opts.Hostname):
+	default:
+		return "", "", false
+	}
+	short, remainder, _ = strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if short == "" || strings.HasPrefix(short, ".") {
+		// the home page or another golink page
+		return "", "", false
+	}
+	return short, remainder, true
+}
+
+// expiredData is the data used by expiredTmpl.
+type expiredData struct {
+	Link     *Link
+	Editable bool // whether the current user can renew the link
+}
+
+// serveExpiredLink responds to a request for a link that has expired,
+// explaining who owned it rather than redirecting.
+func (s *Server) serveExpiredLink(w http.ResponseWriter, r *http.Request, link *Link) {
+	cu, _ := s.currentUser(r)
+	w.WriteHeader(http.StatusGone)
+	s.expiredTmpl.Execute(w, expiredData{
+		Link:     link,
+		Editable: !s.opts.ReadOnly && s.canEditLink(r.Context(), link, cu),
+	})
+}
+
+// serveExpiredLinks lists all expired links. It is only available to admins.
+func (s *Server) serveExpiredLinks(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cu.isAdmin {
+		http.Error(w, "only admins can list expired links", http.StatusForbidden)
+		return
+	}
+	links, err := s.db.GetExpiredLinks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if !links[i].ExpiresAt.Equal(links[j].ExpiresAt) {
+			return links[i].ExpiresAt.After(links[j].ExpiresAt)
+		}
+		return links[i].Short < links[j].Short
+	})
+
+	if !acceptHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+		return
+	}
+	s.expiredLinksTmpl.Execute(w, links)
+}
+
+// loadLink returns the link with the specified short name or alias.
+func (s *Server) loadLink(name string) (*Link, error) {
+	link, err := s.db.Load(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.db.LoadByAlias(name)
+	}
+	return link, err
+}
+
 // acceptHTML returns whether the request can accept a text/html response.
 func acceptHTML(r *http.Request) bool {
 	return strings.Contains(strings.ToLower(r.Header.Get("Accept")), "text/html")
@@ -719,24 +1367,33 @@ type detailData struct {
 	AlreadyExists bool
 }
 
-func serveDetail(w http.ResponseWriter, r *http.Request) {
+func (s *Server) serveDetail(w http.ResponseWriter, r *http.Request) {
 	short := strings.TrimPrefix(r.URL.Path, "/.detail/")
 
-	link, err := db.Load(short)
+	link, err := s.loadLink(short)
 	if errors.Is(err, fs.ErrNotExist) {
 		http.NotFound(w, r)
 		return
 	}
-	if short != link.Short {
-		// redirect to canonical short name
-		http.Redirect(w, r, "/.detail/"+link.Short, http.StatusFound)
+	if err != nil {
+		log.Printf("serving detail %q: %v", short, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	cu, err := s.currentUser(r)
 	if err != nil {
-		log.Printf("serving detail %q: %v", short, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !canViewLink(link, cu) {
+		http.Error(w, fmt.Sprintf("you do not have access to %s/%s", s.opts.Hostname, short), http.StatusForbidden)
+		return
+	}
+	if short != link.Short {
+		// redirect to canonical short name
+		http.Redirect(w, r, "/.detail/"+link.Short, http.StatusFound)
+		return
+	}
 
 	if !acceptHTML(r) {
 		w.Header().Set("Content-Type", "application/json")
@@ -746,13 +1403,8 @@ func serveDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cu, err := currentUser(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	canEdit := canEditLink(r.Context(), link, cu)
-	ownerExists, err := userExists(r.Context(), link.Owner)
+	canEdit := s.canEditLink(r.Context(), link, cu)
+	ownerExists, err := s.userExists(r.Context(), link.Owner)
 	if err != nil {
 		log.Printf("looking up tailnet user %q: %v", link.Owner, err)
 	}
@@ -760,7 +1412,7 @@ func serveDetail(w http.ResponseWriter, r *http.Request) {
 	data := detailData{
 		Link:     link,
 		Editable: canEdit,
-		XSRF:     xsrftoken.Generate(xsrfKey, cu.login, link.Short),
+		XSRF:     xsrftoken.Generate(s.xsrfKey, cu.login, link.Short),
 	}
 	if r.URL.Query().Get("exists") == "1" {
 		data.AlreadyExists = true
@@ -769,25 +1421,414 @@ func serveDetail(w http.ResponseWriter, r *http.Request) {
 		data.Link.Owner = cu.login
 	}
 
-	detailTmpl.Execute(w, data)
+	s.detailTmpl.Execute(w, data)
+}
+
+// historyEntry is a single edit of a link, as shown on the history page.
+type historyEntry struct {
+	ID      int64 // ID of the Revision that recorded this edit
+	Editor  string
+	Created time.Time
+
+	OldShort, NewShort string
+	OldLong, NewLong   string
+	OldOwner, NewOwner string
+}
+
+func (e historyEntry) ShortChanged() bool { return e.OldShort != e.NewShort }
+func (e historyEntry) LongChanged() bool  { return e.OldLong != e.NewLong }
+func (e historyEntry) OwnerChanged() bool { return e.OldOwner != e.NewOwner }
+
+// historyEntries pairs each revision of link with the values that replaced
+// it, so that each edit can be displayed as a diff. revs must be ordered
+// newest first, as returned by LoadRevisions.
+func historyEntries(link *Link, revs []*Revision) []historyEntry {
+	entries := make([]historyEntry, 0, len(revs))
+	short, long, owner := link.Short, link.Long, link.Owner
+	for _, rev := range revs {
+		entries = append(entries, historyEntry{
+			ID:       rev.ID,
+			Editor:   rev.Editor,
+			Created:  rev.Created,
+			OldShort: rev.Short,
+			NewShort: short,
+			OldLong:  rev.Long,
+			NewLong:  long,
+			OldOwner: rev.Owner,
+			NewOwner: owner,
+		})
+		short, long, owner = rev.Short, rev.Long, rev.Owner
+	}
+	return entries
+}
+
+// historyData is the data used by the historyTmpl template.
+type historyData struct {
+	Link    *Link
+	Entries []historyEntry
+	// Editable indicates whether the current user can revert the link.
+	Editable bool
+	XSRF     string
+}
+
+// serveHistory handles requests to /.history/{short}, listing the edits that
+// have been made to a link, newest first.
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
+	short := strings.TrimPrefix(r.URL.Path, "/.history/")
+
+	link, err := s.db.Load(short)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("serving history %q: %v", short, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !canViewLink(link, cu) {
+		http.Error(w, fmt.Sprintf("you do not have access to %s/%s", s.opts.Hostname, short), http.StatusForbidden)
+		return
+	}
+	if short != link.Short {
+		// redirect to canonical short name
+		http.Redirect(w, r, "/.history/"+link.Short, http.StatusFound)
+		return
+	}
+
+	revs, err := s.db.LoadRevisions(link.Short)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries := historyEntries(link, revs)
+
+	if !acceptHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+
+	s.historyTmpl.Execute(w, historyData{
+		Link:     link,
+		Entries:  entries,
+		Editable: s.canEditLink(r.Context(), link, cu),
+		XSRF:     xsrftoken.Generate(s.xsrfKey, cu.login, link.Short),
+	})
 }
 
-// serveSearch handles requests to /.search?q={query}, where {query} can currently only be
-// the owner formated like "owner:<email>".
-func serveSearch(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	owner, found := strings.CutPrefix(query, "owner:")
-	if !found {
-		http.Error(w, `search only supports "owner:<email>"`, http.StatusBadRequest)
+// serveRevert handles requests to /.revert/{short}, restoring the destination
+// and owner a link had before the edit recorded by the revision in the "rev"
+// form value. The revert is itself recorded as a new revision.
+func (s *Server) serveRevert(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "revert requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	short := strings.TrimPrefix(r.URL.Path, "/.revert/")
+	if short == "" {
+		http.Error(w, "short required", http.StatusBadRequest)
 		return
 	}
-	links, err := db.GetLinksByOwner(owner)
+	revID, err := strconv.ParseInt(r.FormValue("rev"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	cu, err := s.currentUser(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	searchTmpl.Execute(w, searchResults(links))
+	link, err := s.db.Load(short)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !s.canEditLink(r.Context(), link, cu) {
+		http.Error(w, fmt.Sprintf("cannot update link owned by %q", link.Owner), http.StatusForbidden)
+		return
+	}
+	if !s.isRequestAuthorized(r, cu, link.Short) {
+		http.Error(w, "invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+
+	revs, err := s.db.LoadRevisions(link.Short)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var rev *Revision
+	for _, rv := range revs {
+		if rv.ID == revID {
+			rev = rv
+			break
+		}
+	}
+	if rev == nil {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	// links may have changed since the revision was recorded, so the old
+	// destination could now make the link part of a cycle
+	var chainErr *chainError
+	env := expandEnv{Now: time.Now().UTC(), user: cu.login}
+	if _, _, err := s.resolveChain(&Link{Short: link.Short, Long: rev.Long}, env, nil); errors.As(err, &chainErr) {
+		http.Error(w, "invalid destination: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	prev := &Revision{
+		Short:   link.Short,
+		Long:    link.Long,
+		Owner:   link.Owner,
+		Editor:  cu.login,
+		Created: now,
+	}
+	link.Long = rev.Long
+	link.Owner = rev.Owner
+	link.LastEdit = now
+	if err := s.db.SaveWithRevision(link, prev); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if acceptHTML(r) {
+		http.Redirect(w, r, "/.history/"+url.PathEscape(link.Short), http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(link)
+	}
+}
+
+// serveSearch handles requests to /.search?q={query}. The query is matched
+// against link names, destinations, and descriptions, with results ranked by
+// relevance and click count. The query may include "owner:<email>" and
+// "tag:<tag>" to only return links with that owner or tag; a query of only
+// these filters lists all matching links.
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	q := parseSearchQuery(r.URL.Query().Get("q"))
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var links []*Link
+	var matches []LinkMatch
+	switch {
+	case len(q.terms) > 0:
+		matches, err = s.db.SearchLinks(q.terms)
+	case len(q.tags) > 0:
+		links, err = s.db.GetLinksByTag(q.tags[0])
+	case q.owner != "":
+		links, err = s.db.GetLinksByOwner(q.owner)
+	default:
+		http.Error(w, "search query required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(q.terms) > 0 {
+		filtered := matches[:0]
+		for _, m := range matches {
+			if q.matchesFilters(m.Link) && canViewLink(m.Link, cu) {
+				filtered = append(filtered, m)
+			}
+		}
+		s.searchTmpl.Execute(w, s.rankedSearchResults(filtered))
+		return
+	}
+	filtered := links[:0]
+	for _, link := range links {
+		if q.matchesFilters(link) && canViewLink(link, cu) {
+			filtered = append(filtered, link)
+		}
+	}
+	s.searchTmpl.Execute(w, s.searchResults(filtered))
+}
+
+// searchQuery is a parsed /.search query.
+type searchQuery struct {
+	owner string   // from "owner:<email>"; empty matches any owner
+	tags  []string // from "tag:<tag>", all of which must match
+	terms []string // free text terms, all of which must match
+}
+
+// parseSearchQuery parses a search query of space-separated terms.
+// Terms of the form "owner:<email>" and "tag:<tag>" restrict results to
+// that owner or tag rather than being matched as text.
+func parseSearchQuery(s string) searchQuery {
+	var q searchQuery
+	for _, f := range strings.Fields(s) {
+		if owner, ok := strings.CutPrefix(f, "owner:"); ok {
+			q.owner = owner
+			continue
+		}
+		if tag, ok := strings.CutPrefix(f, "tag:"); ok {
+			q.tags = append(q.tags, strings.ToLower(tag))
+			continue
+		}
+		q.terms = append(q.terms, f)
+	}
+	return q
+}
+
+// matchesFilters reports whether link has the query's owner and tags.
+func (q searchQuery) matchesFilters(link *Link) bool {
+	if q.owner != "" && !strings.EqualFold(link.Owner, q.owner) {
+		return false
+	}
+	for _, tag := range q.tags {
+		if !slices.Contains(link.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagCount is the number of links with a tag, used by tagsTmpl.
+type tagCount struct {
+	Tag   string
+	Count int
+}
+
+// serveTags lists all tags along with the number of links that have them,
+// most used first. Only links the current user can see are counted.
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	links, err := s.db.LoadAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[string]int)
+	for _, link := range visibleLinks(links, cu) {
+		for _, tag := range link.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]tagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, tagCount{Tag: tag, Count: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+
+	if !acceptHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+		return
+	}
+	s.tagsTmpl.Execute(w, tags)
+}
+
+// parseAliases parses a list of link aliases separated by commas or
+// whitespace. Each alias must be a valid short name.
+func parseAliases(s string) ([]string, error) {
+	aliases := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, alias := range aliases {
+		if !reShortName.MatchString(alias) {
+			return nil, fmt.Errorf("invalid alias %q: aliases may only contain letters, numbers, dash, and period", alias)
+		}
+	}
+	return aliases, nil
+}
+
+// expiresLayouts are the formats accepted by parseExpires. Times without a
+// zone are interpreted as UTC. The second is used by datetime-local inputs.
+var expiresLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// parseExpires parses a link expiration time, which must be after now.
+// An empty string means the link never expires, and returns the zero time.
+func parseExpires(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range expiresLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("expiration time %q is not in the future", s)
+		}
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiration time %q: use RFC 3339 format, such as %q", s, now.UTC().Add(24*time.Hour).Format(time.RFC3339))
+}
+
+// groupPrefix is the prefix of co-owners that name a group rather than a user.
+const groupPrefix = "group:"
+
+// reGroupName is the pattern a group name must match.
+var reGroupName = regexp.MustCompile(`^\w[\w\-\.]*$`)
+
+// parseCoOwners parses a list of co-owners separated by commas or
+// whitespace. Each is either a user login or a group in the form
+// "group:name". Whether the users exist is not checked.
+func parseCoOwners(s string) ([]string, error) {
+	coOwners := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, o := range coOwners {
+		if g, ok := strings.CutPrefix(o, groupPrefix); ok && !reGroupName.MatchString(g) {
+			return nil, fmt.Errorf("invalid group %q: group names may only contain letters, numbers, dash, and period", o)
+		}
+	}
+	return coOwners, nil
+}
+
+// reTagName is the pattern a link tag must match.
+var reTagName = regexp.MustCompile(`^\w[\w\-\.:/]*$`)
+
+// parseTags parses a list of tags separated by commas or whitespace,
+// returning them in the form they are stored.
+func parseTags(s string) ([]string, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, tag := range fields {
+		if !reTagName.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags may only contain letters, numbers, dash, period, colon, and slash", tag)
+		}
+	}
+	return cleanTags(fields), nil
 }
 
 type expandEnv struct {
@@ -872,30 +1913,63 @@ func expandLink(long string, env expandEnv) (*url.URL, error) {
 	return u, nil
 }
 
-func devMode() bool { return *dev != "" }
-
 const peerCapName = "tailscale.com/cap/golink"
 
 type capabilities struct {
 	Admin bool `json:"admin"`
+
+	// Groups are the golink groups the user belongs to. Links name a group
+	// as a co-owner in the form "group:name".
+	Groups []string `json:"groups"`
+
+	// Access names the restricted links the user can see: those with a
+	// Visibility of "access:name".
+	Access []string `json:"access"`
 }
 
 type user struct {
 	login   string
 	isAdmin bool
+	groups  []string // golink groups granted by the peer capability
+	access  []string // link access names granted by the peer capability
 }
 
-// currentUser returns the Tailscale user associated with the request.
+// userFromCaps returns the user with the specified login, with the admin
+// status, groups, and link access granted to them by the golink peer
+// capability.
+func userFromCaps(login string, capMap tailcfg.PeerCapMap) user {
+	u := user{login: login}
+	caps, _ := tailcfg.UnmarshalCapJSON[capabilities](capMap, peerCapName)
+	for _, cap := range caps {
+		if cap.Admin {
+			u.isAdmin = true
+		}
+		for _, g := range cap.Groups {
+			g = strings.TrimPrefix(g, groupPrefix)
+			if g != "" && !slices.Contains(u.groups, g) {
+				u.groups = append(u.groups, g)
+			}
+		}
+		for _, a := range cap.Access {
+			if a != "" && !slices.Contains(u.access, a) {
+				u.access = append(u.access, a)
+			}
+		}
+	}
+	return u
+}
+
+// lookupUser returns the Tailscale user associated with the request.
 // In most cases, this will be the user that owns the device that made the request.
 // For tagged devices, the value "tagged-devices" is returned.
 // If the user can't be determined (such as requests coming through a subnet router),
-// an error is returned unless the -allow-unknown-users flag is set.
+// an error is returned unless AllowUnknownUsers is set.
 //
 // When running as a Tailscale Service, authentication is handled via HTTP headers
 // automatically injected by tsnet's internal proxy (Tailscale-User-Login, etc.).
 // For regular mode, authentication uses WhoIs with the connection's RemoteAddr.
-var currentUser = func(r *http.Request) (user, error) {
-	if devMode() {
+func (s *Server) lookupUser(r *http.Request) (user, error) {
+	if s.opts.Dev {
 		return user{login: "foo@example.com"}, nil
 	}
 
@@ -903,45 +1977,38 @@ type user struct {
 	// by tsnet's internal proxy. Restrict this authentication check to cases
 	// when we are running in service mode, and the immediate client connection is
 	// on loopback.
-	if trustIdentityHeaders(r) {
-		headerUser := extractUserFromHeaders(r)
+	if s.trustIdentityHeaders(r) {
+		headerUser := s.extractUserFromHeaders(r)
 		if headerUser.login != "" {
 			return headerUser, nil
 		}
 	}
 
 	// Regular mode: use WhoIs with RemoteAddr
-	whois, err := localClient.WhoIs(r.Context(), r.RemoteAddr)
+	whois, err := s.opts.LocalClient.WhoIs(r.Context(), r.RemoteAddr)
 	if err != nil {
-		if *allowUnknownUsers {
+		if s.opts.AllowUnknownUsers {
 			// Don't report the error if we are allowing unknown users.
 			return user{}, nil
 		}
 		return user{}, err
 	}
-	login := whois.UserProfile.LoginName
-	caps, _ := tailcfg.UnmarshalCapJSON[capabilities](whois.CapMap, peerCapName)
-	for _, cap := range caps {
-		if cap.Admin {
-			return user{login: login, isAdmin: true}, nil
-		}
-	}
-	return user{login: login}, nil
+	return userFromCaps(whois.UserProfile.LoginName, whois.CapMap), nil
 }
 
 // trustIdentityHeaders returns whether we should trust identity headers injected by tsnet's internal proxy.
-var trustIdentityHeaders = func(r *http.Request) bool {
+func (s *Server) trustIdentityHeaders(r *http.Request) bool {
 	remoteHost := r.RemoteAddr
 	if host, _, err := net.SplitHostPort(remoteHost); err == nil {
 		remoteHost = host
 	}
 	remoteIP := net.ParseIP(remoteHost)
 
-	return *serviceName != "" && remoteIP != nil && remoteIP.IsLoopback()
+	return s.opts.ServiceName != "" && remoteIP != nil && remoteIP.IsLoopback()
 }
 
 // extractUserFromHeaders extracts the user from HTTP headers injected by tsnet's internal proxy.
-var extractUserFromHeaders = func(r *http.Request) user {
+func (s *Server) extractUserFromHeaders(r *http.Request) user {
 	if tsLogin := r.Header.Get("Tailscale-User-Login"); tsLogin != "" {
 		// Look for a peer from x-forwarded-for header. We'll use that for the
 		// whois/capmap lookup first.
@@ -957,48 +2024,36 @@ type user struct {
 				return user{login: tsLogin}
 			}
 
-			whois, err := whoisFunc(r.Context(), ip)
+			whois, err := s.whoisFunc(r.Context(), ip)
 
 			if err != nil {
 				log.Printf("WhoIs lookup for IP %q: %v", ip, err)
 				return user{login: tsLogin}
 			}
 
-			caps, _ := tailcfg.UnmarshalCapJSON[capabilities](whois.CapMap, peerCapName)
-
-			for _, cap := range caps {
-				if cap.Admin {
-					return user{login: tsLogin, isAdmin: true}
-				}
-			}
-
+			return userFromCaps(tsLogin, whois.CapMap)
 		}
 
-		// If we can't determine admin status, just return the user without admin privileges
+		// If we can't determine admin status or groups, just return the user without them.
 		// This allows the service to continue functioning even if the lookup fails
 		return user{login: tsLogin}
 	}
 	return user{}
 }
 
-// whoisFunc is a variable so it can be overridden in tests. By default, it calls localClient.WhoIs.
-var whoisFunc = func(ctx context.Context, ip string) (*apitype.WhoIsResponse, error) {
-	return localClient.WhoIs(ctx, ip)
-}
-
 // userExists returns whether a user exists with the specified login in the current tailnet.
-func userExists(ctx context.Context, login string) (bool, error) {
+func (s *Server) userExists(ctx context.Context, login string) (bool, error) {
 	const userTaggedDevices = "tagged-devices" // owner of tagged devices
 
 	if login == userTaggedDevices {
 		return false, nil
 	}
 
-	if devMode() {
+	if s.opts.Dev {
 		// in dev mode, just assume the user exists
 		return true, nil
 	}
-	st, err := localClient.Status(ctx)
+	st, err := s.opts.LocalClient.Status(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -1015,8 +2070,8 @@ func userExists(ctx context.Context, login string) (bool, error) {
 
 var reShortName = regexp.MustCompile(`^\w[\w\-\.]*$`)
 
-func serveDelete(w http.ResponseWriter, r *http.Request) {
-	if *readonly {
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
 		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
 		return
 	}
@@ -1026,19 +2081,23 @@ func serveDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cu, err := currentUser(r)
+	cu, err := s.currentUser(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	link, err := db.Load(short)
+	link, err := s.db.Load(short)
 	if errors.Is(err, fs.ErrNotExist) {
 		http.NotFound(w, r)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	if !canEditLink(r.Context(), link, cu) {
+	if !s.canEditLink(r.Context(), link, cu) {
 		http.Error(w, fmt.Sprintf("cannot delete link owned by %q", link.Owner), http.StatusForbidden)
 		return
 	}
@@ -1048,59 +2107,332 @@ func serveDelete(w http.ResponseWriter, r *http.Request) {
 	// want to enable deletion via CLI and to honor allowUnknownUsers for
 	// deletion, we could change the below to a call to isRequestAuthorized. For
 	// now, always require the XSRF token, thus maintaining the status quo.
-	if !xsrftoken.Valid(r.PostFormValue("xsrf"), xsrfKey, cu.login, link.Short) {
+	if !xsrftoken.Valid(r.PostFormValue("xsrf"), s.xsrfKey, cu.login, link.Short) {
+		http.Error(w, "invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// flush pending clicks so they are moved to the trash with the link
+	if err := s.flushStats(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.TrashLink(short, cu.login, time.Now().UTC()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.deleteLinkStats(link)
+
+	s.deleteTmpl.Execute(w, deleteData{
+		Short: link.Short,
+		Long:  link.Long,
+		XSRF:  xsrftoken.Generate(s.xsrfKey, cu.login, newShortName),
+	})
+}
+
+// serveRename handles requests to /.rename/{short}, renaming a link to the
+// name in the "short" form value. Its click stats and history move with it.
+// If the "alias" form value is true, the old name is kept as an alias of the
+// renamed link, so that existing uses of it keep working.
+func (s *Server) serveRename(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "rename requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	short := strings.TrimPrefix(r.URL.Path, "/.rename/")
+	if short == "" {
+		http.Error(w, "short required", http.StatusBadRequest)
+		return
+	}
+	newShort := strings.TrimSpace(r.FormValue("short"))
+	if newShort == "" {
+		http.Error(w, "new short name required", http.StatusBadRequest)
+		return
+	}
+	if !reShortName.MatchString(newShort) {
+		http.Error(w, "short may only contain letters, numbers, dash, and period", http.StatusBadRequest)
+		return
+	}
+	keepAlias, _ := strconv.ParseBool(r.FormValue("alias"))
+
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	link, err := s.db.Load(short)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !s.canEditLink(r.Context(), link, cu) {
+		http.Error(w, fmt.Sprintf("cannot rename link owned by %q", link.Owner), http.StatusForbidden)
+		return
+	}
+	if !s.isRequestAuthorized(r, cu, link.Short) {
+		http.Error(w, "invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// flush pending clicks so they are moved to the new name with the link
+	if err := s.flushStats(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = s.db.RenameLink(link.Short, newShort, keepAlias)
+	if errors.Is(err, ErrNameInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.renameLinkStats(link.Short, newShort)
+
+	// record the old name so the rename shows up in the link's history
+	if err := s.db.SaveRevision(&Revision{
+		Short:   link.Short,
+		Long:    link.Long,
+		Owner:   link.Owner,
+		Editor:  cu.login,
+		Created: time.Now().UTC(),
+		LinkID:  linkID(newShort),
+	}); err != nil {
+		log.Printf("recording rename of %q: %v", link.Short, err)
+	}
+
+	if acceptHTML(r) {
+		http.Redirect(w, r, "/.detail/"+url.PathEscape(newShort), http.StatusSeeOther)
+		return
+	}
+	renamed, err := s.db.Load(newShort)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(renamed)
+}
+
+// trashData is the data used by the trashTmpl template.
+type trashData struct {
+	Links     []trashEntry
+	Retention string // how long links are kept, or empty if forever
+	ReadOnly  bool
+}
+
+// trashEntry is a link in the trash, along with the XSRF token needed to
+// restore it.
+type trashEntry struct {
+	*TrashedLink
+	XSRF string
+}
+
+// canRestoreLink returns whether the specified user can see and restore a
+// link in the trash. Admin users can restore all links; other users can only
+// restore links they owned or co-owned.
+func canRestoreLink(link *TrashedLink, u user) bool {
+	return u.isAdmin || isLinkOwner(&link.Link, u)
+}
+
+// serveTrash handles requests to /.trash, listing the deleted links that the
+// current user can restore, most recently deleted first.
+func (s *Server) serveTrash(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	trashed, err := s.db.LoadTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var links []*TrashedLink
+	for _, link := range trashed {
+		if canRestoreLink(link, cu) {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if !links[i].Deleted.Equal(links[j].Deleted) {
+			return links[i].Deleted.After(links[j].Deleted)
+		}
+		return links[i].Short < links[j].Short
+	})
+
+	if !acceptHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(links)
+		return
+	}
+
+	data := trashData{ReadOnly: s.opts.ReadOnly}
+	if d := s.opts.TrashRetention; d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		data.Retention = fmt.Sprintf("%d days", d/(24*time.Hour))
+	} else if d > 0 {
+		data.Retention = d.String()
+	}
+	for _, link := range links {
+		data.Links = append(data.Links, trashEntry{
+			TrashedLink: link,
+			XSRF:        xsrftoken.Generate(s.xsrfKey, cu.login, link.Short),
+		})
+	}
+	s.trashTmpl.Execute(w, data)
+}
+
+// serveRestore handles requests to /.restore/{short}, moving a link and its
+// click stats out of the trash.
+func (s *Server) serveRestore(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "restore requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	short := strings.TrimPrefix(r.URL.Path, "/.restore/")
+	if short == "" {
+		http.Error(w, "short required", http.StatusBadRequest)
+		return
+	}
+
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	trashed, err := s.db.LoadTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var link *TrashedLink
+	for _, l := range trashed {
+		if linkID(l.Short) == linkID(short) {
+			link = l
+			break
+		}
+	}
+	if link == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !canRestoreLink(link, cu) {
+		http.Error(w, fmt.Sprintf("cannot restore link owned by %q", link.Owner), http.StatusForbidden)
+		return
+	}
+	if !s.isRequestAuthorized(r, cu, link.Short) {
 		http.Error(w, "invalid XSRF token", http.StatusBadRequest)
 		return
 	}
 
-	if err := db.Delete(short); err != nil {
+	err = s.db.RestoreLink(link.Short)
+	if errors.Is(err, fs.ErrExist) {
+		http.Error(w, fmt.Sprintf("a link named %q already exists", link.Short), http.StatusConflict)
+		return
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	deleteLinkStats(link)
+	if err := s.restoreLinkStats(&link.Link); err != nil {
+		log.Printf("restoring stats for %q: %v", link.Short, err)
+	}
 
-	deleteTmpl.Execute(w, deleteData{
-		Short: link.Short,
-		Long:  link.Long,
-		XSRF:  xsrftoken.Generate(xsrfKey, cu.login, newShortName),
-	})
+	if acceptHTML(r) {
+		s.successTmpl.Execute(w, homeData{Short: link.Short})
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(link.Link)
+	}
+}
+
+// validateShortLong reports whether short is a valid link name and long a
+// valid destination, which may be a template.
+func validateShortLong(short, long string) error {
+	if short == "" || long == "" {
+		return errors.New("short and long required")
+	}
+	if !reShortName.MatchString(short) {
+		return errors.New("short may only contain letters, numbers, dash, and period")
+	}
+	if _, err := texttemplate.New("").Funcs(expandFuncMap).Parse(long); err != nil {
+		return fmt.Errorf("long contains an invalid template: %v", err)
+	}
+	return nil
 }
 
 // serveSave handles requests to save or update a Link.  Both short name and
 // long URL are validated for proper format. Existing links may only be updated
 // by their owner.
-func serveSave(w http.ResponseWriter, r *http.Request) {
-	if *readonly {
+func (s *Server) serveSave(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
 		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
 		return
 	}
 	short, long := r.FormValue("short"), r.FormValue("long")
-	if short == "" || long == "" {
-		http.Error(w, "short and long required", http.StatusBadRequest)
+	if err := validateShortLong(short, long); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !reShortName.MatchString(short) {
-		http.Error(w, "short may only contain letters, numbers, dash, and period", http.StatusBadRequest)
+	tags, err := parseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if _, err := texttemplate.New("").Funcs(expandFuncMap).Parse(long); err != nil {
-		http.Error(w, fmt.Sprintf("long contains an invalid template: %v", err), http.StatusBadRequest)
+	aliases, err := parseAliases(r.FormValue("aliases"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expires, err := parseExpires(r.FormValue("expires"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coOwners, err := parseCoOwners(r.FormValue("coowners"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visibility, err := parseVisibility(r.FormValue("visibility"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	cu, err := currentUser(r)
+	cu, err := s.currentUser(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	link, err := db.Load(short)
+	link, err := s.db.Load(short)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !canEditLink(r.Context(), link, cu) {
+	if !s.canEditLink(r.Context(), link, cu) {
 		http.Error(w, fmt.Sprintf("cannot update link owned by %q", link.Owner), http.StatusForbidden)
 		return
 	}
@@ -1116,8 +2448,8 @@ func serveSave(w http.ResponseWriter, r *http.Request) {
 		tokenShortName = link.Short
 	}
 
-	if !isRequestAuthorized(r, cu, tokenShortName) {
-		if link != nil && isRequestAuthorized(r, cu, newShortName) {
+	if !s.isRequestAuthorized(r, cu, tokenShortName) {
+		if link != nil && s.isRequestAuthorized(r, cu, newShortName) {
 			// The user submitted from the home page create form but the link
 			// already exists. Redirect to the detail page so they can edit it
 			// intentionally rather than accidentally overwriting it.
@@ -1131,7 +2463,7 @@ func serveSave(w http.ResponseWriter, r *http.Request) {
 	// allow transferring ownership to valid users. If empty, set owner to current user.
 	owner := r.FormValue("owner")
 	if owner != "" {
-		exists, err := userExists(r.Context(), owner)
+		exists, err := s.userExists(r.Context(), owner)
 		if err != nil {
 			log.Printf("looking up tailnet user %q: %v", owner, err)
 		}
@@ -1142,42 +2474,104 @@ func serveSave(w http.ResponseWriter, r *http.Request) {
 	} else {
 		owner = cu.login
 	}
+	for _, o := range coOwners {
+		if strings.HasPrefix(o, groupPrefix) {
+			continue
+		}
+		exists, err := s.userExists(r.Context(), o)
+		if err != nil {
+			log.Printf("looking up tailnet user %q: %v", o, err)
+		}
+		if !exists {
+			http.Error(w, "co-owner not a valid user: "+o, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// reject destinations that would make the link part of a chain of go
+	// links that cannot be resolved
+	var chainErr *chainError
+	env := expandEnv{Now: time.Now().UTC(), user: cu.login}
+	if _, _, err := s.resolveChain(&Link{Short: short, Long: long}, env, nil); errors.As(err, &chainErr) {
+		http.Error(w, "invalid destination: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	now := time.Now().UTC()
 	newLink := false
+	var prev *Revision
 	if link == nil {
 		link = &Link{
 			Short:   short,
 			Created: now,
 		}
 		newLink = true
+	} else if link.Short != short || link.Long != long || link.Owner != owner {
+		// keep the previous version so the edit can be reviewed or reverted
+		prev = &Revision{
+			Short:   link.Short,
+			Long:    link.Long,
+			Owner:   link.Owner,
+			Editor:  cu.login,
+			Created: now,
+		}
 	}
 	link.Short = short
 	link.Long = long
 	link.LastEdit = now
 	link.Owner = owner
-	if err := db.Save(link); err != nil {
+	if _, ok := r.Form["description"]; ok {
+		// API clients that predate descriptions don't send one,
+		// so only update the description if it was provided.
+		link.Description = strings.TrimSpace(r.FormValue("description"))
+	}
+	if _, ok := r.Form["tags"]; ok {
+		link.Tags = tags
+	}
+	if _, ok := r.Form["aliases"]; ok {
+		link.Aliases = aliases
+	}
+	if _, ok := r.Form["coowners"]; ok {
+		link.CoOwners = coOwners
+	}
+	if _, ok := r.Form["visibility"]; ok {
+		link.Visibility = visibility
+	}
+	if _, ok := r.Form["expires"]; ok {
+		link.ExpiresAt = expires
+		link.Expired = false
+	}
+	if prev != nil {
+		err = s.db.SaveWithRevision(link, prev)
+	} else {
+		err = s.db.Save(link)
+	}
+	if errors.Is(err, ErrNameInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if acceptHTML(r) {
-		successTmpl.Execute(w, homeData{Short: short})
+		s.successTmpl.Execute(w, homeData{Short: short})
 	} else {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(link)
 	}
 	// If this is a new link and not an update inc
 	if newLink {
-		totalLinkCount.Inc()
+		s.metrics.totalLinks.Inc()
 	}
 }
 
 // canEditLink returns whether the specified user has permission to edit link.
 // Admin users can edit all links.
-// Non-admin users can only edit their own links or links without an active owner.
-func canEditLink(ctx context.Context, link *Link, u user) bool {
-	if *readonly {
+// Non-admin users can only edit links they own or co-own, either directly or
+// through a group, and links without an active owner.
+func (s *Server) canEditLink(ctx context.Context, link *Link, u user) bool {
+	if s.opts.ReadOnly {
 		return false
 	}
 	if link == nil || link.Owner == "" {
@@ -1185,11 +2579,11 @@ func canEditLink(ctx context.Context, link *Link, u user) bool {
 		return true
 	}
 
-	if u.isAdmin || link.Owner == u.login {
+	if u.isAdmin || isLinkOwner(link, u) {
 		return true
 	}
 
-	owned, err := userExists(ctx, link.Owner)
+	owned, err := s.userExists(ctx, link.Owner)
 	if err != nil {
 		log.Printf("looking up tailnet user %q: %v", link.Owner, err)
 	}
@@ -1197,127 +2591,515 @@ func canEditLink(ctx context.Context, link *Link, u user) bool {
 	return err == nil && !owned
 }
 
-// serveExport prints a snapshot of the link database. Links are JSON encoded
-// and printed one per line. This format is used to restore link snapshots on
-// startup.
-func serveExport(w http.ResponseWriter, _ *http.Request) {
-	if err := flushStats(); err != nil {
+// Link visibilities, as stored in Link.Visibility.
+const (
+	visibilityPublic       = ""
+	visibilityOwner        = "owner"
+	visibilityAccessPrefix = "access:"
+)
+
+// canViewLink returns whether the specified user can see and resolve link.
+// Public links are visible to everyone. Admins and the link's owners and
+// co-owners can see all links; links restricted to an access name are also
+// visible to users granted that name by the golink peer capability.
+func canViewLink(link *Link, u user) bool {
+	if link.Visibility == visibilityPublic || u.isAdmin || isLinkOwner(link, u) {
+		return true
+	}
+	name, ok := strings.CutPrefix(link.Visibility, visibilityAccessPrefix)
+	return ok && slices.Contains(u.access, name)
+}
+
+// visibleLinks returns the links that u can see, reusing the backing array
+// of links.
+func visibleLinks(links []*Link, u user) []*Link {
+	visible := links[:0]
+	for _, link := range links {
+		if canViewLink(link, u) {
+			visible = append(visible, link)
+		}
+	}
+	return visible
+}
+
+// parseVisibility parses a link visibility: "public" or empty, "owner", or
+// "access:name". It returns the visibility in the form it is stored.
+func parseVisibility(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "public":
+		return visibilityPublic, nil
+	case visibilityOwner:
+		return s, nil
+	}
+	if name, ok := strings.CutPrefix(s, visibilityAccessPrefix); ok && reGroupName.MatchString(name) {
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid visibility %q: use %q, %q, or %q", s, "public", visibilityOwner, visibilityAccessPrefix+"name")
+}
+
+// isLinkOwner returns whether u is the owner or one of the co-owners of link,
+// either directly or as a member of a co-owning group.
+func isLinkOwner(link *Link, u user) bool {
+	if u.login == "" {
+		return false
+	}
+	if link.Owner == u.login {
+		return true
+	}
+	for _, o := range link.CoOwners {
+		if o == u.login {
+			return true
+		}
+		if g, ok := strings.CutPrefix(o, groupPrefix); ok && slices.Contains(u.groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveExport prints a snapshot of the link database, sorted by short name.
+//
+// By default, links are JSON encoded, one per line, in the format read by
+// restoreSnapshot. A "format" parameter or Accept header selects CSV,
+// YAML, or a Netscape bookmarks file instead (see exportFormat). If the
+// "clicks" parameter is true, each link's total clicks are included.
+// JSON lines snapshots also include the links' click stats records if
+// "stats" is true, and their history if "history" is true, which
+// restoreSnapshot restores along with the links. Links the current user
+// cannot see are omitted.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.flushStats(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	links, err := db.LoadAll()
+	links, err := s.db.LoadAll()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	sort.Slice(links, func(i, j int) bool {
-		return links[i].Short < links[j].Short
-	})
-	encoder := json.NewEncoder(w)
-	for _, link := range links {
-		if err := encoder.Encode(link); err != nil {
-			panic(http.ErrAbortHandler)
+	links = visibleLinks(links, cu)
+	withStats, _ := strconv.ParseBool(r.FormValue("stats"))
+	withHistory, _ := strconv.ParseBool(r.FormValue("history"))
+	opts, err := loadSnapshotOptions(s.db, links, withStats && format == exportJSONL, withHistory && format == exportJSONL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if withClicks, _ := strconv.ParseBool(r.FormValue("clicks")); withClicks {
+		opts.Clicks, err = s.db.LoadStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if opts.Clicks == nil {
+			opts.Clicks = make(ClickStats)
 		}
 	}
+
+	if h, ok := exportHeaders[format]; ok {
+		w.Header().Set("Content-Type", h.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", h.filename))
+	}
+	w.Header().Add("Vary", "Accept")
+	if err := writeExport(w, format, s.opts.Hostname, links, opts); err != nil {
+		panic(http.ErrAbortHandler)
+	}
 }
 
 // serveExportStats prints a snapshot of the stats database table.
 //
 // Stats are printed in CSV format with three columns: link ID, UNIX timestamp, and click count.
-// Each stat line represents the number of clicks in the previous minute.
-func serveExportStats(w http.ResponseWriter, _ *http.Request) {
-	if err := flushStats(); err != nil {
+// Each stat line represents the number of clicks in the previous minute, or,
+// for older stats that have been rolled up, in the hour or day starting at the timestamp.
+// Stats of links the current user cannot see are omitted.
+func (s *Server) serveExportStats(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.flushStats(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	rows, err := db.db.Query("SELECT ID, Created, Clicks FROM Stats ORDER BY Created, ID")
+	links, err := s.db.LoadAll()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		rows.Close()
-		if err := rows.Err(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	hidden := make(map[string]bool)
+	for _, link := range links {
+		if !canViewLink(link, cu) {
+			hidden[linkID(link.Short)] = true
+		}
+	}
+	records, err := s.db.LoadStatsRecords()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, r := range records {
+		if hidden[r.ID] {
+			continue
 		}
-	}()
+		// id is not permitted to contain commas, so no need to worry about CSV quoting
+		fmt.Fprintf(w, "%s,%d,%d\n", r.ID, r.Created.Unix(), r.Clicks)
+	}
+}
+
+// serveBackup streams a consistent copy of the entire SQLite database,
+// including stats, history and trash, which can be restored with --restore-db.
+// It is only available to admins.
+func (s *Server) serveBackup(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cu.isAdmin {
+		http.Error(w, "only admins can download backups", http.StatusForbidden)
+		return
+	}
+	sqliteDB, ok := baseStore(s.db).(*SQLiteDB)
+	if !ok {
+		http.Error(w, "database backups are only supported with --sqlitedb", http.StatusNotImplemented)
+		return
+	}
+	if err := s.flushStats(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tmpdir, err := os.MkdirTemp("", "golink_backup_*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	now := time.Now()
+	name := filepath.Join(tmpdir, "golink.db")
+	if err := sqliteDB.Backup(r.Context(), name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	filename := "golink-" + now.UTC().Format(backupTimeFormat) + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	http.ServeContent(w, r, "", now, f)
+}
+
+// maxImportSize is the largest snapshot accepted by serveImport.
+const maxImportSize = 64 << 20
+
+// serveImport merges links into the database.
+//
+// The links are read from the "file" field of a multipart form, or else
+// from the request body, in the format named by the "format" parameter (see
+// readImport). If it is empty, the format is chosen by the uploaded file's
+// extension, defaulting to the /.export format. The "mode" parameter selects
+// how links that already exist are merged (see ParseImportMode), and if
+// "dryrun" is true nothing is saved. Imported links without an owner are
+// owned by the current user.
+//
+// The response is a JSON ImportReport. If any row is rejected, nothing is
+// imported unless this is a dry run. It is only available to admins.
+func (s *Server) serveImport(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		http.Error(w, "golink is in read-only mode", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "import requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cu.isAdmin {
+		http.Error(w, "only admins can import links", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if !s.isRequestAuthorized(r, cu, ".import") {
+		http.Error(w, "invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+	mode, err := ParseImportMode(r.FormValue("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dryrun"))
+	format := r.FormValue("format")
 
-	for rows.Next() {
-		var id string
-		var created int64
-		var clicks int
-		err := rows.Scan(&id, &created, &clicks)
+	var in io.Reader = r.Body
+	if r.MultipartForm != nil {
+		f, fh, err := r.FormFile("file")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "file required", http.StatusBadRequest)
 			return
 		}
-		// id is not permitted to contain commas, so no need to worry about CSV quoting
-		fmt.Fprintf(w, "%s,%d,%d\n", id, created, clicks)
+		defer f.Close()
+		in = f
+		if format == "" {
+			format = importFormatForFile(fh.Filename)
+		}
+	}
+	rows, rowErrs, err := readImport(format, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fillImportDefaults(importRowLinks(rows), cu.login, time.Now().UTC())
+	rows, chainErrs, err := s.checkImportChains(rows, mode)
+	if errors.Is(err, ErrNameInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rowErrs = sortImportErrors(append(rowErrs, chainErrs...))
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(rowErrs) > 0 && !dryRun {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&ImportReport{Errors: rowErrs})
+		return
+	}
+	report, err := s.db.ImportLinks(importRowLinks(rows), mode, cu.login, dryRun)
+	if errors.Is(err, ErrNameInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.Errors = rowErrs
+	if !dryRun {
+		if err := s.initMetricsData(); err != nil {
+			log.Printf("updating metrics after import: %v", err)
+		}
 	}
+	json.NewEncoder(w).Encode(report)
 }
 
-func restoreLastSnapshot() error {
-	bs := bufio.NewScanner(bytes.NewReader(LastSnapshot))
-	var restored int
-	for bs.Scan() {
-		link := new(Link)
-		if err := json.Unmarshal(bs.Bytes(), link); err != nil {
-			return err
+// fillImportDefaults sets the owner of imported links that have none, and
+// the creation and edit times of those that were not exported with them.
+func fillImportDefaults(links []*Link, owner string, now time.Time) {
+	for _, link := range links {
+		if link.Owner == "" {
+			link.Owner = owner
+		}
+		if link.Created.IsZero() {
+			link.Created = now
+		}
+		if link.LastEdit.IsZero() {
+			link.LastEdit = link.Created
+		}
+	}
+}
+
+// importLinksFromFile imports the links in the named file into db and prints
+// what was done with each. The format is chosen by the file's extension if
+// empty, and modeName is parsed by ParseImportMode. Links on hostname are
+// followed to reject imports that create link cycles. If any row is
+// rejected, nothing is imported.
+func importLinksFromFile(db LinkStore, hostname, name, format, modeName string, dryRun bool) error {
+	mode, err := ParseImportMode(modeName)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = importFormatForFile(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, rowErrs, err := readImport(format, f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	fillImportDefaults(importRowLinks(rows), "", time.Now().UTC())
+	// following link chains needs only the store and hostname
+	s := &Server{opts: Options{Store: db, Hostname: hostname}, db: db}
+	rows, chainErrs, err := s.checkImportChains(rows, mode)
+	if err != nil {
+		return err
+	}
+	rowErrs = sortImportErrors(append(rowErrs, chainErrs...))
+	for _, e := range rowErrs {
+		fmt.Printf("%s:%d: %s\n", name, e.Row, e.Error)
+	}
+	if len(rowErrs) > 0 && !dryRun {
+		return fmt.Errorf("%d rows of %s rejected; nothing imported", len(rowErrs), name)
+	}
+
+	links := importRowLinks(rows)
+	report, err := db.ImportLinks(links, mode, "", dryRun)
+	if err != nil {
+		return err
+	}
+	for _, short := range report.Created {
+		fmt.Printf("created %s\n", short)
+	}
+	for _, short := range report.Changed {
+		fmt.Printf("changed %s\n", short)
+	}
+	for _, short := range report.Skipped {
+		fmt.Printf("skipped %s\n", short)
+	}
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Dry run: would import"
+	}
+	log.Printf("%s %d links from %s: %d created, %d changed, %d skipped, %d rejected.", verb, len(links), name, len(report.Created), len(report.Changed), len(report.Skipped), len(rowErrs))
+	return nil
+}
+
+// restoreSnapshot adds the links in the Snapshot option that don't
+// already exist to the database. If the snapshot includes stats and history, those
+// of the restored links are restored too.
+func (s *Server) restoreSnapshot() error {
+	snap, err := readSnapshot(bytes.NewReader(s.opts.Snapshot))
+	if err != nil {
+		return err
+	}
+	restored := make(map[string]bool) // link IDs
+	for _, row := range snap.Links {
+		if row.Err != nil {
+			return fmt.Errorf("line %d: %w", row.Row, row.Err)
 		}
+		link := row.Link
 		if link.Short == "" {
 			continue
 		}
-		_, err := db.Load(link.Short)
+		_, err := s.db.Load(link.Short)
 		if err == nil {
 			continue // exists
 		} else if !errors.Is(err, fs.ErrNotExist) {
 			return err
 		}
-		if err := db.Save(link); err != nil {
+		if err := s.db.Save(link); errors.Is(err, ErrNameInUse) {
+			log.Printf("not restoring %q: %v", link.Short, err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		restored[linkID(link.Short)] = true
+	}
+
+	var records []StatsRecord
+	for _, rec := range snap.Stats {
+		if restored[rec.ID] {
+			records = append(records, rec)
+		}
+	}
+	if len(records) > 0 {
+		if err := s.db.SaveStatsRecords(records); err != nil {
+			return err
+		}
+	}
+	var revs int
+	for _, rev := range snap.Revisions {
+		if !restored[revisionLinkID(rev)] {
+			continue
+		}
+		if err := s.db.SaveRevision(rev); err != nil {
 			return err
 		}
-		restored++
+		revs++
+	}
+
+	if len(restored) > 0 && s.opts.Verbose {
+		log.Printf("Restored %v links, %v stats records, and %v revisions.", len(restored), len(records), revs)
+	}
+	return nil
+}
+
+// copySQLiteToPostgres copies the links in the --sqlitedb database into the
+// --postgres-dsn database.
+func copySQLiteToPostgres(sqlitefile, postgresDSN string) error {
+	if sqlitefile == "" || postgresDSN == "" {
+		return errors.New("--copy-to-postgres requires --sqlitedb and --postgres-dsn")
+	}
+	src, err := NewSQLiteDB(sqlitefile)
+	if err != nil {
+		return fmt.Errorf("NewSQLiteDB(%q): %w", sqlitefile, err)
+	}
+	dst, err := NewPostgresDB(postgresDSN)
+	if err != nil {
+		return fmt.Errorf("NewPostgresDB: %w", err)
 	}
-	if restored > 0 && *verbose {
-		log.Printf("Restored %v links.", restored)
+	defer dst.Close()
+
+	n, err := CopySQLiteToPostgres(context.Background(), src, dst)
+	if err != nil {
+		return err
 	}
-	return bs.Err()
+	log.Printf("Copied %d links from %s to PostgreSQL.", n, sqlitefile)
+	return nil
 }
 
-func resolveLink(link *url.URL) (*url.URL, error) {
+func (s *Server) resolveLink(link *url.URL) (*url.URL, error) {
 	path := link.Path
 
 	// if link was specified as "go/name", it will parse with no scheme or host.
 	// Trim "go" prefix from beginning of path.
 	if link.Host == "" {
-		path = strings.TrimPrefix(path, *hostname)
+		path = strings.TrimPrefix(path, s.opts.Hostname)
 	}
 
 	short, remainder, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
-	l, err := db.Load(short)
+	l, err := s.loadLink(short)
 	if err != nil {
 		return nil, err
 	}
-	dst, err := expandLink(l.Long, expandEnv{Now: time.Now().UTC(), Path: remainder})
-	if err == nil {
-		if dst.Host == "" || dst.Host == *hostname {
-			dst, err = resolveLink(dst)
+	now := time.Now()
+	dst, _, err := s.resolveChain(l, expandEnv{Now: now.UTC(), Path: remainder}, func(l *Link) error {
+		if l.IsExpired(now) {
+			return fmt.Errorf("link %q has expired", l.Short)
 		}
-	}
+		return nil
+	})
 	return dst, err
 }
 
-func isRequestAuthorized(r *http.Request, u user, short string) bool {
-	if *allowUnknownUsers {
+func (s *Server) isRequestAuthorized(r *http.Request, u user, short string) bool {
+	if s.opts.AllowUnknownUsers {
 		return true
 	}
 	if r.Header.Get(secHeaderName) != "" {
 		return true
 	}
 
-	return xsrftoken.Valid(r.PostFormValue("xsrf"), xsrfKey, u.login, short)
+	return xsrftoken.Valid(r.PostFormValue("xsrf"), s.xsrfKey, u.login, short)
 }
 
 // parseAdvertiseTags parses a comma-separated list of ACL tags.
@@ -4,40 +4,68 @@
 package golink
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/xsrftoken"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
-	"tailscale.com/types/ptr"
 	"tailscale.com/util/must"
 )
 
-func init() {
-	// tests always need golink to be run in dev mode
-	*dev = ":8080"
+// newTestServer returns a Server that stores links in db. Tests always need
+// golink to be run in dev mode.
+func newTestServer(t testing.TB, db LinkStore) *Server {
+	t.Helper()
+	s, err := NewServer(Options{Store: db, Dev: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
 }
 
 func TestServeGo(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
+	db, err := NewSQLiteDB(":memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
-	db.Save(&Link{Short: "who", Long: "http://who/"})
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/", Aliases: []string{"whois"}})
 	db.Save(&Link{Short: "me", Long: "/who/{{.User}}"})
 	db.Save(&Link{Short: "invalid-var", Long: "/who/{{.Invalid}}"})
+	db.Save(&Link{Short: "people", Long: "go/who-is/people"})
+	db.Save(&Link{Short: "search", Long: "http://go/people/{{.Path}}?from=search"})
+	db.Save(&Link{Short: "missing", Long: "/does-not-exist/p"})
+	db.Save(&Link{Short: "ping", Long: "http://go/pong"})
+	db.Save(&Link{Short: "pong", Long: "/ping"})
+	db.Save(&Link{Short: "secret", Long: "http://secret/", Owner: "bar@example.com", Visibility: visibilityOwner})
+	db.Save(&Link{Short: "to-secret", Long: "/secret"})
+	db.Save(&Link{Short: "old", Long: "http://old/", ExpiresAt: time.Now().Add(-time.Hour)})
+	db.Save(&Link{Short: "to-old", Long: "/old"})
+	for i := range maxChainDepth {
+		db.Save(&Link{Short: fmt.Sprintf("deep%d", i), Long: fmt.Sprintf("/deep%d", i+1)})
+	}
+	db.Save(&Link{Short: fmt.Sprintf("deep%d", maxChainDepth), Long: "http://deep/"})
 
 	tests := []struct {
 		name        string
@@ -112,7 +140,63 @@ func TestServeGo(t *testing.T) {
 			name:       "user link",
 			link:       "/me",
 			wantStatus: http.StatusFound,
-			wantLink:   "/who/foo@example.com",
+			wantLink:   "http://who/foo@example.com",
+		},
+		{
+			name:       "chained link",
+			link:       "/people/p",
+			wantStatus: http.StatusFound,
+			wantLink:   "http://who/people/p",
+		},
+		{
+			name:       "chained link with path and query",
+			link:       "/search/p?q=1",
+			wantStatus: http.StatusFound,
+			wantLink:   "http://who/people/p?from=search&q=1",
+		},
+		{
+			name:       "chained to unknown link",
+			link:       "/missing",
+			wantStatus: http.StatusFound,
+			wantLink:   "/does-not-exist/p",
+		},
+		{
+			name:       "chained cycle",
+			link:       "/ping",
+			wantStatus: http.StatusLoopDetected,
+		},
+		{
+			name:       "chained to the depth limit",
+			link:       "/deep1",
+			wantStatus: http.StatusFound,
+			wantLink:   "http://deep/",
+		},
+		{
+			name:       "chained deeper than limit",
+			link:       "/deep0",
+			wantStatus: http.StatusLoopDetected,
+		},
+		{
+			name:       "chained to hidden link",
+			link:       "/to-secret",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "chained to expired link",
+			link:       "/to-old",
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "alias with path",
+			link:       "/who-is/p",
+			wantStatus: http.StatusFound,
+			wantLink:   "http://who/p",
+		},
+		{
+			name:       "alias, trailing period",
+			link:       "/whois.",
+			wantStatus: http.StatusFound,
+			wantLink:   "http://who/",
 		},
 		{
 			name:       "unknown link",
@@ -135,16 +219,12 @@ func TestServeGo(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.currentUser != nil {
-				oldCurrentUser := currentUser
-				currentUser = tt.currentUser
-				t.Cleanup(func() {
-					currentUser = oldCurrentUser
-				})
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
 			}
 
 			r := httptest.NewRequest("GET", tt.link, nil)
 			w := httptest.NewRecorder()
-			serveHandler().ServeHTTP(w, r)
+			s.Handler().ServeHTTP(w, r)
 
 			if w.Code != tt.wantStatus {
 				t.Errorf("serveGo(%q) = %d; want %d", tt.link, w.Code, tt.wantStatus)
@@ -154,21 +234,29 @@ func TestServeGo(t *testing.T) {
 			}
 		})
 	}
+
+	// links are only counted when their whole chain resolves
+	for _, short := range []string{"ping", "pong", "deep0", "to-secret", "to-old"} {
+		if n := s.stats.clicks[short]; n != 0 {
+			t.Errorf("clicks[%q] = %d; want 0", short, n)
+		}
+	}
+	if n := s.stats.clicks["deep1"]; n != 1 {
+		t.Errorf("clicks[%q] = %d; want 1", "deep1", n)
+	}
 }
 
 func TestServeSave(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
-	if err != nil {
-		t.Fatal(err)
-	}
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
 	db.Save(&Link{Short: "link-owned-by-tagged-devices", Long: "/before", Owner: "tagged-devices"})
+	db.Save(&Link{Short: "ping", Long: "go/pong"})
 
 	fooXSRF := func(short string) string {
-		return xsrftoken.Generate(xsrfKey, "foo@example.com", short)
+		return xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
 	}
 	barXSRF := func(short string) string {
-		return xsrftoken.Generate(xsrfKey, "bar@example.com", short)
+		return xsrftoken.Generate(s.xsrfKey, "bar@example.com", short)
 	}
 
 	tests := []struct {
@@ -249,6 +337,20 @@ func TestServeSave(t *testing.T) {
 			wantStatus:   http.StatusSeeOther,
 			wantLocation: "/.detail/who?exists=1",
 		},
+		{
+			name:       "disallow link to itself",
+			short:      "loop",
+			xsrf:       fooXSRF(newShortName),
+			long:       "/loop/again",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "disallow link cycle",
+			short:      "pong",
+			xsrf:       fooXSRF(newShortName),
+			long:       "http://go/ping",
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:       "invalid xsrf",
 			short:      "goat",
@@ -261,16 +363,10 @@ func TestServeSave(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.currentUser != nil {
-				oldCurrentUser := currentUser
-				currentUser = tt.currentUser
-				t.Cleanup(func() {
-					currentUser = oldCurrentUser
-				})
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
 			}
 
-			oldAllowUnknownUsers := *allowUnknownUsers
-			*allowUnknownUsers = tt.allowUnknownUsers
-			t.Cleanup(func() { *allowUnknownUsers = oldAllowUnknownUsers })
+			tstest.Replace(t, &s.opts.AllowUnknownUsers, tt.allowUnknownUsers)
 
 			r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
 				"short": {tt.short},
@@ -279,7 +375,7 @@ func TestServeSave(t *testing.T) {
 			}.Encode()))
 			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			w := httptest.NewRecorder()
-			serveSave(w, r)
+			s.serveSave(w, r)
 
 			if w.Code != tt.wantStatus {
 				t.Errorf("serveSave(%q, %q) = %d; want %d", tt.short, tt.long, w.Code, tt.wantStatus)
@@ -294,17 +390,14 @@ func TestServeSave(t *testing.T) {
 }
 
 func TestServeDelete(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
-	if err != nil {
-		t.Fatal(err)
-	}
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
 	db.Save(&Link{Short: "a", Owner: "a@example.com"})
 	db.Save(&Link{Short: "foo", Owner: "foo@example.com"})
 	db.Save(&Link{Short: "link-owned-by-tagged-devices", Long: "/before", Owner: "tagged-devices"})
 
 	xsrf := func(short string) string {
-		return xsrftoken.Generate(xsrfKey, "foo@example.com", short)
+		return xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
 	}
 
 	tests := []struct {
@@ -359,11 +452,7 @@ func TestServeDelete(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.currentUser != nil {
-				oldCurrentUser := currentUser
-				currentUser = tt.currentUser
-				t.Cleanup(func() {
-					currentUser = oldCurrentUser
-				})
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
 			}
 
 			r := httptest.NewRequest("POST", "/.delete/"+tt.short, strings.NewReader(url.Values{
@@ -371,191 +460,1300 @@ func TestServeDelete(t *testing.T) {
 			}.Encode()))
 			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			w := httptest.NewRecorder()
-			serveDelete(w, r)
+			s.serveDelete(w, r)
 			t.Logf("response body: %v", w.Body.String())
 			if w.Code != tt.wantStatus {
 				t.Errorf("serveDelete(%q) = %d; want %d", tt.short, w.Code, tt.wantStatus)
 			}
 		})
 	}
-}
-
-func TestServeExport(t *testing.T) {
-	clock := tstest.NewClock(tstest.ClockOpts{
-		Start: time.Date(2022, 06, 02, 1, 2, 3, 4, time.UTC),
-	})
 
-	var err error
-	db, err = NewSQLiteDB(":memory:")
-	db.clock = clock
+	// deleted links are moved to the trash
+	trashed, err := db.LoadTrash()
 	if err != nil {
 		t.Fatal(err)
 	}
-	db.Save(&Link{Short: "a", Owner: "a@example.com"})
-	db.Save(&Link{Short: "foo", Owner: "foo@example.com"})
-	db.Save(&Link{Short: "link-owned-by-tagged-devices", Long: "/before", Owner: "tagged-devices"})
-
-	click := func(id string) {
-		r := httptest.NewRequest("GET", "/"+id, nil)
-		w := httptest.NewRecorder()
-		serveHandler().ServeHTTP(w, r)
+	var got []string
+	for _, link := range trashed {
+		got = append(got, link.Short)
+	}
+	slices.Sort(got)
+	if want := []string{"a", "foo", "link-owned-by-tagged-devices"}; !slices.Equal(got, want) {
+		t.Errorf("trashed links = %q; want %q", got, want)
 	}
-	initStats()
-	click("a")
-	click("foo")
-	click("foo")
-	flushStats()
-	clock.Advance(3 * time.Minute)
-	click("a")
 
-	// export links
-	r := httptest.NewRequest("GET", "/.export", nil)
+	// errors loading the link fail the request
+	s = newTestServer(t, failingLoadStore{NewMemoryDB()})
+	r := httptest.NewRequest("POST", "/.delete/foo", strings.NewReader(url.Values{
+		"xsrf": {xsrf("foo")},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	w := httptest.NewRecorder()
-	serveHandler().ServeHTTP(w, r)
-
-	if want := http.StatusOK; w.Code != want {
-		t.Errorf("serveExport = %d; want %d", w.Code, want)
+	s.serveDelete(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("serveDelete with failing store = %d; want %d", w.Code, http.StatusInternalServerError)
 	}
-	wantOutput := `{"Short":"a","Long":"","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"a@example.com"}
-{"Short":"foo","Long":"","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"foo@example.com"}
-{"Short":"link-owned-by-tagged-devices","Long":"/before","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"tagged-devices"}
-`
-	if got := w.Body.String(); got != wantOutput {
-		t.Errorf("serveExport = %v; want %v", got, wantOutput)
+}
+
+// failingLoadStore is a LinkStore whose Load method always fails.
+type failingLoadStore struct {
+	LinkStore
+}
+
+func (failingLoadStore) Load(string) (*Link, error) {
+	return nil, errors.New("database is locked")
+}
+
+func TestServeHistory(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/v3", Owner: "foo@example.com"})
+	db.SaveRevision(&Revision{Short: "who", Long: "http://who/v1", Owner: "bar@example.com", Editor: "bar@example.com"})
+	db.SaveRevision(&Revision{Short: "who", Long: "http://who/v2", Owner: "bar@example.com", Editor: "foo@example.com"})
+	db.Save(&Link{Short: "new", Long: "http://new/"})
+
+	tests := []struct {
+		name         string
+		path         string
+		wantStatus   int
+		wantLocation string
+		want         []historyEntry
+	}{
+		{
+			name:       "edited link",
+			path:       "/.history/who",
+			wantStatus: http.StatusOK,
+			want: []historyEntry{
+				{ID: 2, Editor: "foo@example.com", OldShort: "who", NewShort: "who", OldLong: "http://who/v2", NewLong: "http://who/v3", OldOwner: "bar@example.com", NewOwner: "foo@example.com"},
+				{ID: 1, Editor: "bar@example.com", OldShort: "who", NewShort: "who", OldLong: "http://who/v1", NewLong: "http://who/v2", OldOwner: "bar@example.com", NewOwner: "bar@example.com"},
+			},
+		},
+		{
+			name:       "unedited link",
+			path:       "/.history/new",
+			wantStatus: http.StatusOK,
+			want:       []historyEntry{},
+		},
+		{
+			name:         "non-canonical name",
+			path:         "/.history/WHO",
+			wantStatus:   http.StatusFound,
+			wantLocation: "/.history/who",
+		},
+		{
+			name:       "nonexistent link",
+			path:       "/.history/does-not-exist",
+			wantStatus: http.StatusNotFound,
+		},
 	}
 
-	// export links stats
-	r = httptest.NewRequest("GET", "/.export-stats", nil)
-	w = httptest.NewRecorder()
-	serveHandler().ServeHTTP(w, r)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
 
-	if want := http.StatusOK; w.Code != want {
-		t.Errorf("serveExportStats = %d; want %d", w.Code, want)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveHistory(%q) = %d; want %d", tt.path, w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("serveHistory(%q) Location = %q; want %q", tt.path, got, tt.wantLocation)
+			}
+			if tt.want == nil {
+				return
+			}
+			var got []historyEntry
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreFields(historyEntry{}, "Created")); diff != "" {
+				t.Errorf("serveHistory(%q) diff (-want +got):\n%s", tt.path, diff)
+			}
+		})
 	}
-	wantOutput = `a,1654131723,1
-foo,1654131723,2
-a,1654131903,1
-`
-	if got := w.Body.String(); got != wantOutput {
-		t.Errorf("serveExportStats = %v; want %v", got, wantOutput)
+
+	// HTML rendering
+	r := httptest.NewRequest("GET", "/.history/who", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("serveHistory HTML = %d; want %d", w.Code, http.StatusOK)
+	}
+	for _, s := range []string{"http://who/v1", "http://who/v2", "http://who/v3", "/.revert/who"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("serveHistory HTML body missing %q", s)
+		}
 	}
 }
 
-func TestReadOnlyMode(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
+func TestServeSaveRecordsHistory(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com"})
+	db.Save(&Link{Short: "wiki", Long: "http://wiki/"})
+
+	save := func(long, aliases string, wantStatus int) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
+			"short":   {"who"},
+			"long":    {long},
+			"aliases": {aliases},
+			"xsrf":    {xsrftoken.Generate(s.xsrfKey, "foo@example.com", "who")},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveSave(w, r)
+		if w.Code != wantStatus {
+			t.Fatalf("serveSave(%q) = %d; want %d", long, w.Code, wantStatus)
+		}
+	}
+	save("http://who/2", "", http.StatusOK)
+	save("http://who/2", "", http.StatusOK) // unchanged; no revision
+	save("http://who/3", "", http.StatusOK)
+	save("http://who/4", "wiki", http.StatusConflict) // not saved; no revision
+
+	revs, err := db.LoadRevisions("who")
 	if err != nil {
 		t.Fatal(err)
 	}
-	db.Save(&Link{Short: "who", Long: "http://who/"})
+	var got []string
+	for _, rev := range revs {
+		got = append(got, rev.Long)
+		if rev.Editor != "foo@example.com" {
+			t.Errorf("revision %d editor = %q; want %q", rev.ID, rev.Editor, "foo@example.com")
+		}
+	}
+	if want := []string{"http://who/2", "http://who/"}; !slices.Equal(got, want) {
+		t.Errorf("recorded revisions = %q; want %q", got, want)
+	}
+}
 
-	oldReadOnly := readonly
-	readonly = ptr.To(true)
-	defer func() { readonly = oldReadOnly }()
+func TestServeSaveDescription(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
 
-	// resolving link should succeed
-	r := httptest.NewRequest("GET", "/who", nil)
-	w := httptest.NewRecorder()
-	serveHandler().ServeHTTP(w, r)
-	if want := http.StatusFound; w.Code != want {
-		t.Errorf("serveHandler() = %d; want %d", w.Code, want)
+	save := func(form url.Values) {
+		t.Helper()
+		form.Set("short", "who")
+		form.Set("long", "http://who/")
+		form.Set("xsrf", xsrftoken.Generate(s.xsrfKey, "foo@example.com", "who"))
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveSave(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("serveSave(%v) = %d; want %d", form, w.Code, http.StatusOK)
+		}
 	}
-	wantLocation := "http://who/"
-	if location := w.Header().Get("Location"); location != wantLocation {
-		t.Errorf("serveHandler() location = %v; want %v", location, wantLocation)
+	description := func() string {
+		t.Helper()
+		link, err := db.Load("who")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return link.Description
 	}
 
-	// updating link should fail
-	r = httptest.NewRequest("POST", "/", nil)
-	w = httptest.NewRecorder()
-	serveHandler().ServeHTTP(w, r)
-	if want := http.StatusMethodNotAllowed; w.Code != want {
-		t.Errorf("serveHandler() = %d; want %d", w.Code, want)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com"})
+	save(url.Values{"description": {" Find people "}})
+	if got, want := description(), "Find people"; got != want {
+		t.Errorf("description = %q; want %q", got, want)
 	}
 
-	// deleting link should fail
-	r = httptest.NewRequest("POST", "/.delete/who", nil)
-	w = httptest.NewRecorder()
-	serveHandler().ServeHTTP(w, r)
-	if want := http.StatusMethodNotAllowed; w.Code != want {
-		t.Errorf("serveHandler() = %d; want %d", w.Code, want)
+	// clients that don't send a description leave it unchanged
+	save(url.Values{})
+	if got, want := description(), "Find people"; got != want {
+		t.Errorf("description after save without one = %q; want %q", got, want)
+	}
+
+	save(url.Values{"description": {""}})
+	if got := description(); got != "" {
+		t.Errorf("description after clearing = %q; want empty", got)
+	}
+
+	save(url.Values{"tags": {"Oncall, team:infra"}})
+	if link, _ := db.Load("who"); !cmp.Equal(link.Tags, []string{"oncall", "team:infra"}) {
+		t.Errorf("tags = %q; want %q", link.Tags, []string{"oncall", "team:infra"})
+	}
+	save(url.Values{})
+	if link, _ := db.Load("who"); len(link.Tags) != 2 {
+		t.Errorf("tags after save without them = %q; want unchanged", link.Tags)
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
+		"short": {"who"},
+		"long":  {"http://who/"},
+		"tags":  {"bad<tag>"},
+		"xsrf":  {xsrftoken.Generate(s.xsrfKey, "foo@example.com", "who")},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.serveSave(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("serveSave with invalid tag = %d; want %d", w.Code, http.StatusBadRequest)
 	}
 }
 
-func TestExpandLink(t *testing.T) {
+func TestRestoreSnapshot(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "existing", Long: "http://existing/new"})
+	s.opts.Snapshot = []byte(`{"Short":"existing","Long":"http://existing/old"}
+{"Short":"who","Long":"http://who/","Owner":"foo@example.com","Description":"Find people","Tags":["people"]}
+{"Short":"old","Long":"http://old/","Owner":"foo@example.com"}
+`)
+	if err := s.restoreSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
-		name      string    // test name
-		long      string    // long URL for golink
-		now       time.Time // current time
-		user      string    // current user resolving link
-		query     string    // query string
-		remainder string    // remainder of URL path after golink name
-		wantErr   bool      // whether we expect an error
-		want      string    // expected redirect URL
+		short string
+		want  *Link
+	}{
+		{"existing", &Link{Short: "existing", Long: "http://existing/new"}}, // not overwritten
+		{"who", &Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Description: "Find people", Tags: []string{"people"}}},
+		{"old", &Link{Short: "old", Long: "http://old/", Owner: "foo@example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := db.Load(tt.short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Created, got.LastEdit = time.Time{}, time.Time{}
+		if !cmp.Equal(got, tt.want) {
+			t.Errorf("restored %q diff (-want +got):\n%s", tt.short, cmp.Diff(tt.want, got))
+		}
+	}
+}
+
+func TestServeRevert(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/v2", Owner: "foo@example.com"})
+	db.SaveRevision(&Revision{Short: "who", Long: "http://who/v1", Owner: "foo@example.com", Editor: "foo@example.com"})
+	db.Save(&Link{Short: "bar", Long: "http://bar/v2", Owner: "bar@example.com"})
+	db.SaveRevision(&Revision{Short: "bar", Long: "http://bar/v1", Owner: "bar@example.com", Editor: "bar@example.com"})
+	db.Save(&Link{Short: "ping", Long: "http://ping/", Owner: "foo@example.com"})
+	db.SaveRevision(&Revision{Short: "ping", Long: "http://go/pong", Owner: "foo@example.com", Editor: "foo@example.com"})
+	db.Save(&Link{Short: "pong", Long: "http://go/ping", Owner: "foo@example.com"})
+
+	xsrf := func(short string) string {
+		return xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
+	}
+
+	tests := []struct {
+		name        string
+		short       string
+		rev         string
+		xsrf        string
+		currentUser func(*http.Request) (user, error)
+		wantStatus  int
+		wantLong    string
 	}{
 		{
-			name: "dont-mangle-escapes",
-			long: "http://host.com/foo%2f/bar",
-			want: "http://host.com/foo%2f/bar",
-		},
-		{
-			name:      "dont-mangle-escapes-and-remainder",
-			long:      "http://host.com/foo%2f/bar",
-			remainder: "extra",
-			want:      "http://host.com/foo%2f/bar/extra",
-		},
-		{
-			name:      "remainder-insert-slash",
-			long:      "http://host.com/foo",
-			remainder: "extra",
-			want:      "http://host.com/foo/extra",
-		},
-		{
-			name:      "remainder-long-as-trailing-slash",
-			long:      "http://host.com/foo/",
-			remainder: "extra",
-			want:      "http://host.com/foo/extra",
+			name:       "nonexistent link",
+			short:      "does-not-exist",
+			rev:        "1",
+			xsrf:       xsrf("does-not-exist"),
+			wantStatus: http.StatusNotFound,
 		},
 		{
-			name: "var-expansions-time",
-			long: `https://roamresearch.com/#/app/ts-corp/page/{{.Now.Format "01-02-2006"}}`,
-			want: "https://roamresearch.com/#/app/ts-corp/page/06-02-2022",
-			now:  time.Date(2022, 06, 02, 1, 2, 3, 4, time.UTC),
+			name:       "invalid revision",
+			short:      "who",
+			rev:        "x",
+			xsrf:       xsrf("who"),
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name: "var-expansions-user",
-			long: `http://host.com/{{.User}}`,
-			user: "foo@example.com",
-			want: "http://host.com/foo@example.com",
+			name:       "revision of another link",
+			short:      "who",
+			rev:        "2",
+			xsrf:       xsrf("who"),
+			wantStatus: http.StatusNotFound,
 		},
 		{
-			name:    "var-expansions-no-user",
-			long:    `http://host.com/{{.User}}`,
-			wantErr: true,
+			name:       "invalid xsrf",
+			short:      "who",
+			rev:        "1",
+			xsrf:       xsrf("bar"),
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name:    "unknown-field",
-			long:    `http://host.com/{{.Foo}}`,
-			wantErr: true,
+			name:       "disallow reverting another's link",
+			short:      "bar",
+			rev:        "2",
+			xsrf:       xsrf("bar"),
+			wantStatus: http.StatusForbidden,
 		},
 		{
-			name: "template-no-path",
-			long: "https://calendar.google.com/{{with .Path}}calendar/embed?mode=week&src={{.}}@tailscale.com{{end}}",
-			want: "https://calendar.google.com/",
+			name:        "admin can revert another's link",
+			short:       "bar",
+			rev:         "2",
+			xsrf:        xsrf("bar"),
+			currentUser: func(*http.Request) (user, error) { return user{login: "foo@example.com", isAdmin: true}, nil },
+			wantStatus:  http.StatusOK,
+			wantLong:    "http://bar/v1",
 		},
 		{
-			name:      "template-with-path",
-			long:      "https://calendar.google.com/{{with .Path}}calendar/embed?mode=week&src={{.}}@tailscale.com{{end}}",
-			remainder: "amelie",
-			want:      "https://calendar.google.com/calendar/embed?mode=week&src=amelie@tailscale.com",
+			name:       "disallow reverting into a link cycle",
+			short:      "ping",
+			rev:        "3",
+			xsrf:       xsrf("ping"),
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name:      "template-with-pathescape-func",
-			long:      "http://host.com/{{PathEscape .Path}}",
-			remainder: "a/b+c",
-			want:      "http://host.com/a%2Fb+c",
+			name:       "owner can revert",
+			short:      "who",
+			rev:        "1",
+			xsrf:       xsrf("who"),
+			wantStatus: http.StatusOK,
+			wantLong:   "http://who/v1",
 		},
-		{
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.currentUser != nil {
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
+			}
+
+			r := httptest.NewRequest("POST", "/.revert/"+tt.short, strings.NewReader(url.Values{
+				"rev":  {tt.rev},
+				"xsrf": {tt.xsrf},
+			}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.serveRevert(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveRevert(%q, %q) = %d; want %d", tt.short, tt.rev, w.Code, tt.wantStatus)
+			}
+			if tt.wantLong == "" {
+				return
+			}
+			link, err := db.Load(tt.short)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link.Long != tt.wantLong {
+				t.Errorf("after revert, long = %q; want %q", link.Long, tt.wantLong)
+			}
+		})
+	}
+
+	// the revert itself is recorded, so it can be undone
+	revs, err := db.LoadRevisions("who")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 || revs[0].Long != "http://who/v2" {
+		t.Errorf("revisions after revert = %v; want newest with long %q", revs, "http://who/v2")
+	}
+}
+
+func TestServeRename(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com"})
+	db.Save(&Link{Short: "bar", Long: "http://bar/", Owner: "bar@example.com"})
+
+	xsrf := func(short string) string {
+		return xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
+	}
+	rename := func(short, newShort, alias, xsrf string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/.rename/"+short, strings.NewReader(url.Values{
+			"short": {newShort},
+			"alias": {alias},
+			"xsrf":  {xsrf},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveRename(w, r)
+		return w
+	}
+	resolve := func(short string) string {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/"+short, nil))
+		return w.Header().Get("Location")
+	}
+
+	tests := []struct {
+		name       string
+		short      string
+		newShort   string
+		xsrf       string
+		wantStatus int
+	}{
+		{"nonexistent link", "does-not-exist", "people", xsrf("does-not-exist"), http.StatusNotFound},
+		{"missing name", "who", "", xsrf("who"), http.StatusBadRequest},
+		{"invalid name", "who", "who/is", xsrf("who"), http.StatusBadRequest},
+		{"invalid xsrf", "who", "people", xsrf("bar"), http.StatusBadRequest},
+		{"disallow renaming another's link", "bar", "people", xsrf("bar"), http.StatusForbidden},
+		{"name in use", "who", "Bar", xsrf("who"), http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := rename(tt.short, tt.newShort, "", tt.xsrf); w.Code != tt.wantStatus {
+				t.Errorf("serveRename(%q, %q) = %d; want %d", tt.short, tt.newShort, w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// clicks that haven't been flushed yet move with the link
+	resolve("who")
+	if w := rename("who", "people", "true", xsrf("who")); w.Code != http.StatusOK {
+		t.Fatalf("serveRename = %d; want %d\n%s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := resolve("who"); got != "http://who/" {
+		t.Errorf("old name resolves to %q; want %q", got, "http://who/")
+	}
+	if got := resolve("people"); got != "http://who/" {
+		t.Errorf("new name resolves to %q; want %q", got, "http://who/")
+	}
+	s.stats.mu.Lock()
+	clicks := maps.Clone(s.stats.clicks)
+	s.stats.mu.Unlock()
+	if want := (ClickStats{"people": 3}); !cmp.Equal(clicks, want) {
+		t.Errorf("clicks after rename = %v; want %v", clicks, want)
+	}
+	if err := s.flushStats(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.LoadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ClickStats{"people": 3}); !cmp.Equal(stored, want) {
+		t.Errorf("stored clicks after rename = %v; want %v", stored, want)
+	}
+
+	// the rename is recorded in the link's history
+	revs, err := db.LoadRevisions("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 1 || revs[0].Short != "who" {
+		t.Errorf("revisions after rename = %v; want one with short %q", revs, "who")
+	}
+
+	// without an alias, the old name stops resolving
+	if w := rename("people", "folks", "false", xsrf("people")); w.Code != http.StatusOK {
+		t.Fatalf("serveRename = %d; want %d\n%s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := resolve("people"); got != "" {
+		t.Errorf("old name resolves to %q; want not found", got)
+	}
+	if got := resolve("who"); got != "http://who/" {
+		t.Errorf("earlier alias resolves to %q; want %q", got, "http://who/")
+	}
+}
+
+func TestServeTrash(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "mine", Long: "http://mine/", Owner: "foo@example.com"})
+	db.Save(&Link{Short: "theirs", Long: "http://theirs/", Owner: "bar@example.com"})
+	db.TrashLink("mine", "foo@example.com", time.Now())
+	db.TrashLink("theirs", "bar@example.com", time.Now())
+
+	tests := []struct {
+		name        string
+		currentUser func(*http.Request) (user, error)
+		want        []string
+	}{
+		{
+			name: "former owner",
+			want: []string{"mine"},
+		},
+		{
+			name:        "admin",
+			currentUser: func(*http.Request) (user, error) { return user{login: "foo@example.com", isAdmin: true}, nil },
+			want:        []string{"mine", "theirs"},
+		},
+		{
+			name:        "anonymous",
+			currentUser: func(*http.Request) (user, error) { return user{}, nil },
+			want:        nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.currentUser != nil {
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
+			}
+
+			r := httptest.NewRequest("GET", "/.trash", nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("serveTrash = %d; want %d", w.Code, http.StatusOK)
+			}
+
+			var links []*TrashedLink
+			if err := json.Unmarshal(w.Body.Bytes(), &links); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, link := range links {
+				got = append(got, link.Short)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("serveTrash links = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRestore(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	s.initStats()
+	db.Save(&Link{Short: "mine", Long: "http://mine/", Owner: "foo@example.com"})
+	db.Save(&Link{Short: "theirs", Long: "http://theirs/", Owner: "bar@example.com"})
+	db.Save(&Link{Short: "reused", Long: "http://reused/", Owner: "foo@example.com"})
+	db.SaveStats(ClickStats{"mine": 4})
+	db.TrashLink("mine", "foo@example.com", time.Now())
+	db.TrashLink("theirs", "bar@example.com", time.Now())
+	db.TrashLink("reused", "foo@example.com", time.Now())
+	db.Save(&Link{Short: "reused", Long: "http://new/", Owner: "foo@example.com"})
+
+	xsrf := func(short string) string {
+		return xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
+	}
+
+	tests := []struct {
+		name        string
+		short       string
+		xsrf        string
+		currentUser func(*http.Request) (user, error)
+		wantStatus  int
+	}{
+		{
+			name:       "not in trash",
+			short:      "does-not-exist",
+			xsrf:       xsrf("does-not-exist"),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "another user's link",
+			short:      "theirs",
+			xsrf:       xsrf("theirs"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid xsrf",
+			short:      "mine",
+			xsrf:       xsrf("theirs"),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "name reused",
+			short:      "reused",
+			xsrf:       xsrf("reused"),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "former owner can restore",
+			short:      "mine",
+			xsrf:       xsrf("mine"),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "admin can restore any link",
+			short:       "theirs",
+			xsrf:        xsrf("theirs"),
+			currentUser: func(*http.Request) (user, error) { return user{login: "foo@example.com", isAdmin: true}, nil },
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.currentUser != nil {
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
+			}
+
+			r := httptest.NewRequest("POST", "/.restore/"+tt.short, strings.NewReader(url.Values{
+				"xsrf": {tt.xsrf},
+			}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.serveRestore(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("serveRestore(%q) = %d; want %d", tt.short, w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// restored links resolve again, with their previous clicks
+	if _, err := db.Load("mine"); err != nil {
+		t.Errorf("restored link: %v", err)
+	}
+	s.stats.mu.Lock()
+	clicks := s.stats.clicks["mine"]
+	s.stats.mu.Unlock()
+	if clicks != 4 {
+		t.Errorf("restored link clicks = %d; want 4", clicks)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "old"})
+	db.Save(&Link{Short: "new"})
+	db.TrashLink("old", "foo@example.com", time.Now().Add(-48*time.Hour))
+	db.TrashLink("new", "foo@example.com", time.Now())
+
+	s.opts.TrashRetention = 24 * time.Hour
+	if err := s.purgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+
+	trashed, err := db.LoadTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].Short != "new" {
+		t.Errorf("trash after purge = %v; want only %q", trashed, "new")
+	}
+}
+
+func TestServeExport(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{
+		Start: time.Date(2022, 06, 02, 1, 2, 3, 4, time.UTC),
+	})
+
+	db, err := NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.clock = clock
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "a", Owner: "a@example.com"})
+	db.Save(&Link{Short: "foo", Owner: "foo@example.com", Description: "Foo things"})
+	db.Save(&Link{Short: "link-owned-by-tagged-devices", Long: "/before", Owner: "tagged-devices"})
+
+	click := func(id string) {
+		r := httptest.NewRequest("GET", "/"+id, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+	}
+	s.initStats()
+	click("a")
+	click("foo")
+	click("foo")
+	s.flushStats()
+	clock.Advance(3 * time.Minute)
+	click("a")
+
+	// export links
+	r := httptest.NewRequest("GET", "/.export", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+
+	if want := http.StatusOK; w.Code != want {
+		t.Errorf("serveExport = %d; want %d", w.Code, want)
+	}
+	header, links, _ := strings.Cut(w.Body.String(), "\n")
+	if !strings.HasPrefix(header, `{"Type":"header","Header":{"Version":2,`) {
+		t.Errorf("serveExport header = %v; want a version 2 snapshot header", header)
+	}
+	wantOutput := `{"Short":"a","Long":"","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"a@example.com","Description":""}
+{"Short":"foo","Long":"","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"foo@example.com","Description":"Foo things"}
+{"Short":"link-owned-by-tagged-devices","Long":"/before","Created":"0001-01-01T00:00:00Z","LastEdit":"0001-01-01T00:00:00Z","Owner":"tagged-devices","Description":""}
+`
+	if got := links; got != wantOutput {
+		t.Errorf("serveExport = %v; want %v", got, wantOutput)
+	}
+
+	// export links stats
+	r = httptest.NewRequest("GET", "/.export-stats", nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+
+	if want := http.StatusOK; w.Code != want {
+		t.Errorf("serveExportStats = %d; want %d", w.Code, want)
+	}
+	wantOutput = `a,1654131723,1
+foo,1654131723,2
+a,1654131903,1
+`
+	if got := w.Body.String(); got != wantOutput {
+		t.Errorf("serveExportStats = %v; want %v", got, wantOutput)
+	}
+}
+
+func TestServeBackup(t *testing.T) {
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, db)
+	created := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Created: created, LastEdit: created})
+	db.Save(&Link{Short: "gone", Long: "http://gone/", Owner: "foo@example.com", Created: created, LastEdit: created})
+	db.TrashLink("gone", "foo@example.com", created)
+	s.stats.dirty = ClickStats{"who": 3}
+
+	tests := []struct {
+		name       string
+		user       user
+		wantStatus int
+	}{
+		{"admin", user{login: "admin@example.com", isAdmin: true}, http.StatusOK},
+		{"non-admin", user{login: "foo@example.com"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tstest.Replace(t, &s.currentUser, func(*http.Request) (user, error) { return tt.user, nil })
+			r := httptest.NewRequest("GET", "/.backup", nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveBackup = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			// the backup restores links, pending stats, and the trash
+			backup := filepath.Join(t.TempDir(), "backup.db")
+			if err := os.WriteFile(backup, w.Body.Bytes(), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			restored := filepath.Join(t.TempDir(), "restored.db")
+			if err := RestoreSQLiteDB(backup, restored); err != nil {
+				t.Fatal(err)
+			}
+			rdb, err := NewSQLiteDB(restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			links, err := rdb.LoadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(links) != 1 || links[0].Short != "who" {
+				t.Errorf("restored links = %v; want only %q", links, "who")
+			}
+			clicks, err := rdb.LoadStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clicks["who"] != 3 {
+				t.Errorf("restored clicks = %v; want 3 for %q", clicks, "who")
+			}
+			trashed, err := rdb.LoadTrash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trashed) != 1 || trashed[0].Short != "gone" {
+				t.Errorf("restored trash = %v; want only %q", trashed, "gone")
+			}
+		})
+	}
+
+	t.Run("unsupported store", func(t *testing.T) {
+		s := newTestServer(t, NewMemoryDB())
+		s.currentUser = func(*http.Request) (user, error) {
+			return user{login: "admin@example.com", isAdmin: true}, nil
+		}
+		r := httptest.NewRequest("GET", "/.backup", nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("serveBackup = %d; want %d", w.Code, http.StatusNotImplemented)
+		}
+	})
+}
+
+func TestServeImport(t *testing.T) {
+	older := time.Date(2022, 06, 01, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	snapshot := fmt.Sprintf(`{"Short":"who","Long":"http://who/new","LastEdit":%q}
+
+{"Short":"new","Long":"http://new/","Owner":"foo@example.com"}
+`, newer.Format(time.RFC3339))
+	admin := user{login: "admin@example.com", isAdmin: true}
+	const xsrfKey = "import-test-key"
+
+	// multipartBody returns a form with the snapshot as its "file" field.
+	multipartBody := func(t *testing.T, fields map[string]string) (io.Reader, string) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		fw := must.Get(mw.CreateFormFile("file", "links.json"))
+		io.WriteString(fw, snapshot)
+		mw.Close()
+		return &buf, mw.FormDataContentType()
+	}
+
+	tests := []struct {
+		name        string
+		user        user
+		method      string
+		query       string
+		multipart   map[string]string // if non-nil, post as a form with these fields
+		body        string
+		wantStatus  int
+		wantReport  *ImportReport
+		wantWhoLong string
+	}{
+		{
+			name:        "body",
+			user:        admin,
+			method:      "POST",
+			body:        snapshot,
+			wantStatus:  http.StatusOK,
+			wantReport:  &ImportReport{Created: []string{"new"}, Skipped: []string{"who"}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "form newer",
+			user:        admin,
+			method:      "POST",
+			multipart:   map[string]string{"mode": "newer", "xsrf": xsrftoken.Generate(xsrfKey, admin.login, ".import")},
+			wantStatus:  http.StatusOK,
+			wantReport:  &ImportReport{Created: []string{"new"}, Changed: []string{"who"}},
+			wantWhoLong: "http://who/new",
+		},
+		{
+			name:        "dry run",
+			user:        admin,
+			method:      "POST",
+			query:       "?mode=overwrite&dryrun=1",
+			body:        snapshot,
+			wantStatus:  http.StatusOK,
+			wantReport:  &ImportReport{DryRun: true, Created: []string{"new"}, Changed: []string{"who"}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "csv",
+			user:        admin,
+			method:      "POST",
+			query:       "?format=csv",
+			body:        "csv,http://csv/\n",
+			wantStatus:  http.StatusOK,
+			wantReport:  &ImportReport{Created: []string{"csv"}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "rejected rows",
+			user:        admin,
+			method:      "POST",
+			query:       "?format=csv",
+			body:        "csv,http://csv/\nbad name,http://bad/\n",
+			wantStatus:  http.StatusBadRequest,
+			wantReport:  &ImportReport{Errors: []ImportError{{Row: 2, Short: "bad name", Error: "short may only contain letters, numbers, dash, and period"}}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "rejected rows dry run",
+			user:        admin,
+			method:      "POST",
+			query:       "?format=csv&dryrun=true",
+			body:        "csv,http://csv/\nbad name,http://bad/\n",
+			wantStatus:  http.StatusOK,
+			wantReport:  &ImportReport{DryRun: true, Created: []string{"csv"}, Errors: []ImportError{{Row: 2, Short: "bad name", Error: "short may only contain letters, numbers, dash, and period"}}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:       "link cycle",
+			user:       admin,
+			method:     "POST",
+			query:      "?format=csv",
+			body:       "ping,http://go/pong\npong,/ping\nto-ping,/ping\n",
+			wantStatus: http.StatusBadRequest,
+			wantReport: &ImportReport{Errors: []ImportError{
+				{Row: 1, Short: "ping", Error: "invalid destination: link cycle: ping -> pong -> ping"},
+				{Row: 2, Short: "pong", Error: "invalid destination: link cycle: pong -> ping -> pong"},
+				{Row: 3, Short: "to-ping", Error: "invalid destination: link cycle: to-ping -> ping -> pong -> ping"},
+			}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:       "link cycle through existing link dry run",
+			user:       admin,
+			method:     "POST",
+			query:      "?format=csv&mode=overwrite&dryrun=1",
+			body:       "who,/loop\nloop,go/who\nok,http://ok/\n",
+			wantStatus: http.StatusOK,
+			wantReport: &ImportReport{DryRun: true, Created: []string{"ok"}, Errors: []ImportError{
+				{Row: 1, Short: "who", Error: "invalid destination: link cycle: who -> loop -> who"},
+				{Row: 2, Short: "loop", Error: "invalid destination: link cycle: loop -> who -> loop"},
+			}},
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "bad mode",
+			user:        admin,
+			method:      "POST",
+			query:       "?mode=merge",
+			body:        snapshot,
+			wantStatus:  http.StatusBadRequest,
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "bad snapshot",
+			user:        admin,
+			method:      "POST",
+			body:        snapshot + "{\"Long\":\"http://nameless/\"}\n",
+			wantStatus:  http.StatusBadRequest,
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "alias conflict",
+			user:        admin,
+			method:      "POST",
+			body:        snapshot + `{"Short":"other","Long":"http://other/","Aliases":["w-h-o"]}` + "\n",
+			wantStatus:  http.StatusConflict,
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "non-admin",
+			user:        user{login: "foo@example.com"},
+			method:      "POST",
+			body:        snapshot,
+			wantStatus:  http.StatusForbidden,
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "missing xsrf",
+			user:        admin,
+			method:      "POST",
+			multipart:   map[string]string{},
+			wantStatus:  http.StatusBadRequest,
+			wantWhoLong: "http://who/",
+		},
+		{
+			name:        "get",
+			user:        admin,
+			method:      "GET",
+			wantStatus:  http.StatusMethodNotAllowed,
+			wantWhoLong: "http://who/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			s, err := NewServer(Options{Store: db, Dev: true, XSRFKey: xsrfKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Save(&Link{Short: "who", Long: "http://who/", LastEdit: older})
+			s.currentUser = func(*http.Request) (user, error) { return tt.user, nil }
+
+			var r *http.Request
+			if tt.multipart != nil {
+				body, contentType := multipartBody(t, tt.multipart)
+				r = httptest.NewRequest(tt.method, "/.import"+tt.query, body)
+				r.Header.Set("Content-Type", contentType)
+			} else {
+				r = httptest.NewRequest(tt.method, "/.import"+tt.query, strings.NewReader(tt.body))
+				r.Header.Set(secHeaderName, "1")
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveImport = %d; want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantReport != nil {
+				var got ImportReport
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(tt.wantReport, &got, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("serveImport report diff (-want +got):\n%s", diff)
+				}
+			}
+			if got := must.Get(db.Load("who")).Long; got != tt.wantWhoLong {
+				t.Errorf("who.Long = %q; want %q", got, tt.wantWhoLong)
+			}
+			// a replaced link's previous version is recorded as an edit by the importer
+			revs := must.Get(db.LoadRevisions("who"))
+			if tt.wantWhoLong == "http://who/" {
+				if len(revs) != 0 {
+					t.Errorf("who has %d revisions; want none", len(revs))
+				}
+			} else if len(revs) != 1 || revs[0].Long != "http://who/" || revs[0].Editor != admin.login {
+				t.Errorf("who revisions = %+v; want one of %q by %q", revs, "http://who/", admin.login)
+			}
+			// imported links without an owner are owned by the importer
+			if link, err := db.Load("csv"); err == nil && link.Owner != admin.login {
+				t.Errorf("csv.Owner = %q; want %q", link.Owner, admin.login)
+			}
+		})
+	}
+}
+
+func TestCompactStats(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{
+		Start: time.Now().Add(-30 * 24 * time.Hour),
+	})
+	db, err := NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.clock = clock
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "a"})
+
+	for range 10 {
+		db.SaveStats(ClickStats{"a": 1})
+		clock.Advance(time.Minute)
+	}
+	s.opts.StatsHourlyAfter = 24 * time.Hour
+	s.opts.StatsDailyAfter = 7 * 24 * time.Hour
+	if err := s.compactStats(); err != nil {
+		t.Fatal(err)
+	}
+	s.initStats()
+
+	r := httptest.NewRequest("GET", "/.export-stats", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) > 2 {
+		t.Errorf("serveExportStats returned %d lines after rollup; want at most 2", len(lines))
+	}
+	var total int
+	for _, line := range lines {
+		var clicks int
+		fields := strings.Split(line, ",")
+		fmt.Sscan(fields[len(fields)-1], &clicks)
+		total += clicks
+	}
+	if total != 10 {
+		t.Errorf("serveExportStats total clicks = %d; want 10", total)
+	}
+
+	s.stats.mu.Lock()
+	clicks := s.stats.clicks["a"]
+	s.stats.mu.Unlock()
+	if clicks != 10 {
+		t.Errorf("loaded clicks = %d; want 10", clicks)
+	}
+}
+
+func TestMultipleServers(t *testing.T) {
+	db1, db2 := NewMemoryDB(), NewMemoryDB()
+	db1.Save(&Link{Short: "who", Long: "http://who/one"})
+	db2.Save(&Link{Short: "who", Long: "http://who/two"})
+	db2.Save(&Link{Short: "what", Long: "http://what/"})
+	s1, s2 := newTestServer(t, db1), newTestServer(t, db2)
+
+	// each server is mounted at its own host, next to another route
+	mux := http.NewServeMux()
+	mux.Handle("one/", s1.Handler())
+	mux.Handle("two/", s2.Handler())
+	mux.HandleFunc("/portal", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "portal")
+	})
+
+	for host, want := range map[string]string{"one": "http://who/one", "two": "http://who/two"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/who", nil))
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("%s/who = %q; want %q", host, got, want)
+		}
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://portal/portal", nil))
+	if got := w.Body.String(); got != "portal" {
+		t.Errorf("portal = %q; want %q", got, "portal")
+	}
+
+	// clicks are only counted by the server that resolved the link
+	if got := s1.stats.clicks["who"]; got != 1 {
+		t.Errorf("server one clicks = %d; want 1", got)
+	}
+	if got := s2.stats.clicks["who"]; got != 1 {
+		t.Errorf("server two clicks = %d; want 1", got)
+	}
+
+	// each server reports its own metrics
+	for host, want := range map[string]string{"one": "golinks_total 1\n", "two": "golinks_total 2\n"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/.metrics", nil))
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s/.metrics does not contain %q:\n%s", host, want, w.Body)
+		}
+	}
+
+	// XSRF tokens are only valid on the server that issued them
+	r := httptest.NewRequest("POST", "http://two/", strings.NewReader(url.Values{
+		"short": {"new"},
+		"long":  {"http://new/"},
+		"xsrf":  {xsrftoken.Generate(s1.xsrfKey, "foo@example.com", newShortName)},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("save with another server's XSRF token = %d; want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSharedRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := NewServer(Options{Store: NewMemoryDB(), Dev: true, Registerer: reg}); err != nil {
+		t.Fatal(err)
+	}
+	// the second server's metrics would collide with the first's
+	if _, err := NewServer(Options{Store: NewMemoryDB(), Dev: true, Registerer: reg}); err == nil {
+		t.Error("NewServer with an already used Registerer succeeded; want error")
+	}
+}
+
+func TestServerStart(t *testing.T) {
+	db := NewMemoryDB()
+	db.Save(&Link{Short: "who", Long: "http://who/"})
+	s := newTestServer(t, db)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/who", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("resolving who = %d; want %d", w.Code, http.StatusFound)
+	}
+
+	// pending clicks are flushed when the loops stop
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		clicks, err := db.LoadStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clicks["who"] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored clicks = %v; want 1 for %q", clicks, "who")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	db, err := NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/"})
+
+	s.opts.ReadOnly = true
+
+	// resolving link should succeed
+	r := httptest.NewRequest("GET", "/who", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if want := http.StatusFound; w.Code != want {
+		t.Errorf("Handler() = %d; want %d", w.Code, want)
+	}
+	wantLocation := "http://who/"
+	if location := w.Header().Get("Location"); location != wantLocation {
+		t.Errorf("Handler() location = %v; want %v", location, wantLocation)
+	}
+
+	// updating link should fail
+	r = httptest.NewRequest("POST", "/", nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if want := http.StatusMethodNotAllowed; w.Code != want {
+		t.Errorf("Handler() = %d; want %d", w.Code, want)
+	}
+
+	// deleting link should fail
+	r = httptest.NewRequest("POST", "/.delete/who", nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if want := http.StatusMethodNotAllowed; w.Code != want {
+		t.Errorf("Handler() = %d; want %d", w.Code, want)
+	}
+}
+
+func TestExpandLink(t *testing.T) {
+	tests := []struct {
+		name      string    // test name
+		long      string    // long URL for golink
+		now       time.Time // current time
+		user      string    // current user resolving link
+		query     string    // query string
+		remainder string    // remainder of URL path after golink name
+		wantErr   bool      // whether we expect an error
+		want      string    // expected redirect URL
+	}{
+		{
+			name: "dont-mangle-escapes",
+			long: "http://host.com/foo%2f/bar",
+			want: "http://host.com/foo%2f/bar",
+		},
+		{
+			name:      "dont-mangle-escapes-and-remainder",
+			long:      "http://host.com/foo%2f/bar",
+			remainder: "extra",
+			want:      "http://host.com/foo%2f/bar/extra",
+		},
+		{
+			name:      "remainder-insert-slash",
+			long:      "http://host.com/foo",
+			remainder: "extra",
+			want:      "http://host.com/foo/extra",
+		},
+		{
+			name:      "remainder-long-as-trailing-slash",
+			long:      "http://host.com/foo/",
+			remainder: "extra",
+			want:      "http://host.com/foo/extra",
+		},
+		{
+			name: "var-expansions-time",
+			long: `https://roamresearch.com/#/app/ts-corp/page/{{.Now.Format "01-02-2006"}}`,
+			want: "https://roamresearch.com/#/app/ts-corp/page/06-02-2022",
+			now:  time.Date(2022, 06, 02, 1, 2, 3, 4, time.UTC),
+		},
+		{
+			name: "var-expansions-user",
+			long: `http://host.com/{{.User}}`,
+			user: "foo@example.com",
+			want: "http://host.com/foo@example.com",
+		},
+		{
+			name:    "var-expansions-no-user",
+			long:    `http://host.com/{{.User}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown-field",
+			long:    `http://host.com/{{.Foo}}`,
+			wantErr: true,
+		},
+		{
+			name: "template-no-path",
+			long: "https://calendar.google.com/{{with .Path}}calendar/embed?mode=week&src={{.}}@tailscale.com{{end}}",
+			want: "https://calendar.google.com/",
+		},
+		{
+			name:      "template-with-path",
+			long:      "https://calendar.google.com/{{with .Path}}calendar/embed?mode=week&src={{.}}@tailscale.com{{end}}",
+			remainder: "amelie",
+			want:      "https://calendar.google.com/calendar/embed?mode=week&src=amelie@tailscale.com",
+		},
+		{
+			name:      "template-with-pathescape-func",
+			long:      "http://host.com/{{PathEscape .Path}}",
+			remainder: "a/b+c",
+			want:      "http://host.com/a%2Fb+c",
+		},
+		{
 			name:      "template-with-queryescape-func",
 			long:      "http://host.com/{{QueryEscape .Path}}",
 			remainder: "a/b+c",
@@ -638,38 +1836,588 @@ func TestExpandLink(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			query, _ := url.ParseQuery(tt.query)
-			env := expandEnv{Now: tt.now, Path: tt.remainder, user: tt.user, query: query}
-			link, err := expandLink(tt.long, env)
-			if (err != nil) != tt.wantErr {
-				t.Fatalf("expandLink(%q) returned error %v; want %v", tt.long, err, tt.wantErr)
+			query, _ := url.ParseQuery(tt.query)
+			env := expandEnv{Now: tt.now, Path: tt.remainder, user: tt.user, query: query}
+			link, err := expandLink(tt.long, env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandLink(%q) returned error %v; want %v", tt.long, err, tt.wantErr)
+			}
+			var got string
+			if link != nil {
+				got = link.String()
+			}
+			if got != tt.want {
+				t.Errorf("expandLink(%q) = %q; want %q", tt.long, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeGoAliasClicks(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "kubernetes", Long: "http://k8s/", Aliases: []string{"k8s"}})
+
+	for _, path := range []string{"/kubernetes", "/k8s", "/K-8s"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusFound {
+			t.Errorf("serveGo(%q) = %d; want %d", path, w.Code, http.StatusFound)
+		}
+	}
+	s.stats.mu.Lock()
+	got := maps.Clone(s.stats.clicks)
+	s.stats.mu.Unlock()
+	if want := (ClickStats{"kubernetes": 3}); !cmp.Equal(got, want) {
+		t.Errorf("clicks = %v; want %v", got, want)
+	}
+}
+
+func TestServeDetailAlias(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "kubernetes", Long: "http://k8s/", Aliases: []string{"k8s"}})
+
+	r := httptest.NewRequest("GET", "/.detail/k8s", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("serveDetail(alias) = %d; want %d", w.Code, http.StatusFound)
+	}
+	if got, want := w.Header().Get("Location"), "/.detail/kubernetes"; got != want {
+		t.Errorf("serveDetail(alias) Location = %q; want %q", got, want)
+	}
+
+	r = httptest.NewRequest("GET", "/.detail/kubernetes", nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	var link Link
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"k8s"}; !cmp.Equal(link.Aliases, want) {
+		t.Errorf("serveDetail JSON aliases = %q; want %q", link.Aliases, want)
+	}
+}
+
+func TestServeSaveAliases(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "kubernetes", Long: "http://k8s/", Owner: "foo@example.com"})
+	db.Save(&Link{Short: "wiki", Long: "http://wiki/", Owner: "foo@example.com", Aliases: []string{"docs"}})
+
+	save := func(short, aliases string) *httptest.ResponseRecorder {
+		t.Helper()
+		xsrf := xsrftoken.Generate(s.xsrfKey, "foo@example.com", short)
+		if _, err := db.Load(short); err != nil {
+			xsrf = xsrftoken.Generate(s.xsrfKey, "foo@example.com", newShortName)
+		}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
+			"short":   {short},
+			"long":    {"http://" + short + "/"},
+			"aliases": {aliases},
+			"xsrf":    {xsrf},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveSave(w, r)
+		return w
+	}
+
+	if w := save("kubernetes", "k8s, kube"); w.Code != http.StatusOK {
+		t.Fatalf("adding aliases = %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if link, _ := db.Load("kubernetes"); !cmp.Equal(link.Aliases, []string{"k8s", "kube"}) {
+		t.Errorf("aliases = %q; want %q", link.Aliases, []string{"k8s", "kube"})
+	}
+	if w := save("kubernetes", "k8s"); w.Code != http.StatusOK {
+		t.Fatalf("removing alias = %d; want %d", w.Code, http.StatusOK)
+	}
+	if link, _ := db.Load("kubernetes"); !cmp.Equal(link.Aliases, []string{"k8s"}) {
+		t.Errorf("aliases after removal = %q; want %q", link.Aliases, []string{"k8s"})
+	}
+
+	tests := []struct {
+		name       string
+		short      string
+		aliases    string
+		wantStatus int
+	}{
+		{"invalid alias", "kubernetes", "k8s, not/valid", http.StatusBadRequest},
+		{"alias is another link", "kubernetes", "wiki", http.StatusConflict},
+		{"alias of another link", "kubernetes", "DOCS", http.StatusConflict},
+		{"new link named after alias", "k-8-s", "", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := save(tt.short, tt.aliases); w.Code != tt.wantStatus {
+				t.Errorf("serveSave(%q, aliases=%q) = %d; want %d", tt.short, tt.aliases, w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServeSaveCoOwners(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       user
+		coOwners   string
+		wantStatus int
+	}{
+		{"owner", user{login: "foo@example.com"}, "bar@example.com", http.StatusOK},
+		{"co-owner", user{login: "bar@example.com"}, "bar@example.com, group:infra", http.StatusOK},
+		{"group member", user{login: "baz@example.com", groups: []string{"infra"}}, "group:infra", http.StatusOK},
+		{"other group", user{login: "baz@example.com", groups: []string{"sales"}}, "", http.StatusForbidden},
+		{"not an owner", user{login: "baz@example.com"}, "baz@example.com", http.StatusForbidden},
+		{"invalid group", user{login: "foo@example.com"}, "group:in/fra", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			s := newTestServer(t, db)
+			db.Save(&Link{Short: "infra", Long: "http://infra/", Owner: "foo@example.com", CoOwners: []string{"bar@example.com", "group:infra"}})
+			s.currentUser = func(*http.Request) (user, error) { return tt.user, nil }
+
+			r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
+				"short":    {"infra"},
+				"long":     {"http://infra/"},
+				"owner":    {"foo@example.com"},
+				"coowners": {tt.coOwners},
+				"xsrf":     {xsrftoken.Generate(s.xsrfKey, tt.user.login, "infra")},
+			}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.serveSave(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveSave = %d; want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			want, _ := parseCoOwners(tt.coOwners)
+			link, _ := db.Load("infra")
+			if !cmp.Equal(link.CoOwners, want, cmpopts.EquateEmpty()) {
+				t.Errorf("co-owners = %q; want %q", link.CoOwners, want)
+			}
+
+			// co-owners are included in the JSON detail view
+			r = httptest.NewRequest("GET", "/.detail/infra", nil)
+			w = httptest.NewRecorder()
+			s.serveDetail(w, r)
+			var got Link
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got.CoOwners, want, cmpopts.EquateEmpty()) {
+				t.Errorf("detail co-owners = %q; want %q", got.CoOwners, want)
+			}
+		})
+	}
+}
+
+func TestCanEditLinkCoOwners(t *testing.T) {
+	link := &Link{Short: "a", Owner: "foo@example.com", CoOwners: []string{"bar@example.com", "group:infra"}}
+	trashed := &TrashedLink{Link: *link}
+	s := newTestServer(t, NewMemoryDB())
+	tests := []struct {
+		name string
+		user user
+		want bool
+	}{
+		{"owner", user{login: "foo@example.com"}, true},
+		{"co-owner", user{login: "bar@example.com"}, true},
+		{"group member", user{login: "baz@example.com", groups: []string{"sales", "infra"}}, true},
+		{"other group", user{login: "baz@example.com", groups: []string{"sales"}}, false},
+		{"group named like a login", user{login: "baz@example.com", groups: []string{"bar@example.com"}}, false},
+		{"unknown user in group", user{groups: []string{"infra"}}, false},
+		{"admin", user{login: "admin@example.com", isAdmin: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.canEditLink(context.Background(), link, tt.user); got != tt.want {
+				t.Errorf("canEditLink = %v; want %v", got, tt.want)
+			}
+			if got := canRestoreLink(trashed, tt.user); got != tt.want {
+				t.Errorf("canRestoreLink = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkVisibility(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "pub", Long: "http://pub/", Owner: "foo@example.com", Tags: []string{"shared"}})
+	db.Save(&Link{Short: "mine", Long: "http://mine/", Owner: "foo@example.com", Tags: []string{"shared"}, CoOwners: []string{"group:infra"}, Visibility: "owner"})
+	db.Save(&Link{Short: "fin", Long: "http://fin/", Owner: "bar@example.com", Tags: []string{"shared"}, Visibility: "access:finance"})
+	allLinks := []string{"fin", "mine", "pub"}
+
+	users := []struct {
+		name string
+		user user
+		want []string // visible links, sorted
+	}{
+		{"other user", user{login: "baz@example.com"}, []string{"pub"}},
+		{"owner", user{login: "foo@example.com"}, []string{"mine", "pub"}},
+		{"co-owner", user{login: "baz@example.com", groups: []string{"infra"}}, []string{"mine", "pub"}},
+		{"access", user{login: "baz@example.com", access: []string{"finance"}}, []string{"fin", "pub"}},
+		{"other access", user{login: "baz@example.com", access: []string{"sales"}}, []string{"pub"}},
+		{"admin", user{login: "admin@example.com", isAdmin: true}, allLinks},
+	}
+
+	// listed returns which of allLinks are linked to from a listing page.
+	listed := func(body string) []string {
+		var got []string
+		for _, short := range allLinks {
+			if strings.Contains(body, `href="/.detail/`+short+`"`) {
+				got = append(got, short)
+			}
+		}
+		return got
+	}
+	serve := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	for _, u := range users {
+		t.Run(u.name, func(t *testing.T) {
+			tstest.Replace(t, &s.currentUser, func(*http.Request) (user, error) { return u.user, nil })
+
+			t.Run("serveGo", func(t *testing.T) {
+				for _, short := range allLinks {
+					want := http.StatusForbidden
+					if slices.Contains(u.want, short) {
+						want = http.StatusFound
+					}
+					if w := serve("/" + short); w.Code != want {
+						t.Errorf("serveGo(%q) = %d; want %d", short, w.Code, want)
+					}
+				}
+			})
+			t.Run("serveDetail", func(t *testing.T) {
+				for _, short := range allLinks {
+					want := http.StatusForbidden
+					if slices.Contains(u.want, short) {
+						want = http.StatusOK
+					}
+					if w := serve("/.detail/" + short); w.Code != want {
+						t.Errorf("serveDetail(%q) = %d; want %d", short, w.Code, want)
+					}
+					if w := serve("/.history/" + short); w.Code != want {
+						t.Errorf("serveHistory(%q) = %d; want %d", short, w.Code, want)
+					}
+				}
+			})
+			t.Run("serveAll", func(t *testing.T) {
+				if got := listed(serve("/.all").Body.String()); !slices.Equal(got, u.want) {
+					t.Errorf("serveAll listed %q; want %q", got, u.want)
+				}
+			})
+			t.Run("serveSearch", func(t *testing.T) {
+				for _, q := range []string{"http", "tag:shared"} {
+					if got := listed(serve("/.search?q=" + url.QueryEscape(q)).Body.String()); !slices.Equal(got, u.want) {
+						t.Errorf("serveSearch(%q) listed %q; want %q", q, got, u.want)
+					}
+				}
+			})
+			t.Run("serveExport", func(t *testing.T) {
+				var got []string
+				dec := json.NewDecoder(serve("/.export").Body)
+				for dec.More() {
+					var link Link
+					if err := dec.Decode(&link); err != nil {
+						t.Fatal(err)
+					}
+					if link.Short != "" { // not the snapshot header
+						got = append(got, link.Short)
+					}
+				}
+				if !slices.Equal(got, u.want) {
+					t.Errorf("serveExport = %q; want %q", got, u.want)
+				}
+			})
+			t.Run("serveTags", func(t *testing.T) {
+				var tags []tagCount
+				if err := json.Unmarshal(serve("/.tags").Body.Bytes(), &tags); err != nil {
+					t.Fatal(err)
+				}
+				want := []tagCount{{"shared", len(u.want)}}
+				if !cmp.Equal(tags, want) {
+					t.Errorf("serveTags = %v; want %v", tags, want)
+				}
+			})
+		})
+	}
+
+	s.stats.mu.Lock()
+	clicks := maps.Clone(s.stats.clicks)
+	s.stats.mu.Unlock()
+	// only visible links count clicks: pub by everyone, mine by its owners
+	// and the admin, and fin by the access holder and the admin.
+	if want := (ClickStats{"pub": 6, "mine": 3, "fin": 2}); !cmp.Equal(clicks, want) {
+		t.Errorf("clicks = %v; want %v", clicks, want)
+	}
+
+	// hidden links are left out of the popular links on the home page, and
+	// their stats out of the stats export
+	for _, u := range users {
+		t.Run(u.name+"/serveHome", func(t *testing.T) {
+			tstest.Replace(t, &s.currentUser, func(*http.Request) (user, error) { return u.user, nil })
+			if got := listed(serve("/").Body.String()); !slices.Equal(got, u.want) {
+				t.Errorf("serveHome listed %q; want %q", got, u.want)
+			}
+		})
+		t.Run(u.name+"/serveExportStats", func(t *testing.T) {
+			tstest.Replace(t, &s.currentUser, func(*http.Request) (user, error) { return u.user, nil })
+			var got []string
+			for _, line := range strings.Fields(serve("/.export-stats").Body.String()) {
+				id, _, _ := strings.Cut(line, ",")
+				got = append(got, id)
+			}
+			slices.Sort(got)
+			got = slices.Compact(got)
+			if !slices.Equal(got, u.want) {
+				t.Errorf("serveExportStats listed %q; want %q", got, u.want)
+			}
+		})
+	}
+}
+
+func TestParseVisibility(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "public", want: ""},
+		{in: " owner ", want: "owner"},
+		{in: "access:finance", want: "access:finance"},
+		{in: "access:", wantErr: true},
+		{in: "access:fin/ance", wantErr: true},
+		{in: "private", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVisibility(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseVisibility(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVisibility(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestServeSaveVisibility(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "plans", Long: "http://plans/", Owner: "foo@example.com"})
+
+	save := func(form url.Values) int {
+		form.Set("short", "plans")
+		form.Set("long", "http://plans/")
+		form.Set("xsrf", xsrftoken.Generate(s.xsrfKey, "foo@example.com", "plans"))
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveSave(w, r)
+		return w.Code
+	}
+
+	if code := save(url.Values{"visibility": {"owner"}}); code != http.StatusOK {
+		t.Fatalf("setting visibility = %d; want %d", code, http.StatusOK)
+	}
+	if code := save(url.Values{}); code != http.StatusOK {
+		t.Fatalf("saving without visibility = %d; want %d", code, http.StatusOK)
+	}
+	if link, _ := db.Load("plans"); link.Visibility != "owner" {
+		t.Errorf("visibility = %q; want %q", link.Visibility, "owner")
+	}
+	if code := save(url.Values{"visibility": {"secret"}}); code != http.StatusBadRequest {
+		t.Errorf("invalid visibility = %d; want %d", code, http.StatusBadRequest)
+	}
+	if code := save(url.Values{"visibility": {"public"}}); code != http.StatusOK {
+		t.Fatalf("making public = %d; want %d", code, http.StatusOK)
+	}
+	if link, _ := db.Load("plans"); link.Visibility != "" {
+		t.Errorf("visibility = %q; want public", link.Visibility)
+	}
+}
+
+func TestServeGoExpired(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	past := time.Now().Add(-time.Hour)
+	db.Save(&Link{Short: "conf", Long: "http://conf/", Owner: "bar@example.com", ExpiresAt: past})
+	db.Save(&Link{Short: "swept", Long: "http://swept/", Owner: "bar@example.com", ExpiresAt: past, Expired: true})
+	db.Save(&Link{Short: "launch", Long: "http://launch/", Owner: "bar@example.com", ExpiresAt: time.Now().Add(time.Hour)})
+
+	for _, short := range []string{"conf", "swept"} {
+		r := httptest.NewRequest("GET", "/"+short, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusGone {
+			t.Errorf("serveGo(%q) = %d; want %d", short, w.Code, http.StatusGone)
+		}
+		if loc := w.Header().Get("Location"); loc != "" {
+			t.Errorf("serveGo(%q) redirected to %q", short, loc)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "has expired") || !strings.Contains(body, "bar@example.com") {
+			t.Errorf("serveGo(%q) body does not explain expiry and owner:\n%s", short, body)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/launch", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("serveGo(unexpired) = %d; want %d", w.Code, http.StatusFound)
+	}
+
+	s.stats.mu.Lock()
+	got := maps.Clone(s.stats.clicks)
+	s.stats.mu.Unlock()
+	if want := (ClickStats{"launch": 1}); !cmp.Equal(got, want) {
+		t.Errorf("clicks = %v; want %v", got, want)
+	}
+}
+
+func TestServeExpiredLinks(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "old", ExpiresAt: time.Now().Add(-time.Hour)})
+	db.Save(&Link{Short: "new", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := s.expireLinks(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		user       user
+		wantStatus int
+		want       []string
+	}{
+		{"admin", user{login: "admin@example.com", isAdmin: true}, http.StatusOK, []string{"old"}},
+		{"non-admin", user{login: "foo@example.com"}, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tstest.Replace(t, &s.currentUser, func(*http.Request) (user, error) { return tt.user, nil })
+			r := httptest.NewRequest("GET", "/.expired", nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("serveExpiredLinks = %d; want %d", w.Code, tt.wantStatus)
 			}
-			var got string
-			if link != nil {
-				got = link.String()
+			if tt.wantStatus != http.StatusOK {
+				return
 			}
-			if got != tt.want {
-				t.Errorf("expandLink(%q) = %q; want %q", tt.long, got, tt.want)
+			var links []*Link
+			if err := json.Unmarshal(w.Body.Bytes(), &links); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, link := range links {
+				got = append(got, link.Short)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("serveExpiredLinks = %q; want %q", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestServeSaveExpires(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "conf", Long: "http://conf/", Owner: "foo@example.com", ExpiresAt: time.Now().Add(-time.Hour), Expired: true})
+
+	save := func(expires string) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{
+			"short":   {"conf"},
+			"long":    {"http://conf/"},
+			"expires": {expires},
+			"xsrf":    {xsrftoken.Generate(s.xsrfKey, "foo@example.com", "conf")},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveSave(w, r)
+		return w.Code
+	}
+
+	future := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	if code := save(future.Format(time.RFC3339)); code != http.StatusOK {
+		t.Fatalf("renewing = %d; want %d", code, http.StatusOK)
+	}
+	link, _ := db.Load("conf")
+	if !link.ExpiresAt.Equal(future) || link.Expired {
+		t.Errorf("after renewing, ExpiresAt = %v, Expired = %v; want %v, false", link.ExpiresAt, link.Expired, future)
+	}
+
+	if code := save("2001-01-01T00:00:00Z"); code != http.StatusBadRequest {
+		t.Errorf("expiry in the past = %d; want %d", code, http.StatusBadRequest)
+	}
+
+	if code := save(""); code != http.StatusOK {
+		t.Fatalf("removing expiry = %d; want %d", code, http.StatusOK)
+	}
+	if link, _ := db.Load("conf"); !link.ExpiresAt.IsZero() {
+		t.Errorf("after removing, ExpiresAt = %v; want zero", link.ExpiresAt)
+	}
+}
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "2022-06-03T10:00:00-07:00", want: time.Date(2022, 06, 03, 17, 0, 0, 0, time.UTC)},
+		{in: "2022-06-03T10:00", want: time.Date(2022, 06, 03, 10, 0, 0, 0, time.UTC)},
+		{in: "2022-06-03", want: time.Date(2022, 06, 03, 0, 0, 0, 0, time.UTC)},
+		{in: "2022-06-01", wantErr: true},
+		{in: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseExpires(tt.in, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseExpires(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseExpires(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
 func TestResolveLink(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
+	db, err := NewSQLiteDB(":memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
+	s := newTestServer(t, db)
 	db.Save(&Link{Short: "meet", Long: "https://meet.google.com/lookup/"})
 	db.Save(&Link{Short: "cs", Long: "http://codesearch/{{with .Path}}search?q={{.}}{{end}}"})
 	db.Save(&Link{Short: "m", Long: "http://go/meet"})
 	db.Save(&Link{Short: "chat", Long: "/meet"})
+	db.Save(&Link{Short: "codesearch", Long: "http://codesearch/{{with .Path}}search?q={{.}}{{end}}", Aliases: []string{"code"}})
 
 	tests := []struct {
 		link string
 		want string
 	}{
+		{
+			link: "go/code/term",
+			want: "http://codesearch/search?q=term",
+		},
 		{
 			link: "meet",
 			want: "https://meet.google.com/lookup/",
@@ -714,7 +2462,7 @@ func TestResolveLink(t *testing.T) {
 		name := "golink " + tt.link
 		t.Run(name, func(t *testing.T) {
 			u := must.Get(url.Parse(tt.link))
-			got, err := resolveLink(u)
+			got, err := s.resolveLink(u)
 			if err != nil {
 				t.Error(err)
 			}
@@ -723,14 +2471,54 @@ func TestResolveLink(t *testing.T) {
 			}
 		})
 	}
+
+	// links that point at each other fail rather than recursing forever
+	db.Save(&Link{Short: "ping", Long: "http://go/pong"})
+	db.Save(&Link{Short: "pong", Long: "go/ping"})
+	_, err = s.resolveLink(must.Get(url.Parse("go/ping")))
+	if want := "link cycle: ping -> pong -> ping"; err == nil || err.Error() != want {
+		t.Errorf("ResolveLink(%q) error = %v; want %q", "go/ping", err, want)
+	}
+}
+
+func TestChainedShort(t *testing.T) {
+	tests := []struct {
+		hostname      string
+		dst           string
+		wantShort     string
+		wantRemainder string
+		wantOK        bool
+	}{
+		{hostname: "go", dst: "http://go/who/p", wantShort: "who", wantRemainder: "p", wantOK: true},
+		{hostname: "go", dst: "https://GO/who", wantShort: "who", wantOK: true},
+		{hostname: "go", dst: "go/who", wantShort: "who", wantOK: true},
+		{hostname: "go", dst: "/who", wantShort: "who", wantOK: true},
+		{hostname: "go", dst: "http://go/", wantOK: false},
+		{hostname: "go", dst: "http://go/.detail/who", wantOK: false},
+		{hostname: "go", dst: "http://who/", wantOK: false},
+		{hostname: "localhost:8080", dst: "http://localhost:8080/who", wantShort: "who", wantOK: true},
+		{hostname: "localhost:8080", dst: "/who", wantShort: "who", wantOK: true},
+		{hostname: "localhost:8080", dst: "http://localhost:9090/who", wantOK: false},
+		{hostname: "localhost:8080", dst: "http://localhost/who", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname+" "+tt.dst, func(t *testing.T) {
+			s := newTestServer(t, NewMemoryDB())
+			s.opts.Hostname = tt.hostname
+			short, remainder, ok := s.chainedShort(must.Get(url.Parse(tt.dst)))
+			if short != tt.wantShort || remainder != tt.wantRemainder || ok != tt.wantOK {
+				t.Errorf("chainedShort(%q) = %q, %q, %v; want %q, %q, %v", tt.dst, short, remainder, ok, tt.wantShort, tt.wantRemainder, tt.wantOK)
+			}
+		})
+	}
 }
 
 func TestNoHSTSShortDomain(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
+	db, err := NewSQLiteDB(":memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
+	s := newTestServer(t, db)
 	db.Save(&Link{Short: "foobar", Long: "http://foobar/"})
 
 	tests := []struct {
@@ -753,7 +2541,7 @@ func TestNoHSTSShortDomain(t *testing.T) {
 			r.Header.Add("Host", tt.host)
 
 			w := httptest.NewRecorder()
-			HSTS(serveHandler()).ServeHTTP(w, r)
+			HSTS(s.Handler()).ServeHTTP(w, r)
 
 			_, found := w.Header()["Strict-Transport-Security"]
 			if found != tt.expectHsts {
@@ -777,11 +2565,11 @@ func TestHTTPSRedirectHandlerWithQuery(t *testing.T) {
 }
 
 func TestServeSearch(t *testing.T) {
-	var err error
-	db, err = NewSQLiteDB(":memory:")
+	db, err := NewSQLiteDB(":memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
+	s := newTestServer(t, db)
 	links := []*Link{
 		{Short: "alpha", Long: "http://alpha/", Owner: "foo@example.com"},
 		{Short: "beta", Long: "http://beta/", Owner: "foo@example.com"},
@@ -828,7 +2616,7 @@ func TestServeSearch(t *testing.T) {
 			testURL := "/.search?q=owner:" + url.QueryEscape(tt.owner)
 			r := httptest.NewRequest("GET", testURL, nil)
 			w := httptest.NewRecorder()
-			serveHandler().ServeHTTP(w, r)
+			s.Handler().ServeHTTP(w, r)
 
 			if w.Code != tt.wantStatus {
 				t.Errorf("serveSearch(owner=%q) = %d; want %d", tt.owner, w.Code, tt.wantStatus)
@@ -849,15 +2637,220 @@ func TestServeSearch(t *testing.T) {
 	}
 }
 
+func TestServeSearchText(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	links := []*Link{
+		{Short: "alpha", Long: "http://wiki/alpha", Owner: "foo@example.com"},
+		{Short: "beta", Long: "http://wiki/beta", Owner: "bar@example.com"},
+		{Short: "gamma", Long: "http://docs/gamma", Owner: "foo@example.com"},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContains    []string
+		wantNotContains []string
+	}{
+		{
+			name:            "text",
+			query:           "wiki",
+			wantStatus:      http.StatusOK,
+			wantContains:    []string{"alpha", "beta", "2 total"},
+			wantNotContains: []string{"gamma"},
+		},
+		{
+			name:            "text and owner",
+			query:           "wiki owner:foo@example.com",
+			wantStatus:      http.StatusOK,
+			wantContains:    []string{"alpha", "1 total"},
+			wantNotContains: []string{"beta", "gamma"},
+		},
+		{
+			name:         "no matches",
+			query:        "nothing",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"0 total"},
+		},
+		{
+			name:       "empty",
+			query:      " ",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/.search?q="+url.QueryEscape(tt.query), nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("serveSearch(%q) = %d; want %d", tt.query, w.Code, tt.wantStatus)
+			}
+			body := w.Body.String()
+			for _, s := range tt.wantContains {
+				if !strings.Contains(body, s) {
+					t.Errorf("serveSearch(%q) body missing %q", tt.query, s)
+				}
+			}
+			for _, s := range tt.wantNotContains {
+				if strings.Contains(body, s) {
+					t.Errorf("serveSearch(%q) body unexpectedly contains %q", tt.query, s)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want searchQuery
+	}{
+		{"", searchQuery{}},
+		{"owner:foo@example.com", searchQuery{owner: "foo@example.com"}},
+		{" wiki  docs ", searchQuery{terms: []string{"wiki", "docs"}}},
+		{"wiki owner:foo@example.com docs", searchQuery{owner: "foo@example.com", terms: []string{"wiki", "docs"}}},
+		{"tag:Team:Infra tag:oncall wiki", searchQuery{tags: []string{"team:infra", "oncall"}, terms: []string{"wiki"}}},
+	}
+	for _, tt := range tests {
+		got := parseSearchQuery(tt.in)
+		if !cmp.Equal(got, tt.want, cmp.AllowUnexported(searchQuery{})) {
+			t.Errorf("parseSearchQuery(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestServeSearchTags(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	links := []*Link{
+		{Short: "alpha", Long: "http://wiki/alpha", Owner: "foo@example.com", Tags: []string{"team:infra", "oncall"}},
+		{Short: "beta", Long: "http://wiki/beta", Owner: "bar@example.com", Tags: []string{"team:infra"}},
+		{Short: "gamma", Long: "http://docs/gamma", Owner: "foo@example.com"},
+	}
+	for _, link := range links {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"tag:team:infra", []string{"alpha", "beta"}},
+		{"tag:TEAM:INFRA tag:oncall", []string{"alpha"}},
+		{"tag:team:infra owner:bar@example.com", []string{"beta"}},
+		{"wiki tag:oncall", []string{"alpha"}},
+		{"tag:nothing", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/.search?q="+url.QueryEscape(tt.query), nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("serveSearch(%q) = %d; want %d", tt.query, w.Code, http.StatusOK)
+			continue
+		}
+		body := w.Body.String()
+		if want := fmt.Sprintf("%d total", len(tt.want)); !strings.Contains(body, want) {
+			t.Errorf("serveSearch(%q) body missing %q", tt.query, want)
+		}
+		for _, short := range tt.want {
+			if !strings.Contains(body, `href="/`+short+`"`) {
+				t.Errorf("serveSearch(%q) body missing %q", tt.query, short)
+			}
+		}
+	}
+}
+
+func TestServeTags(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "a", Tags: []string{"oncall", "team:infra"}})
+	db.Save(&Link{Short: "b", Tags: []string{"team:infra"}})
+
+	r := httptest.NewRequest("GET", "/.tags", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("serveTags = %d; want %d", w.Code, http.StatusOK)
+	}
+	var got []tagCount
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []tagCount{{"team:infra", 2}, {"oncall", 1}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("serveTags = %v; want %v", got, want)
+	}
+
+	r = httptest.NewRequest("GET", "/.tags", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if body := w.Body.String(); !strings.Contains(body, "/.search?q=tag:team%3ainfra") {
+		t.Errorf("serveTags HTML missing link to tag search")
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "oncall", want: []string{"oncall"}},
+		{in: "Team:Infra, oncall  deprecated,oncall", want: []string{"deprecated", "oncall", "team:infra"}},
+		{in: "docs/api", want: []string{"docs/api"}},
+		{in: "bad<tag>", wantErr: true},
+		{in: ":leading", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTags(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTags(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !cmp.Equal(got, tt.want) {
+			t.Errorf("parseTags(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRankedSearchResults(t *testing.T) {
+	s := newTestServer(t, NewMemoryDB())
+	s.stats.clicks = ClickStats{"popular": 100, "unused": 0}
+
+	matches := []LinkMatch{
+		{Link: &Link{Short: "best"}, Score: 20},
+		{Link: &Link{Short: "unused"}, Score: 10},
+		{Link: &Link{Short: "popular"}, Score: 10},
+	}
+	// Frequent clicks boost popular above both the equally relevant unused
+	// link and the more relevant best link.
+	want := []string{"popular", "best", "unused"}
+
+	var got []string
+	for _, r := range s.rankedSearchResults(matches) {
+		got = append(got, r.Short)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("rankedSearchResults order = %q; want %q", got, want)
+	}
+}
+
 func TestSearchResults(t *testing.T) {
-	stats.mu.Lock()
-	stats.clicks = ClickStats{"alpha": 3, "beta": 10}
-	stats.mu.Unlock()
-	t.Cleanup(func() {
-		stats.mu.Lock()
-		stats.clicks = nil
-		stats.mu.Unlock()
-	})
+	s := newTestServer(t, NewMemoryDB())
+	s.stats.clicks = ClickStats{"alpha": 3, "beta": 10}
 
 	links := []*Link{
 		{Short: "alpha"},
@@ -876,7 +2869,7 @@ func TestSearchResults(t *testing.T) {
 		{Short: "gamma", NumClicks: 0},
 	}
 
-	got := searchResults(links)
+	got := s.searchResults(links)
 	if len(got) != len(want) {
 		t.Fatalf("searchResults returned %d results; want %d", len(got), len(want))
 	}
@@ -947,7 +2940,7 @@ func TestParseAdvertiseTags(t *testing.T) {
 func TestTrustIdentityHeaders(t *testing.T) {
 	tests := []struct {
 		name        string
-		serviceName string // value to set *serviceName to
+		serviceName string // value of the ServiceName option
 		remoteAddr  string
 		want        bool
 	}{
@@ -978,10 +2971,10 @@ func TestTrustIdentityHeaders(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tstest.Replace(t, serviceName, tt.serviceName)
+			s := &Server{opts: Options{ServiceName: tt.serviceName}}
 			r := httptest.NewRequest("GET", "/", nil)
 			r.RemoteAddr = tt.remoteAddr
-			if got := trustIdentityHeaders(r); got != tt.want {
+			if got := s.trustIdentityHeaders(r); got != tt.want {
 				t.Errorf("got %v, want %v", got, tt.want)
 			}
 		})
@@ -995,13 +2988,21 @@ func TestExtractUserFromHeaders(t *testing.T) {
 		},
 	}
 	noCapMap := tailcfg.PeerCapMap{}
+	groupCapMap := tailcfg.PeerCapMap{
+		peerCapName: []tailcfg.RawMessage{
+			tailcfg.RawMessage(must.Get(json.Marshal(capabilities{Groups: []string{"infra"}}))),
+			tailcfg.RawMessage(must.Get(json.Marshal(capabilities{Groups: []string{"group:oncall", "infra"}, Access: []string{"finance"}}))),
+		},
+	}
 
 	tests := []struct {
-		name      string
-		headers   map[string]string
-		whoisFunc func(context.Context, string) (*apitype.WhoIsResponse, error) // mock for localClient.WhoIs
-		wantLogin string
-		wantAdmin bool
+		name       string
+		headers    map[string]string
+		whoisFunc  func(context.Context, string) (*apitype.WhoIsResponse, error) // mock for LocalClient.WhoIs
+		wantLogin  string
+		wantAdmin  bool
+		wantGroups []string
+		wantAccess []string
 	}{
 		{
 			name:      "no headers",
@@ -1038,6 +3039,19 @@ func TestExtractUserFromHeaders(t *testing.T) {
 			wantLogin: "alice@example.com",
 			wantAdmin: false,
 		},
+		{
+			name: "login header with XFF, peer has group caps",
+			headers: map[string]string{
+				"Tailscale-User-Login": "alice@example.com",
+				"X-Forwarded-For":      "100.64.1.1",
+			},
+			whoisFunc: func(_ context.Context, _ string) (*apitype.WhoIsResponse, error) {
+				return &apitype.WhoIsResponse{CapMap: groupCapMap}, nil
+			},
+			wantLogin:  "alice@example.com",
+			wantGroups: []string{"infra", "oncall"},
+			wantAccess: []string{"finance"},
+		},
 		{
 			name: "login header with XFF, WhoIs fails",
 			headers: map[string]string{
@@ -1065,21 +3079,110 @@ func TestExtractUserFromHeaders(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, NewMemoryDB())
 			if tt.whoisFunc != nil {
-				tstest.Replace(t, &whoisFunc, tt.whoisFunc)
+				s.whoisFunc = tt.whoisFunc
 			}
 
 			r := httptest.NewRequest("GET", "/", nil)
 			for k, v := range tt.headers {
 				r.Header.Set(k, v)
 			}
-			got := extractUserFromHeaders(r)
+			got := s.extractUserFromHeaders(r)
 			if got.login != tt.wantLogin {
 				t.Errorf("login: got %q, want %q", got.login, tt.wantLogin)
 			}
 			if got.isAdmin != tt.wantAdmin {
 				t.Errorf("isAdmin: got %v, want %v", got.isAdmin, tt.wantAdmin)
 			}
+			if !cmp.Equal(got.groups, tt.wantGroups) {
+				t.Errorf("groups: got %q, want %q", got.groups, tt.wantGroups)
+			}
+			if !cmp.Equal(got.access, tt.wantAccess) {
+				t.Errorf("access: got %q, want %q", got.access, tt.wantAccess)
+			}
+		})
+	}
+}
+
+// BenchmarkResolve measures link resolution by serveGo against a SQLite
+// database, both when idle and while click stats for every link are being
+// flushed every few milliseconds.
+func BenchmarkResolve(b *testing.B) {
+	const numLinks = 1000
+	db, err := NewSQLiteDB(filepath.Join(b.TempDir(), "links.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	// no link cache, so that every request reads the link from SQLite
+	s, err := NewServer(Options{Store: db, Dev: true, LinkCacheSize: 0})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, ok := s.db.(*SQLiteDB); !ok {
+		b.Fatalf("server store is %T; want *SQLiteDB", s.db)
+	}
+	clicks := make(ClickStats)
+	for i := range numLinks {
+		short := fmt.Sprintf("link-%d", i)
+		if err := db.Save(&Link{Short: short, Long: "http://" + short + "/", Tags: []string{"bench"}}); err != nil {
+			b.Fatal(err)
+		}
+		clicks[short] = i
+	}
+	handler := s.Handler()
+
+	resolve := func(b *testing.B) {
+		var mu sync.Mutex
+		var latencies []time.Duration
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			var local []time.Duration
+			for i := 0; pb.Next(); i++ {
+				r := httptest.NewRequest("GET", fmt.Sprintf("/link-%d", i%numLinks), nil)
+				w := httptest.NewRecorder()
+				start := time.Now()
+				handler.ServeHTTP(w, r)
+				local = append(local, time.Since(start))
+				if w.Code != http.StatusFound {
+					b.Errorf("serveGo = %d; want %d", w.Code, http.StatusFound)
+					return
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
 		})
+		b.StopTimer()
+		slices.Sort(latencies)
+		if len(latencies) > 0 {
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+			b.ReportMetric(float64(latencies[len(latencies)-1].Microseconds()), "max-µs")
+		}
 	}
+
+	b.Run("idle", resolve)
+	b.Run("flushing", func(b *testing.B) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			ticker := time.NewTicker(5 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if err := db.SaveStats(clicks); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		resolve(b)
+		cancel()
+		wg.Wait()
+	})
 }
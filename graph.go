@@ -0,0 +1,172 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Graph formats written by serveGraph.
+const (
+	graphJSON = "json"
+	graphDOT  = "dot" // Graphviz
+)
+
+// graphDOTMediaType is the media type of Graphviz DOT files.
+const graphDOTMediaType = "text/vnd.graphviz"
+
+// linkGraph is the graph of links whose destinations are other go links.
+type linkGraph struct {
+	Links []string    // short names of the links in Edges, sorted
+	Edges []graphEdge // sorted by From
+}
+
+// graphEdge is a link whose destination is another go link.
+type graphEdge struct {
+	From, To string // short names
+
+	// Cycle is whether the edge is part of a cycle of links that point
+	// at each other, which cannot be resolved.
+	Cycle bool `json:",omitempty"`
+}
+
+// buildLinkGraph returns the graph of links whose destinations point at
+// other links in links, as resolved by resolveChain. Destinations are
+// expanded with no path, so links that only point at other links for some
+// paths may be missed, as may those whose destination cannot be expanded
+// for user.
+func (s *Server) buildLinkGraph(links []*Link, user string) *linkGraph {
+	names := make(map[string]string) // link or alias ID => short name
+	for _, link := range links {
+		names[linkID(link.Short)] = link.Short
+		for _, alias := range link.Aliases {
+			names[linkID(alias)] = link.Short
+		}
+	}
+
+	next := make(map[string]string) // short name => short name it points at
+	env := expandEnv{Now: time.Now().UTC(), user: user}
+	for _, link := range links {
+		dst, err := expandLink(link.Long, env)
+		if err != nil {
+			continue
+		}
+		short, _, ok := s.chainedShort(dst)
+		if !ok {
+			continue
+		}
+		if to, ok := names[linkID(short)]; ok {
+			next[link.Short] = to
+		}
+	}
+
+	g := new(linkGraph)
+	seen := make(map[string]bool)
+	for from, to := range next {
+		g.Edges = append(g.Edges, graphEdge{From: from, To: to, Cycle: onCycle(next, from)})
+		for _, short := range []string{from, to} {
+			if !seen[short] {
+				seen[short] = true
+				g.Links = append(g.Links, short)
+			}
+		}
+	}
+	slices.Sort(g.Links)
+	slices.SortFunc(g.Edges, func(a, b graphEdge) int {
+		return strings.Compare(a.From, b.From)
+	})
+	return g
+}
+
+// onCycle reports whether following next from short leads back to short.
+// Each link points at no more than one other, so a walk that does not
+// return within len(next) steps never will.
+func onCycle(next map[string]string, short string) bool {
+	cur := short
+	for range len(next) {
+		var ok bool
+		if cur, ok = next[cur]; !ok {
+			return false
+		}
+		if cur == short {
+			return true
+		}
+	}
+	return false
+}
+
+// graphFormat returns the format requested by r, either by the "format"
+// parameter or else by its Accept header, defaulting to JSON.
+func graphFormat(r *http.Request) (string, error) {
+	if format := r.FormValue("format"); format != "" {
+		switch format {
+		case graphJSON, graphDOT:
+			return format, nil
+		}
+		return "", fmt.Errorf("unknown graph format %q; want %q or %q", format, graphJSON, graphDOT)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == graphDOTMediaType {
+			return graphDOT, nil
+		}
+	}
+	return graphJSON, nil
+}
+
+// writeDOT writes g to w as a Graphviz digraph, with edges that are part
+// of a cycle colored red.
+func writeDOT(w io.Writer, g *linkGraph) error {
+	var b strings.Builder
+	b.WriteString("digraph golinks {\n")
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q", e.From, e.To)
+		if e.Cycle {
+			b.WriteString(" [color=red]")
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serveGraph handles requests to /.graph, describing which links point at
+// which other links, as JSON or, with format=dot, as a Graphviz digraph.
+// Only links the current user can see are included.
+func (s *Server) serveGraph(w http.ResponseWriter, r *http.Request) {
+	format, err := graphFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	links, err := s.db.LoadAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	g := s.buildLinkGraph(visibleLinks(links, cu), cu.login)
+
+	if format == graphDOT {
+		w.Header().Set("Content-Type", graphDOTMediaType+"; charset=utf-8")
+		writeDOT(w, g)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(g)
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServeGraph(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	for _, link := range []*Link{
+		{Short: "who", Long: "http://who/", Aliases: []string{"whois"}},
+		{Short: "people", Long: "http://go/whois/people"},
+		{Short: "me", Long: "/people/{{.User}}"},
+		{Short: "ping", Long: "go/pong"},
+		{Short: "pong", Long: "/ping"},
+		{Short: "self", Long: "/self/again"},
+		{Short: "missing", Long: "/does-not-exist"},
+		{Short: "secret", Long: "/who", Owner: "bar@example.com", Visibility: visibilityOwner},
+	} {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(t *testing.T, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d; want %d\n%s", target, w.Code, http.StatusOK, w.Body)
+		}
+		return w
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var got linkGraph
+		if err := json.Unmarshal(get(t, "/.graph").Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := linkGraph{
+			Links: []string{"me", "people", "ping", "pong", "self", "who"},
+			Edges: []graphEdge{
+				{From: "me", To: "people"},
+				{From: "people", To: "who"},
+				{From: "ping", To: "pong", Cycle: true},
+				{From: "pong", To: "ping", Cycle: true},
+				{From: "self", To: "self", Cycle: true},
+			},
+		}
+		if !cmp.Equal(got, want) {
+			t.Errorf("graph diff (-want +got):\n%s", cmp.Diff(want, got))
+		}
+	})
+
+	t.Run("dot", func(t *testing.T) {
+		w := get(t, "/.graph?format=dot")
+		if got, want := w.Header().Get("Content-Type"), "text/vnd.graphviz; charset=utf-8"; got != want {
+			t.Errorf("Content-Type = %q; want %q", got, want)
+		}
+		want := `digraph golinks {
+	"me" -> "people";
+	"people" -> "who";
+	"ping" -> "pong" [color=red];
+	"pong" -> "ping" [color=red];
+	"self" -> "self" [color=red];
+}
+`
+		if got := w.Body.String(); got != want {
+			t.Errorf("DOT graph diff (-want +got):\n%s", cmp.Diff(want, got))
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/.graph", nil)
+		r.Header.Set("Accept", "text/vnd.graphviz")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if got, want := w.Header().Get("Content-Type"), "text/vnd.graphviz; charset=utf-8"; got != want {
+			t.Errorf("Content-Type = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/.graph?format=svg", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /.graph?format=svg = %d; want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHealthCheckTimeout is how long a destination has to respond to
+	// a health check if the HealthCheckTimeout option is zero.
+	defaultHealthCheckTimeout = 10 * time.Second
+
+	// healthCheckConcurrency is the number of destinations checked at once.
+	healthCheckConcurrency = 8
+
+	// healthCheckPath is the Path that template links are expanded with
+	// when their destinations are checked, standing in for whatever a user
+	// would type after the link's name.
+	healthCheckPath = "test"
+
+	// healthCheckUserAgent identifies health check requests to the servers
+	// that receive them.
+	healthCheckUserAgent = "golink-health-check"
+)
+
+// brokenLink is a link whose destination was found to be broken by its most
+// recent health check.
+type brokenLink struct {
+	*Link
+	Health *LinkHealth
+}
+
+// brokenLinks returns the links whose current destinations were found to be
+// broken by their most recent check in health, sorted by short name.
+// Expired links, which no longer resolve anyway, are left out.
+func brokenLinks(links []*Link, health map[string]*LinkHealth, now time.Time) []brokenLink {
+	var broken []brokenLink
+	for _, link := range links {
+		h := health[linkID(link.Short)]
+		if h == nil || h.Long != link.Long || !h.Broken() || link.IsExpired(now) {
+			continue
+		}
+		broken = append(broken, brokenLink{Link: link, Health: h})
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].Short < broken[j].Short
+	})
+	return broken
+}
+
+// checkLink checks the destination of link, following it through any other
+// go links it points to. It returns nil if the destination is not an HTTP
+// or HTTPS URL, which cannot be checked.
+//
+// Template links are expanded as if they were visited by their owner with
+// healthCheckPath after the link's name.
+func (s *Server) checkLink(ctx context.Context, link *Link) *LinkHealth {
+	h := &LinkHealth{ID: linkID(link.Short), Long: link.Long, Checked: time.Now().UTC()}
+	env := expandEnv{Now: h.Checked, user: link.Owner}
+	if strings.Contains(link.Long, "{{") {
+		env.Path = healthCheckPath
+	}
+	dst, _, err := s.resolveChain(link, env, func(l *Link) error {
+		if l.IsExpired(env.Now) {
+			return fmt.Errorf("%s/%s has expired", s.opts.Hostname, l.Short)
+		}
+		return nil
+	})
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	if short, _, ok := s.chainedShort(dst); ok {
+		// resolveChain follows links that exist
+		h.URL = dst.String()
+		h.Error = fmt.Sprintf("%s/%s does not exist", s.opts.Hostname, short)
+		return h
+	}
+	if dst.Scheme != "http" && dst.Scheme != "https" || dst.Host == "" {
+		return nil
+	}
+	h.URL = dst.String()
+
+	timeout := s.opts.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	h.Status, err = s.requestStatus(ctx, http.MethodHead, h.URL)
+	if err == nil && (h.Status == http.StatusMethodNotAllowed || h.Status == http.StatusNotImplemented) {
+		// some servers only answer GET requests
+		start = time.Now()
+		h.Status, err = s.requestStatus(ctx, http.MethodGet, h.URL)
+	}
+	h.Latency = time.Since(start)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		h.Error = fmt.Sprintf("no response within %v", timeout)
+	case err != nil:
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// requestStatus makes a request to dst with the specified method, following
+// any redirects, and returns the status of the final response.
+func (s *Server) requestStatus(ctx context.Context, method, dst string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, dst, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+	client := s.opts.HealthCheckClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err // dst is recorded separately
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// checkLinkHealth checks the destinations of all links that have not
+// expired, records the results, and updates the broken link metric.
+func (s *Server) checkLinkHealth(ctx context.Context) error {
+	links, err := s.db.LoadAll()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	links = slices.DeleteFunc(links, func(link *Link) bool {
+		return link.IsExpired(now)
+	})
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	queue := make(chan *Link)
+	for range healthCheckConcurrency {
+		wg.Go(func() {
+			for link := range queue {
+				h := s.checkLink(ctx, link)
+				if h == nil || ctx.Err() != nil {
+					continue
+				}
+				if err := s.db.SaveLinkHealth(h); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", link.Short, err))
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	for _, link := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- link
+	}
+	close(queue)
+	wg.Wait()
+
+	n, err := s.updateBrokenLinkCount()
+	if err != nil {
+		errs = append(errs, err)
+	} else if s.opts.Verbose {
+		log.Printf("Checked %v links; %v are broken.", len(links), n)
+	}
+	return errors.Join(errs...)
+}
+
+// updateBrokenLinkCount sets the broken link metric from the stored health
+// check results, returning the number of broken links.
+func (s *Server) updateBrokenLinkCount() (int, error) {
+	links, err := s.db.LoadAll()
+	if err != nil {
+		return 0, err
+	}
+	health, err := s.db.LoadLinkHealth()
+	if err != nil {
+		return 0, err
+	}
+	n := len(brokenLinks(links, health, time.Now()))
+	s.metrics.brokenLinks.Set(float64(n))
+	return n, nil
+}
+
+// healthCheckLoop will check link destinations every HealthCheckInterval.
+// This function returns when ctx is done.
+func (s *Server) healthCheckLoop(ctx context.Context) {
+	for {
+		if err := s.checkLinkHealth(ctx); err != nil {
+			log.Printf("checking link health: %v", err)
+		}
+		if !sleep(ctx, s.opts.HealthCheckInterval) {
+			return
+		}
+	}
+}
+
+// serveBroken handles requests to /.broken, listing the links whose
+// destinations failed their most recent health check. Admins see all such
+// links and other users see those they own or co-own. Either can narrow
+// the list with the "owner" and "tag" parameters.
+func (s *Server) serveBroken(w http.ResponseWriter, r *http.Request) {
+	cu, err := s.currentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	links, err := s.db.LoadAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	health, err := s.db.LoadLinkHealth()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	owner := strings.TrimSpace(r.FormValue("owner"))
+	tag := strings.ToLower(strings.TrimSpace(r.FormValue("tag")))
+	links = slices.DeleteFunc(links, func(link *Link) bool {
+		if !cu.isAdmin && !isLinkOwner(link, cu) {
+			return true
+		}
+		if owner != "" && !strings.EqualFold(link.Owner, owner) && !slices.Contains(link.CoOwners, owner) {
+			return true
+		}
+		return tag != "" && !slices.Contains(link.Tags, tag)
+	})
+	broken := brokenLinks(links, health, time.Now())
+
+	if !acceptHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(broken)
+		return
+	}
+	s.brokenTmpl.Execute(w, brokenData{
+		Links: broken,
+		Owner: owner,
+		Tag:   tag,
+		Admin: cu.isAdmin,
+	})
+}
+
+// brokenData is the data used by brokenTmpl.
+type brokenData struct {
+	Links []brokenLink
+	Owner string // owner filter, if any
+	Tag   string // tag filter, if any
+	Admin bool   // whether the current user sees all links
+}
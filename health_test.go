@@ -0,0 +1,246 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+func TestCheckLinkHealth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/gone", http.NotFound)
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	mux.HandleFunc("/search/{q}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("q") != healthCheckPath {
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.Handle("/moved", http.RedirectHandler("/gone", http.StatusFound))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	s.opts.HealthCheckTimeout = 100 * time.Millisecond
+	for _, link := range []*Link{
+		{Short: "ok", Long: ts.URL + "/ok"},
+		{Short: "gone", Long: ts.URL + "/gone"},
+		{Short: "get-only", Long: ts.URL + "/get-only"},
+		{Short: "slow", Long: ts.URL + "/slow"},
+		{Short: "search", Long: ts.URL + "/search/{{.Path}}"},
+		{Short: "private", Long: ts.URL + "/private"},
+		{Short: "moved", Long: ts.URL + "/moved"},
+		{Short: "to-ok", Long: "go/ok"},
+		{Short: "to-missing", Long: "/missing"},
+		{Short: "mail", Long: "mailto:foo@example.com"},
+		{Short: "old", Long: ts.URL + "/gone", ExpiresAt: time.Now().Add(-time.Hour)},
+	} {
+		if err := db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.checkLinkHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	health, err := db.LoadLinkHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		short      string
+		wantStatus int
+		wantError  string // substring of the recorded error
+		wantBroken bool
+	}{
+		{short: "ok", wantStatus: 200},
+		{short: "gone", wantStatus: 404, wantBroken: true},
+		{short: "get-only", wantStatus: 200},
+		{short: "slow", wantError: "no response within 100ms", wantBroken: true},
+		{short: "search", wantStatus: 200},
+		{short: "private", wantStatus: 401},
+		{short: "moved", wantStatus: 404, wantBroken: true},
+		{short: "to-ok", wantStatus: 200},
+		{short: "to-missing", wantError: "go/missing does not exist", wantBroken: true},
+	}
+	for _, tt := range tests {
+		h := health[linkID(tt.short)]
+		if h == nil {
+			t.Errorf("%s: not checked", tt.short)
+			continue
+		}
+		if h.Status != tt.wantStatus || !strings.Contains(h.Error, tt.wantError) || tt.wantError == "" && h.Error != "" {
+			t.Errorf("%s: status %d, error %q; want %d, %q", tt.short, h.Status, h.Error, tt.wantStatus, tt.wantError)
+		}
+		if h.Broken() != tt.wantBroken {
+			t.Errorf("%s: Broken() = %v; want %v", tt.short, h.Broken(), tt.wantBroken)
+		}
+	}
+	if got, want := health[linkID("to-ok")].URL, ts.URL+"/ok"; got != want {
+		t.Errorf("to-ok: checked %q; want %q", got, want)
+	}
+	for _, short := range []string{"mail", "old"} {
+		if h := health[short]; h != nil {
+			t.Errorf("%s: checked with result %+v; want unchecked", short, h)
+		}
+	}
+	if got, want := metricValue(t, s.metrics.brokenLinks), 4.0; got != want {
+		t.Errorf("golink_broken_links = %v; want %v", got, want)
+	}
+}
+
+func TestServeBroken(t *testing.T) {
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	checked := time.Now().UTC()
+	for _, tt := range []struct {
+		link   *Link
+		health *LinkHealth
+	}{
+		{
+			&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Tags: []string{"people"}},
+			&LinkHealth{Long: "http://who/", URL: "http://who/", Status: 404},
+		},
+		{
+			&Link{Short: "wiki", Long: "http://wiki/", Owner: "bar@example.com", CoOwners: []string{"foo@example.com"}},
+			&LinkHealth{Long: "http://wiki/", URL: "http://wiki/", Error: "connection refused"},
+		},
+		{
+			&Link{Short: "oncall", Long: "http://oncall/", Owner: "bar@example.com", Tags: []string{"oncall"}},
+			&LinkHealth{Long: "http://oncall/", URL: "http://oncall/", Status: 503},
+		},
+		{
+			&Link{Short: "fine", Long: "http://fine/", Owner: "foo@example.com"},
+			&LinkHealth{Long: "http://fine/", URL: "http://fine/", Status: 200},
+		},
+		{
+			// fixed since it was checked
+			&Link{Short: "edited", Long: "http://edited/new", Owner: "foo@example.com"},
+			&LinkHealth{Long: "http://edited/", URL: "http://edited/", Status: 404},
+		},
+		{
+			&Link{Short: "old", Long: "http://old/", Owner: "foo@example.com", ExpiresAt: checked.Add(-time.Hour)},
+			&LinkHealth{Long: "http://old/", URL: "http://old/", Status: 404},
+		},
+	} {
+		if err := db.Save(tt.link); err != nil {
+			t.Fatal(err)
+		}
+		tt.health.ID = linkID(tt.link.Short)
+		tt.health.Checked = checked
+		if err := db.SaveLinkHealth(tt.health); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := func(*http.Request) (user, error) {
+		return user{login: "baz@example.com", isAdmin: true}, nil
+	}
+	tests := []struct {
+		name        string
+		target      string
+		currentUser func(*http.Request) (user, error)
+		want        []string
+	}{
+		{
+			name:   "owner",
+			target: "/.broken",
+			want:   []string{"who", "wiki"},
+		},
+		{
+			name:   "owner filtered by tag",
+			target: "/.broken?tag=People",
+			want:   []string{"who"},
+		},
+		{
+			name:        "admin",
+			target:      "/.broken",
+			currentUser: admin,
+			want:        []string{"oncall", "who", "wiki"},
+		},
+		{
+			name:        "admin filtered by owner",
+			target:      "/.broken?owner=bar@example.com",
+			currentUser: admin,
+			want:        []string{"oncall", "wiki"},
+		},
+		{
+			name:        "admin filtered by co-owner",
+			target:      "/.broken?owner=foo@example.com",
+			currentUser: admin,
+			want:        []string{"who", "wiki"},
+		},
+		{
+			name:        "admin filtered by tag",
+			target:      "/.broken?tag=oncall",
+			currentUser: admin,
+			want:        []string{"oncall"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.currentUser != nil {
+				tstest.Replace(t, &s.currentUser, tt.currentUser)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d; want %d\n%s", tt.target, w.Code, http.StatusOK, w.Body)
+			}
+			var broken []brokenLink
+			if err := json.NewDecoder(w.Body).Decode(&broken); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, b := range broken {
+				got = append(got, b.Short)
+				if b.Health == nil || !b.Health.Broken() {
+					t.Errorf("%s: health %+v is not broken", b.Short, b.Health)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("broken links = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("html", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/.broken", nil)
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /.broken = %d; want %d", w.Code, http.StatusOK)
+		}
+		for _, want := range []string{"go/who", "HTTP 404", "connection refused"} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("GET /.broken does not contain %q", want)
+			}
+		}
+	})
+}
@@ -0,0 +1,434 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Import formats accepted by readImport.
+const (
+	importJSONL     = "jsonl"     // golink snapshots, as returned by /.export
+	importCSV       = "csv"       // short,long,owner rows
+	importBookmarks = "bookmarks" // Netscape bookmark files exported by browsers
+	importJSON      = "json"      // exports of other go link servers
+)
+
+// importFormats maps the name of each import format to its reader.
+var importFormats = map[string]func(io.Reader) ([]importRow, error){
+	importJSONL:     readSnapshotRows,
+	importCSV:       readCSVRows,
+	importBookmarks: readBookmarkRows,
+	importJSON:      readJSONRows,
+}
+
+// importRow is a link read by an importer, or the reason it could not be.
+type importRow struct {
+	Row  int // 1-based line, or bookmark or object number
+	Link *Link
+	Err  error
+}
+
+// ImportError describes a row of an import that was rejected.
+type ImportError struct {
+	Row   int
+	Short string `json:",omitempty"`
+	Error string
+}
+
+// importFormatForFile returns the import format for the file named name,
+// based on its extension.
+func importFormatForFile(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return importCSV
+	case ".html", ".htm":
+		return importBookmarks
+	}
+	// golink snapshots are conventionally named links.json
+	return importJSONL
+}
+
+// readImport reads links in the named format from r and validates them the
+// way serveSave does. Rows that cannot be read or are invalid are reported
+// as errors rather than returned; an error is only returned if r cannot be
+// read at all.
+func readImport(format string, r io.Reader) ([]importRow, []ImportError, error) {
+	if format == "" {
+		format = importJSONL
+	}
+	read, ok := importFormats[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown import format %q; want %q, %q, %q, or %q", format, importJSONL, importCSV, importBookmarks, importJSON)
+	}
+	rows, err := read(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var valid []importRow
+	var errs []ImportError
+	seen := make(map[string]int) // link ID => row
+	for _, row := range rows {
+		if row.Err == nil {
+			row.Err = validateImportedLink(row.Link)
+		}
+		if row.Err == nil {
+			if prev, ok := seen[linkID(row.Link.Short)]; ok {
+				row.Err = fmt.Errorf("duplicate of row %d", prev)
+			} else {
+				seen[linkID(row.Link.Short)] = row.Row
+			}
+		}
+		if row.Err != nil {
+			errs = append(errs, row.importError())
+			continue
+		}
+		valid = append(valid, row)
+	}
+	return valid, errs, nil
+}
+
+// importError returns the ImportError reporting why row was rejected.
+func (row importRow) importError() ImportError {
+	e := ImportError{Row: row.Row, Error: row.Err.Error()}
+	if row.Link != nil {
+		e.Short = row.Link.Short
+	}
+	return e
+}
+
+// sortImportErrors sorts errs by row and returns it.
+func sortImportErrors(errs []ImportError) []ImportError {
+	slices.SortStableFunc(errs, func(a, b ImportError) int {
+		return a.Row - b.Row
+	})
+	return errs
+}
+
+// importRowLinks returns the links read in rows.
+func importRowLinks(rows []importRow) []*Link {
+	links := make([]*Link, len(rows))
+	for i, row := range rows {
+		links[i] = row.Link
+	}
+	return links
+}
+
+// checkImportChains rejects the links in rows that, once imported with
+// mode, would be part of a chain of go links that cannot be resolved, as
+// serveSave does for a single link. Links are followed through both the
+// imported links and those already saved. It returns the rows that were
+// not rejected along with errors for those that were.
+func (s *Server) checkImportChains(rows []importRow, mode ImportMode) ([]importRow, []ImportError, error) {
+	links := importRowLinks(rows)
+	report, err := s.db.ImportLinks(links, mode, "", true)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved := make(map[string]bool) // shorts of the links the import saves
+	for _, short := range slices.Concat(report.Created, report.Changed) {
+		saved[short] = true
+	}
+	imported := make(map[string]*Link) // by link ID
+	for _, link := range links {
+		if saved[link.Short] {
+			imported[linkID(link.Short)] = link
+		}
+	}
+	load := func(short string) (*Link, error) {
+		if link := imported[linkID(short)]; link != nil {
+			return link, nil
+		}
+		return s.loadLink(short)
+	}
+
+	var valid []importRow
+	var errs []ImportError
+	now := time.Now().UTC()
+	for _, row := range rows {
+		if imported[linkID(row.Link.Short)] != nil {
+			var chainErr *chainError
+			env := expandEnv{Now: now, user: row.Link.Owner}
+			if _, _, err := s.resolveChainWith(row.Link, env, nil, load); errors.As(err, &chainErr) {
+				row.Err = fmt.Errorf("invalid destination: %w", err)
+				errs = append(errs, row.importError())
+				continue
+			}
+		}
+		valid = append(valid, row)
+	}
+	return valid, errs, nil
+}
+
+// validateImportedLink reports whether link could have been saved by
+// serveSave, and normalizes its tags and visibility.
+func validateImportedLink(link *Link) error {
+	if err := validateShortLong(link.Short, link.Long); err != nil {
+		return err
+	}
+	if _, err := parseAliases(strings.Join(link.Aliases, ",")); err != nil {
+		return err
+	}
+	if _, err := parseCoOwners(strings.Join(link.CoOwners, ",")); err != nil {
+		return err
+	}
+	tags, err := parseTags(strings.Join(link.Tags, ","))
+	if err != nil {
+		return err
+	}
+	visibility, err := parseVisibility(link.Visibility)
+	if err != nil {
+		return err
+	}
+	link.Tags, link.Visibility = tags, visibility
+	return nil
+}
+
+// readSnapshotRows reads the links in a snapshot. Any stats and history in
+// the snapshot are ignored.
+func readSnapshotRows(r io.Reader) ([]importRow, error) {
+	snap, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Links, nil
+}
+
+// readCSVRows reads links from CSV records of the form short,long,owner.
+//
+// If the first record is a header naming a "short" column, columns are
+// instead matched by name, and "description" and "tags" columns are read
+// as well. The owner column is optional.
+func readCSVRows(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // rows are checked individually
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	cols := map[string]int{"short": 0, "long": 1, "owner": 2, "description": -1, "tags": -1}
+	var rows []importRow
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr := (*csv.ParseError)(nil); errors.As(err, &perr) {
+			rows = append(rows, importRow{Row: perr.Line, Err: perr.Err})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if first && slices.ContainsFunc(record, func(f string) bool { return strings.EqualFold(strings.TrimSpace(f), "short") }) {
+			for name := range cols {
+				cols[name] = -1
+			}
+			for i, name := range record {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if _, ok := cols[name]; ok {
+					cols[name] = i
+				}
+			}
+			if cols["long"] < 0 {
+				return nil, errors.New(`CSV header has no "long" column`)
+			}
+			continue
+		}
+
+		field := func(name string) string {
+			if i := cols[name]; i >= 0 && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if len(record) <= cols["long"] {
+			rows = append(rows, importRow{Row: line, Err: fmt.Errorf("expected at least %d fields, got %d", cols["long"]+1, len(record))})
+			continue
+		}
+		link := &Link{
+			Short:       field("short"),
+			Long:        field("long"),
+			Owner:       field("owner"),
+			Description: field("description"),
+		}
+		if tags := field("tags"); tags != "" {
+			link.Tags = strings.Split(tags, ",")
+		}
+		rows = append(rows, importRow{Row: line, Link: link})
+	}
+	return rows, nil
+}
+
+// readBookmarkRows reads links from a Netscape bookmark file, the HTML
+// format browsers use to export bookmarks.
+//
+// A bookmark's short name is its keyword (the SHORTCUTURL attribute), in
+// which case its title becomes the link description. Bookmarks without a
+// keyword use their title as the short name. The TAGS attribute becomes
+// the link's tags.
+func readBookmarkRows(r io.Reader) ([]importRow, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var rows []importRow
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.DataAtom != atom.A {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, a := range n.Attr {
+			attrs[strings.ToLower(a.Key)] = a.Val
+		}
+		href, ok := attrs["href"]
+		if !ok {
+			continue // an anchor, not a bookmark
+		}
+		title := strings.TrimSpace(textContent(n))
+		link := &Link{Long: href}
+		if keyword := strings.TrimSpace(attrs["shortcuturl"]); keyword != "" {
+			link.Short = keyword
+			link.Description = title
+		} else {
+			link.Short = title
+		}
+		if tags := attrs["tags"]; tags != "" {
+			link.Tags = strings.Split(tags, ",")
+		}
+		link.Created = unixAttr(attrs["add_date"])
+		link.LastEdit = unixAttr(attrs["last_modified"])
+		rows = append(rows, importRow{Row: len(rows) + 1, Link: link})
+	}
+	return rows, nil
+}
+
+// textContent returns the text within n.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	for d := range n.Descendants() {
+		if d.Type == html.TextNode {
+			sb.WriteString(d.Data)
+		}
+	}
+	return sb.String()
+}
+
+// unixAttr parses a bookmark time attribute, in seconds since the UNIX
+// epoch. It returns the zero time if s is empty or invalid.
+func unixAttr(s string) time.Time {
+	sec, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// jsonLinkFields lists, for each Link field set by readJSONRows, the
+// lowercase names other go link servers use for it in their exports.
+var jsonLinkFields = map[string][]string{
+	"short":       {"short", "shortpath", "name", "keyword", "key", "slug", "alias"},
+	"long":        {"long", "destination_url", "destination", "url", "target", "href"},
+	"owner":       {"owner", "created_by", "creator", "user"},
+	"description": {"description", "desc", "title"},
+}
+
+// hasJSONLinkField reports whether obj has a member naming a link's short
+// name, and so is a link rather than a wrapper around a list of links.
+func hasJSONLinkField(obj map[string]json.RawMessage) bool {
+	for k := range obj {
+		if slices.Contains(jsonLinkFields["short"], strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSONRows reads links exported by other go link servers. The input is
+// either a JSON array of objects, an object with such an array as its only
+// array-valued member (such as {"links": [...]}), or one object per line.
+// Fields are matched by the names in jsonLinkFields, ignoring case.
+func readJSONRows(r io.Reader) ([]importRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+
+	var objects []json.RawMessage
+	switch {
+	case len(data) == 0:
+		return nil, nil
+	case data[0] == '[':
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, err
+		}
+	default:
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(data, &wrapper); err == nil && !hasJSONLinkField(wrapper) {
+			var arrays []json.RawMessage
+			for _, v := range wrapper {
+				if v := bytes.TrimSpace(v); len(v) > 0 && v[0] == '[' {
+					arrays = append(arrays, v)
+				}
+			}
+			if len(arrays) == 1 {
+				if err := json.Unmarshal(arrays[0], &objects); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		// one object per line
+		for line := range bytes.Lines(data) {
+			objects = append(objects, bytes.TrimSpace(line))
+		}
+	}
+
+	var rows []importRow
+	for i, obj := range objects {
+		if len(obj) == 0 {
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(obj, &fields); err != nil {
+			rows = append(rows, importRow{Row: i + 1, Err: err})
+			continue
+		}
+		values := make(map[string]string)
+		for k, v := range fields {
+			if s, ok := v.(string); ok {
+				values[strings.ToLower(k)] = strings.TrimSpace(s)
+			}
+		}
+		field := func(name string) string {
+			for _, k := range jsonLinkFields[name] {
+				if v := values[k]; v != "" {
+					return v
+				}
+			}
+			return ""
+		}
+		rows = append(rows, importRow{Row: i + 1, Link: &Link{
+			Short:       strings.TrimPrefix(field("short"), "/"),
+			Long:        field("long"),
+			Owner:       field("owner"),
+			Description: field("description"),
+		}})
+	}
+	return rows, nil
+}
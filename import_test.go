@@ -0,0 +1,177 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReadImport(t *testing.T) {
+	added := time.Unix(1654131723, 0).UTC()
+
+	tests := []struct {
+		name     string
+		format   string
+		input    string
+		want     []*Link
+		wantErrs []ImportError
+	}{
+		{
+			name:   "jsonl",
+			format: importJSONL,
+			input: `{"Short":"who","Long":"http://who/","Owner":"foo@example.com","Tags":["People"]}
+
+{"Short":"bad name","Long":"http://bad/"}
+not json
+{"Short":"Who","Long":"http://who/2"}
+{"Short":"tmpl","Long":"http://x/{{.Path"}
+{"Short":"vis","Long":"http://vis/","Visibility":"everyone"}
+`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/", Owner: "foo@example.com", Tags: []string{"people"}},
+			},
+			wantErrs: []ImportError{
+				{Row: 3, Short: "bad name", Error: "short may only contain letters, numbers, dash, and period"},
+				{Row: 4, Error: "invalid character 'o' in literal null (expecting 'u')"},
+				{Row: 5, Short: "Who", Error: "duplicate of row 1"},
+				{Row: 6, Short: "tmpl", Error: "long contains an invalid template: template: :1: unclosed action"},
+				{Row: 7, Short: "vis", Error: `invalid visibility "everyone": use "public", "owner", or "access:name"`},
+			},
+		},
+		{
+			name:   "csv",
+			format: importCSV,
+			input: `# exported from the wiki
+who,http://who/,foo@example.com
+"docs", "http://docs/"
+nolong
+,http://empty/
+`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/", Owner: "foo@example.com"},
+				{Short: "docs", Long: "http://docs/"},
+			},
+			wantErrs: []ImportError{
+				{Row: 4, Error: "expected at least 2 fields, got 1"},
+				{Row: 5, Error: "short and long required"},
+			},
+		},
+		{
+			name:   "csv header",
+			format: importCSV,
+			input: `Long,Tags,Short,Description
+http://who/,"people,oncall",who,Find people
+http://x/,bad tag!,x,
+`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/", Description: "Find people", Tags: []string{"oncall", "people"}},
+			},
+			wantErrs: []ImportError{
+				{Row: 3, Short: "x", Error: `invalid tag "tag!": tags may only contain letters, numbers, dash, period, colon, and slash`},
+			},
+		},
+		{
+			name:   "bookmarks",
+			format: importBookmarks,
+			input: `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1654131723">go links</H3>
+    <DL><p>
+        <DT><A HREF="http://who/" ADD_DATE="1654131723" SHORTCUTURL="who" TAGS="people">Find people</A>
+        <DT><A HREF="http://docs/" ADD_DATE="1654131723" LAST_MODIFIED="1654131783">docs</A>
+        <DT><A HREF="http://news/">Company news</A>
+    </DL><p>
+</DL><p>
+`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/", Description: "Find people", Tags: []string{"people"}, Created: added},
+				{Short: "docs", Long: "http://docs/", Created: added, LastEdit: added.Add(time.Minute)},
+			},
+			wantErrs: []ImportError{
+				{Row: 3, Short: "Company news", Error: "short may only contain letters, numbers, dash, and period"},
+			},
+		},
+		{
+			name:   "json array",
+			format: importJSON,
+			input: `[
+				{"shortpath": "who", "destination_url": "http://who/", "owner": "foo@example.com"},
+				{"name": "/docs", "url": "http://docs/", "title": "Docs"},
+				{"keyword": "nourl"},
+				"not an object"
+			]`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/", Owner: "foo@example.com"},
+				{Short: "docs", Long: "http://docs/", Description: "Docs"},
+			},
+			wantErrs: []ImportError{
+				{Row: 3, Short: "nourl", Error: "short and long required"},
+				{Row: 4, Error: "json: cannot unmarshal string into Go value of type map[string]interface {}"},
+			},
+		},
+		{
+			name:   "json wrapper",
+			format: importJSON,
+			input:  `{"ok": true, "links": [{"name": "who", "url": "http://who/"}]}`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/"},
+			},
+		},
+		{
+			name:   "json lines",
+			format: importJSON,
+			input: `{"name": "who", "url": "http://who/", "tags": ["ignored"]}
+{"name": "docs", "url": "http://docs/"}
+`,
+			want: []*Link{
+				{Short: "who", Long: "http://who/"},
+				{Short: "docs", Long: "http://docs/"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, errs, err := readImport(tt.format, strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, importRowLinks(rows), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("readImport links diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantErrs, errs); diff != "" {
+				t.Errorf("readImport errors diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, _, err := readImport("xml", strings.NewReader("")); err == nil {
+		t.Error("readImport with unknown format succeeded; want error")
+	}
+	if _, _, err := readImport(importCSV, strings.NewReader("short,url\n")); err == nil {
+		t.Error("readImport of CSV header without long column succeeded; want error")
+	}
+}
+
+func TestImportFormatForFile(t *testing.T) {
+	tests := map[string]string{
+		"links.json":     importJSONL,
+		"links.jsonl":    importJSONL,
+		"links.CSV":      importCSV,
+		"bookmarks.html": importBookmarks,
+		"bookmarks.htm":  importBookmarks,
+	}
+	for name, want := range tests {
+		if got := importFormatForFile(name); got != want {
+			t.Errorf("importFormatForFile(%q) = %q; want %q", name, got, want)
+		}
+	}
+}
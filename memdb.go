@@ -0,0 +1,704 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tstime"
+)
+
+// MemoryDB stores Links in memory. It is primarily useful for tests, and
+// for short-lived servers that do not need to persist links.
+type MemoryDB struct {
+	mu      sync.RWMutex
+	links   map[string]*Link  // keyed by linkID
+	aliases map[string]string // alias ID => link ID
+	stats   []StatsRecord
+	revs    []*Revision            // in order of creation, with LinkID always set
+	health  map[string]*LinkHealth // keyed by linkID
+
+	trash      map[string]*TrashedLink  // keyed by linkID
+	trashStats map[string][]StatsRecord // keyed by linkID
+
+	clock tstime.Clock // allow overriding time for tests
+}
+
+var _ LinkStore = (*MemoryDB)(nil)
+
+// NewMemoryDB returns a new, empty MemoryDB.
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		links:      make(map[string]*Link),
+		aliases:    make(map[string]string),
+		health:     make(map[string]*LinkHealth),
+		trash:      make(map[string]*TrashedLink),
+		trashStats: make(map[string][]StatsRecord),
+	}
+}
+
+// Now returns the current time.
+func (m *MemoryDB) Now() time.Time {
+	return tstime.DefaultClock{Clock: m.clock}.Now()
+}
+
+// storedTime returns t with the same precision that SQLiteDB persists,
+// so that both stores return identical values.
+func storedTime(t time.Time) time.Time {
+	return time.Unix(t.Unix(), 0).UTC()
+}
+
+// copyLink returns a copy of link as it would be returned from storage.
+func copyLink(link *Link) *Link {
+	l := *link
+	l.Created = storedTime(l.Created)
+	l.LastEdit = storedTime(l.LastEdit)
+	l.Tags = cleanTags(l.Tags)
+	l.Aliases = cleanAliases(l.Short, l.Aliases)
+	l.CoOwners = cleanCoOwners(l.Owner, l.CoOwners)
+	if !l.ExpiresAt.IsZero() {
+		l.ExpiresAt = storedTime(l.ExpiresAt)
+	}
+	return &l
+}
+
+// LoadAll returns all stored Links.
+//
+// The caller owns the returned values.
+func (m *MemoryDB) LoadAll() ([]*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*Link
+	for _, link := range m.links {
+		links = append(links, copyLink(link))
+	}
+	return links, nil
+}
+
+// Load returns a Link by its short name.
+//
+// It returns fs.ErrNotExist if the link does not exist.
+//
+// The caller owns the returned value.
+func (m *MemoryDB) Load(short string) (*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, ok := m.links[linkID(short)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return copyLink(link), nil
+}
+
+// Save saves a Link.
+func (m *MemoryDB) Save(link *Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked(link)
+}
+
+// SaveWithRevision saves a Link and records rev, the version of the link
+// that it replaces.
+func (m *MemoryDB) SaveWithRevision(link *Link, rev *Revision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveLocked(link); err != nil {
+		return err
+	}
+	m.saveRevisionLocked(rev)
+	return nil
+}
+
+// saveLocked saves a Link. The caller must hold m.mu.
+func (m *MemoryDB) saveLocked(link *Link) error {
+	id := linkID(link.Short)
+	if owner, ok := m.aliases[id]; ok && owner != id {
+		return fmt.Errorf("%q is an alias: %w", link.Short, ErrNameInUse)
+	}
+	l := copyLink(link)
+	for _, alias := range l.Aliases {
+		aid := linkID(alias)
+		_, isLink := m.links[aid]
+		if owner, ok := m.aliases[aid]; isLink || ok && owner != id {
+			return fmt.Errorf("alias %q: %w", alias, ErrNameInUse)
+		}
+	}
+	if old, ok := m.links[id]; ok {
+		m.unindexAliases(old)
+	}
+	m.links[id] = l
+	for _, alias := range l.Aliases {
+		m.aliases[linkID(alias)] = id
+	}
+	return nil
+}
+
+// unindexAliases removes link's aliases from m.aliases.
+// The caller must hold m.mu.
+func (m *MemoryDB) unindexAliases(link *Link) {
+	for _, alias := range link.Aliases {
+		delete(m.aliases, linkID(alias))
+	}
+}
+
+// Delete removes a Link using its short name.
+//
+// Like SQLiteDB, it fails without wrapping fs.ErrNotExist if the link does
+// not exist.
+func (m *MemoryDB) Delete(short string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := linkID(short)
+	link, ok := m.links[id]
+	if !ok {
+		return errors.New("expected to affect 1 row, affected 0")
+	}
+	m.unindexAliases(link)
+	delete(m.links, id)
+	delete(m.health, id)
+	return nil
+}
+
+// LoadByAlias returns the Link that alias resolves to.
+//
+// It returns fs.ErrNotExist if there is no such alias.
+//
+// The caller owns the returned value.
+func (m *MemoryDB) LoadByAlias(alias string) (*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, ok := m.links[m.aliases[linkID(alias)]]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return copyLink(link), nil
+}
+
+// GetLinksByOwner returns all Links owned by the specified owner.
+func (m *MemoryDB) GetLinksByOwner(owner string) ([]*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*Link
+	for _, link := range m.links {
+		if strings.EqualFold(link.Owner, owner) {
+			links = append(links, copyLink(link))
+		}
+	}
+	return links, nil
+}
+
+// SearchLinks returns the Links matching all of the specified terms,
+// most relevant first.
+//
+// Unlike SQLiteDB, terms may match anywhere within a word. Matches in the
+// link name score highest, then the description, then the destination.
+//
+// The caller owns the returned values.
+func (m *MemoryDB) SearchLinks(terms []string) ([]LinkMatch, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []LinkMatch
+outer:
+	for id, link := range m.links {
+		var score float64
+		short, long := strings.ToLower(link.Short), strings.ToLower(link.Long)
+		desc := strings.ToLower(link.Description)
+		for _, t := range terms {
+			t = strings.ToLower(t)
+			var s float64
+			if strings.Contains(short, t) || strings.Contains(id, t) {
+				s += 10
+			}
+			if strings.Contains(desc, t) {
+				s += 2
+			}
+			if strings.Contains(long, t) {
+				s++
+			}
+			if s == 0 {
+				continue outer
+			}
+			score += s
+		}
+		matches = append(matches, LinkMatch{Link: copyLink(link), Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Short < matches[j].Short
+	})
+	return matches, nil
+}
+
+// GetLinksByTag returns all Links with the specified tag.
+func (m *MemoryDB) GetLinksByTag(tag string) ([]*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tag = strings.ToLower(tag)
+	var links []*Link
+	for _, link := range m.links {
+		if slices.Contains(link.Tags, tag) {
+			links = append(links, copyLink(link))
+		}
+	}
+	return links, nil
+}
+
+// LoadTags returns the number of links with each tag.
+func (m *MemoryDB) LoadTags() (map[string]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tags := make(map[string]int)
+	for _, link := range m.links {
+		for _, tag := range link.Tags {
+			tags[tag]++
+		}
+	}
+	return tags, nil
+}
+
+// ExpireLinks marks links whose ExpiresAt is at or before now as expired,
+// returning the number of newly expired links.
+func (m *MemoryDB) ExpireLinks(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	now = storedTime(now)
+	for _, link := range m.links {
+		if !link.Expired && !link.ExpiresAt.IsZero() && !link.ExpiresAt.After(now) {
+			link.Expired = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetExpiredLinks returns all Links that have been marked expired.
+func (m *MemoryDB) GetExpiredLinks() ([]*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*Link
+	for _, link := range m.links {
+		if link.Expired {
+			links = append(links, copyLink(link))
+		}
+	}
+	return links, nil
+}
+
+// LoadStats returns click stats for links.
+func (m *MemoryDB) LoadStats() (ClickStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clicks := make(map[string]int) // map ID => clicks
+	for _, r := range m.stats {
+		clicks[r.ID] += r.Clicks
+	}
+	stats := make(ClickStats)
+	for id, n := range clicks {
+		var short string
+		if link, ok := m.links[id]; ok {
+			short = link.Short
+		}
+		stats[short] = n
+	}
+	return stats, nil
+}
+
+// LoadStatsRecords returns all stored click stats entries, ordered by
+// creation time and then link ID.
+func (m *MemoryDB) LoadStatsRecords() ([]StatsRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := append([]StatsRecord(nil), m.stats...)
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].Created.Equal(records[j].Created) {
+			return records[i].Created.Before(records[j].Created)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+// SaveStats records click stats for links.  The provided map includes
+// incremental clicks that have occurred since the last time SaveStats
+// was called.
+func (m *MemoryDB) SaveStats(stats ClickStats) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := storedTime(m.Now())
+	for short, clicks := range stats {
+		m.stats = append(m.stats, StatsRecord{ID: linkID(short), Created: now, Clicks: clicks})
+	}
+	return nil
+}
+
+// SaveStatsRecords stores click stats entries as they are.
+func (m *MemoryDB) SaveStatsRecords(records []StatsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range records {
+		r.Created = storedTime(r.Created)
+		m.stats = append(m.stats, r)
+	}
+	return nil
+}
+
+// CompactStats rolls up click stats entries into hourly and daily buckets.
+func (m *MemoryDB) CompactStats(hourlyBefore, dailyBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stats = rollupStatsRecords(m.stats, hourlyBefore, time.Hour)
+	m.stats = rollupStatsRecords(m.stats, dailyBefore, 24*time.Hour)
+	return nil
+}
+
+// rollupStatsRecords combines the records created before the specified time
+// into one record per link per bucket of the specified size.
+func rollupStatsRecords(records []StatsRecord, before time.Time, bucket time.Duration) []StatsRecord {
+	size := int64(bucket / time.Second)
+	cutoff := statsBucket(before.Unix(), size)
+
+	type key struct {
+		id      string
+		created int64
+	}
+	var keys []key // in order of first appearance
+	clicks := make(map[key]int)
+	var out []StatsRecord
+	for _, r := range records {
+		if r.Created.Unix() >= cutoff {
+			out = append(out, r)
+			continue
+		}
+		k := key{r.ID, statsBucket(r.Created.Unix(), size)}
+		if _, ok := clicks[k]; !ok {
+			keys = append(keys, k)
+		}
+		clicks[k] += r.Clicks
+	}
+	for _, k := range keys {
+		out = append(out, StatsRecord{ID: k.id, Created: time.Unix(k.created, 0).UTC(), Clicks: clicks[k]})
+	}
+	return out
+}
+
+// DeleteStats deletes click stats for a link.
+func (m *MemoryDB) DeleteStats(short string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := linkID(short)
+	stats := m.stats[:0]
+	for _, r := range m.stats {
+		if r.ID != id {
+			stats = append(stats, r)
+		}
+	}
+	m.stats = stats
+	return nil
+}
+
+// SaveRevision records a previous version of a link.
+func (m *MemoryDB) SaveRevision(rev *Revision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveRevisionLocked(rev)
+	return nil
+}
+
+// saveRevisionLocked records a previous version of a link.
+// m.mu must be held.
+func (m *MemoryDB) saveRevisionLocked(rev *Revision) {
+	rev.ID = int64(len(m.revs) + 1)
+	r := *rev
+	r.Created = storedTime(r.Created)
+	r.LinkID = revisionLinkID(rev)
+	m.revs = append(m.revs, &r)
+}
+
+// LoadRevisions returns the recorded revisions of a link, newest first.
+func (m *MemoryDB) LoadRevisions(short string) ([]*Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id := linkID(short)
+	var revs []*Revision
+	for i := len(m.revs) - 1; i >= 0; i-- {
+		if m.revs[i].LinkID == id {
+			r := *m.revs[i]
+			if linkID(r.Short) == id {
+				r.LinkID = ""
+			}
+			revs = append(revs, &r)
+		}
+	}
+	return revs, nil
+}
+
+// TrashLink moves a Link and its click stats into the trash.
+func (m *MemoryDB) TrashLink(short, deletedBy string, deleted time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := linkID(short)
+	link, ok := m.links[id]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	m.trash[id] = &TrashedLink{Link: *link, DeletedBy: deletedBy, Deleted: storedTime(deleted)}
+
+	var trashed []StatsRecord
+	stats := m.stats[:0]
+	for _, r := range m.stats {
+		if r.ID == id {
+			trashed = append(trashed, r)
+		} else {
+			stats = append(stats, r)
+		}
+	}
+	m.stats = stats
+	m.trashStats[id] = trashed
+	m.unindexAliases(link)
+	delete(m.links, id)
+	delete(m.health, id)
+	return nil
+}
+
+// LoadTrash returns all links in the trash.
+//
+// The caller owns the returned values.
+func (m *MemoryDB) LoadTrash() ([]*TrashedLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*TrashedLink
+	for _, link := range m.trash {
+		links = append(links, &TrashedLink{
+			Link:      *copyLink(&link.Link),
+			DeletedBy: link.DeletedBy,
+			Deleted:   link.Deleted,
+		})
+	}
+	return links, nil
+}
+
+// RestoreLink moves a Link and its click stats out of the trash.
+func (m *MemoryDB) RestoreLink(short string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := linkID(short)
+	if _, ok := m.links[id]; ok {
+		return fs.ErrExist
+	}
+	if _, ok := m.aliases[id]; ok {
+		return fs.ErrExist
+	}
+	trashed, ok := m.trash[id]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	link := trashed.Link
+	// aliases that have since been taken by other links are not restored
+	link.Aliases = slices.DeleteFunc(slices.Clone(link.Aliases), func(alias string) bool {
+		_, isLink := m.links[linkID(alias)]
+		_, isAlias := m.aliases[linkID(alias)]
+		return isLink || isAlias
+	})
+	if len(link.Aliases) == 0 {
+		link.Aliases = nil
+	}
+	for _, alias := range link.Aliases {
+		m.aliases[linkID(alias)] = id
+	}
+	m.links[id] = &link
+	m.stats = append(m.stats, m.trashStats[id]...)
+	delete(m.trash, id)
+	delete(m.trashStats, id)
+	return nil
+}
+
+// PurgeTrash permanently removes links deleted before the specified time
+// from the trash, returning the number of links removed.
+func (m *MemoryDB) PurgeTrash(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	before = storedTime(before)
+	for id, link := range m.trash {
+		if link.Deleted.Before(before) {
+			delete(m.trash, id)
+			delete(m.trashStats, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RenameLink renames a Link, moving its click stats and revisions to the
+// new name.
+func (m *MemoryDB) RenameLink(short, newShort string, keepAlias bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, newID := linkID(short), linkID(newShort)
+	link, ok := m.links[id]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	if newID == id {
+		// only the spelling of the name changed
+		l := *link
+		l.Short = newShort
+		m.links[id] = &l
+		return nil
+	}
+	_, isLink := m.links[newID]
+	if owner, ok := m.aliases[newID]; isLink || ok && owner != id {
+		return fmt.Errorf("%q: %w", newShort, ErrNameInUse)
+	}
+
+	// the new name may have been an alias of the link itself
+	l := *link
+	l.Short = newShort
+	l.Aliases = slices.DeleteFunc(slices.Clone(l.Aliases), func(alias string) bool {
+		return linkID(alias) == newID
+	})
+	if keepAlias {
+		l.Aliases = cleanAliases(newShort, append(l.Aliases, link.Short))
+	}
+	if len(l.Aliases) == 0 {
+		l.Aliases = nil
+	}
+	m.unindexAliases(link)
+	delete(m.links, id)
+	m.links[newID] = &l
+	for _, alias := range l.Aliases {
+		m.aliases[linkID(alias)] = newID
+	}
+	for i, r := range m.stats {
+		if r.ID == id {
+			m.stats[i].ID = newID
+		}
+	}
+	for _, r := range m.revs {
+		if r.LinkID == id {
+			r.LinkID = newID
+		}
+	}
+	if h, ok := m.health[id]; ok {
+		delete(m.health, id)
+		h.ID = newID
+		m.health[newID] = h
+	}
+	return nil
+}
+
+// SaveLinkHealth records the result of checking the destination of a link.
+func (m *MemoryDB) SaveLinkHealth(h *LinkHealth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.links[h.ID]; !ok {
+		return nil
+	}
+	stored := *h
+	stored.Latency = h.Latency.Truncate(time.Millisecond)
+	stored.Checked = storedTime(h.Checked)
+	m.health[h.ID] = &stored
+	return nil
+}
+
+// LoadLinkHealth returns the most recent health check result of each link.
+//
+// The caller owns the returned values.
+func (m *MemoryDB) LoadLinkHealth() (map[string]*LinkHealth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	health := make(map[string]*LinkHealth, len(m.health))
+	for id, h := range m.health {
+		c := *h
+		health[id] = &c
+	}
+	return health, nil
+}
+
+// ImportLinks saves links, merging them with existing links of the same name
+// as specified by mode. If any link cannot be saved, or if dryRun is true,
+// the store is left unchanged.
+func (m *MemoryDB) ImportLinks(links []*Link, mode ImportMode, editor string, dryRun bool) (*ImportReport, error) {
+	if _, err := ParseImportMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Saved links are replaced rather than modified, so shallow copies of
+	// the indexes are enough to roll back. Revisions are only appended.
+	oldLinks, oldAliases, oldRevs := maps.Clone(m.links), maps.Clone(m.aliases), m.revs
+	rollback := func() {
+		m.links, m.aliases, m.revs = oldLinks, oldAliases, oldRevs
+	}
+
+	now := time.Now().UTC()
+	report := &ImportReport{DryRun: dryRun}
+	for _, link := range links {
+		existing, ok := m.links[linkID(link.Short)]
+		var lastEdit time.Time
+		if ok {
+			lastEdit = existing.LastEdit
+		}
+		if !report.importLink(mode, link, ok, lastEdit) {
+			continue
+		}
+		if err := m.saveLocked(link); err != nil {
+			rollback()
+			return nil, fmt.Errorf("importing %q: %w", link.Short, err)
+		}
+		if ok {
+			m.saveRevisionLocked(&Revision{
+				Short:   existing.Short,
+				Long:    existing.Long,
+				Owner:   existing.Owner,
+				Editor:  editor,
+				Created: now,
+			})
+		}
+	}
+	if dryRun {
+		rollback()
+	}
+	return report, nil
+}
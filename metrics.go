@@ -0,0 +1,100 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the Prometheus metrics reported by a Server. Each Server has
+// its own, so that several Servers in one process don't overwrite each
+// other's values.
+type metrics struct {
+	clicks     *prometheus.CounterVec
+	notFound   *prometheus.CounterVec
+	totalLinks prometheus.Gauge
+
+	backupLastSuccess prometheus.Gauge
+	backupFailures    prometheus.Counter
+
+	linkCacheHits   prometheus.Counter
+	linkCacheMisses prometheus.Counter
+
+	brokenLinks prometheus.Gauge
+}
+
+// newMetrics returns a new, unregistered set of metrics.
+func newMetrics() *metrics {
+	return &metrics{
+		clicks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "golink_clicks_total",
+				Help: "Total number of clicks for a recognized GoLink",
+			},
+			[]string{"path"},
+		),
+		notFound: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "golink_not_found_total",
+				Help: "Total number of clicks for a GoLink doesn't exist",
+			},
+			[]string{"path"},
+		),
+		totalLinks: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "golinks_total",
+				Help: "Total number of GoLinks being served",
+			},
+		),
+		backupLastSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "golink_backup_last_success_timestamp_seconds",
+				Help: "UNIX time of the last successful snapshot backup",
+			},
+		),
+		backupFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "golink_backup_failures_total",
+				Help: "Total number of failed snapshot backups",
+			},
+		),
+		linkCacheHits: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "golink_link_cache_hits_total",
+				Help: "Total number of link lookups answered from the link cache",
+			},
+		),
+		linkCacheMisses: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "golink_link_cache_misses_total",
+				Help: "Total number of link lookups that had to query the database",
+			},
+		),
+		brokenLinks: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "golink_broken_links",
+				Help: "Number of GoLinks whose destination failed its most recent health check",
+			},
+		),
+	}
+}
+
+// register registers all of m with r.
+func (m *metrics) register(r prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		m.clicks,
+		m.notFound,
+		m.totalLinks,
+		m.backupLastSuccess,
+		m.backupFailures,
+		m.linkCacheHits,
+		m.linkCacheMisses,
+		m.brokenLinks,
+	} {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// migrationFS holds the numbered SQL migrations applied by migrateSQLite,
+// and those in migrations/postgres applied by migratePostgres.
+//
+// Each file is named NNN_description.sql, where NNN is the schema version
+// the database is at after the migration has been applied. Versions start
+// at 1 and must be contiguous. Once released, a migration must never be
+// edited; add a new one instead. Schema changes need a migration for each
+// database.
+//
+//go:embed migrations/*.sql migrations/postgres/*.sql
+var migrationFS embed.FS
+
+// migration is a single step that upgrades the schema to version.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations are all known SQLite migrations, ordered by version.
+var migrations = mustLoadMigrations(migrationFS, "migrations")
+
+// schemaVersion is the SQLite schema version this binary expects.
+var schemaVersion = len(migrations)
+
+// postgresMigrations are all known PostgreSQL migrations, ordered by version.
+var postgresMigrations = mustLoadMigrations(migrationFS, "migrations/postgres")
+
+// postgresSchemaVersion is the PostgreSQL schema version this binary expects.
+var postgresSchemaVersion = len(postgresMigrations)
+
+// mustLoadMigrations reads the migrations in dir from fsys.
+// This func panics if the migrations are malformed.
+func mustLoadMigrations(fsys fs.FS, dir string) []migration {
+	files, err := fs.Glob(fsys, dir+"/*.sql")
+	if err != nil {
+		panic(err)
+	}
+	ms := make([]migration, 0, len(files))
+	for i, f := range files { // fs.Glob returns names in lexical order
+		name := strings.TrimSuffix(path.Base(f), ".sql")
+		num, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			panic(fmt.Sprintf("migration %q: invalid version: %v", f, err))
+		}
+		if version != i+1 {
+			panic(fmt.Sprintf("migration %q: got version %d, want %d", f, version, i+1))
+		}
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			panic(err)
+		}
+		ms = append(ms, migration{version: version, name: name, sql: string(b)})
+	}
+	return ms
+}
+
+// dbSchemaVersion returns the schema version recorded in db.
+func dbSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// migrateSQLite upgrades db to the latest schema version.
+//
+// The current version is recorded in the database's user_version pragma.
+// Each migration runs in its own transaction along with the version update,
+// so a failed migration leaves the database at the previous version.
+// migrateSQLite returns an error if the database is newer than this binary.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	return migrateSQLiteTo(ctx, db, schemaVersion)
+}
+
+// migrateSQLiteTo upgrades db to the specified schema version.
+func migrateSQLiteTo(ctx context.Context, db *sql.DB, target int) error {
+	current, err := dbSchemaVersion(ctx, db)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if current > schemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d; upgrade golink", current, schemaVersion)
+	}
+	for _, m := range migrations[current:target] {
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs m and records its version in a single transaction.
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	// PRAGMA statements cannot use bound parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postgresMigrationLock is the advisory lock key held while migrating a
+// PostgreSQL database, so that golink servers sharing the database don't
+// apply the same migration concurrently.
+const postgresMigrationLock = 0x676f6c696e6b // "golink"
+
+// postgresSchemaVersionOf returns the schema version recorded in the
+// SchemaVersion table of a PostgreSQL database, or 0 if it has none.
+func postgresSchemaVersionOf(ctx context.Context, tx *sql.Tx) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(max(Version), 0) FROM SchemaVersion").Scan(&version)
+	return version, err
+}
+
+// migratePostgres upgrades db to the latest PostgreSQL schema version.
+//
+// The current version is recorded in the SchemaVersion table. As with
+// migrateSQLite, each migration runs in its own transaction along with the
+// version update. migratePostgres returns an error if the database is newer
+// than this binary.
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS SchemaVersion (Version INTEGER NOT NULL)"); err != nil {
+		return fmt.Errorf("creating schema version table: %w", err)
+	}
+	for {
+		done, err := applyNextPostgresMigration(ctx, db)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// applyNextPostgresMigration applies the next migration db needs, if any,
+// while holding the migration lock. It returns true if db is up to date.
+func applyNextPostgresMigration(ctx context.Context, db *sql.DB) (done bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+		return false, err
+	}
+	current, err := postgresSchemaVersionOf(ctx, tx)
+	if err != nil {
+		return false, fmt.Errorf("reading schema version: %w", err)
+	}
+	if current > postgresSchemaVersion {
+		return false, fmt.Errorf("database schema version %d is newer than supported version %d; upgrade golink", current, postgresSchemaVersion)
+	}
+	if current == postgresSchemaVersion {
+		return true, nil
+	}
+	m := postgresMigrations[current]
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, fmt.Errorf("migration %s: %w", m.name, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM SchemaVersion"); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO SchemaVersion (Version) VALUES ($1)", m.version); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
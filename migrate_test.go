@@ -0,0 +1,171 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Test that a new database is created at the latest schema version.
+func TestMigrateNewDatabase(t *testing.T) {
+	f := path.Join(t.TempDir(), "links.db")
+	db, err := NewSQLiteDB(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dbSchemaVersion(context.Background(), db.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != schemaVersion {
+		t.Errorf("schema version = %d; want %d", got, schemaVersion)
+	}
+
+	// reopening an up-to-date database is a no-op
+	db.db.Close()
+	if _, err := NewSQLiteDB(f); err != nil {
+		t.Errorf("reopening database: %v", err)
+	}
+}
+
+// Test upgrading fixture databases from every past schema version.
+//
+// Each version N has a fixture in testdata/migrations/NNN.sql that is run
+// against a database migrated to version N. The fixture for version 0 is a
+// database created before schema versioning was introduced, so it creates
+// its own tables. Every fixture stores the same link and stats, which must
+// survive the upgrade to the latest version.
+func TestMigrateFixtures(t *testing.T) {
+	for v := 0; v <= schemaVersion; v++ {
+		t.Run(fmt.Sprintf("v%d", v), func(t *testing.T) {
+			fixture, err := os.ReadFile(filepath.Join("testdata", "migrations", fmt.Sprintf("%03d.sql", v)))
+			if err != nil {
+				t.Fatalf("missing fixture for schema version %d: %v", v, err)
+			}
+
+			f := path.Join(t.TempDir(), "links.db")
+			raw, err := sql.Open("sqlite", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := migrateSQLiteTo(context.Background(), raw, v); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := raw.Exec(string(fixture)); err != nil {
+				t.Fatalf("loading fixture: %v", err)
+			}
+			raw.Close()
+
+			db, err := NewSQLiteDB(f)
+			if err != nil {
+				t.Fatalf("upgrading from version %d: %v", v, err)
+			}
+			if got, _ := dbSchemaVersion(context.Background(), db.db); got != schemaVersion {
+				t.Errorf("schema version = %d; want %d", got, schemaVersion)
+			}
+
+			link, err := db.Load("fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantTime := time.Unix(1654131723, 0).UTC()
+			want := &Link{
+				Short:    "Fixture",
+				Long:     "http://fixture/",
+				Created:  wantTime,
+				LastEdit: wantTime,
+				Owner:    "foo@example.com",
+			}
+			if v >= 6 { // descriptions were added in version 6
+				want.Description = "A fixture link"
+			}
+			if v >= 7 { // tags were added in version 7
+				want.Tags = []string{"oncall", "team:infra"}
+			}
+			if v >= 8 { // aliases were added in version 8
+				want.Aliases = []string{"Fix"}
+			}
+			if v >= 9 { // expiration was added in version 9
+				want.ExpiresAt = time.Unix(1969660800, 0).UTC()
+			}
+			if v >= 10 { // co-owners were added in version 10
+				want.CoOwners = []string{"bar@example.com", "group:infra"}
+			}
+			if v >= 11 { // visibility was added in version 11
+				want.Visibility = "access:infra"
+			}
+			if !cmp.Equal(link, want) {
+				t.Errorf("db.Load diff (-want +got):\n%s", cmp.Diff(want, link))
+			}
+
+			stats, err := db.LoadStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (ClickStats{"Fixture": 5}); !cmp.Equal(stats, want) {
+				t.Errorf("db.LoadStats = %v; want %v", stats, want)
+			}
+
+			if v >= 12 { // health checks were added in version 12
+				health, err := db.LoadLinkHealth()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if h := health["fixture"]; h == nil || h.Status != 404 || h.Latency != 25*time.Millisecond {
+					t.Errorf("db.LoadLinkHealth = %v; want the fixture's 404", health)
+				}
+			}
+		})
+	}
+}
+
+// Test that golink refuses to open a database newer than it supports.
+func TestMigrateNewerDatabase(t *testing.T) {
+	f := path.Join(t.TempDir(), "links.db")
+	raw, err := sql.Open("sqlite", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	_, err = NewSQLiteDB(f)
+	if err == nil || !strings.Contains(err.Error(), "newer than supported") {
+		t.Errorf("NewSQLiteDB error = %v; want newer version error", err)
+	}
+}
+
+// Test that a failed migration leaves the database at its previous version.
+func TestMigrateRollback(t *testing.T) {
+	raw, err := sql.Open("sqlite", path.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	bad := migration{version: 1, name: "001_bad", sql: "CREATE TABLE Foo (ID TEXT); SELECT * FROM DoesNotExist;"}
+	if err := applyMigration(context.Background(), raw, bad); err == nil {
+		t.Fatal("applyMigration succeeded; want error")
+	}
+	if v, _ := dbSchemaVersion(context.Background(), raw); v != 0 {
+		t.Errorf("schema version = %d; want 0", v)
+	}
+	var n int
+	raw.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'Foo'").Scan(&n)
+	if n != 0 {
+		t.Error("table from failed migration was not rolled back")
+	}
+}
@@ -1,3 +1,8 @@
+-- Initial golink schema.
+--
+-- Databases created before schema versioning was introduced already have
+-- these tables at user_version 0, so this migration must remain idempotent.
+
 CREATE TABLE IF NOT EXISTS Links (
 	ID       TEXT    PRIMARY KEY,         -- normalized version of Short (foobar)
 	Short    TEXT    NOT NULL DEFAULT "", -- user-provided Short name (Foo-Bar)
@@ -0,0 +1,13 @@
+-- History records previous versions of links, one row per edit.
+
+CREATE TABLE History (
+	RevID    INTEGER PRIMARY KEY AUTOINCREMENT,
+	ID       TEXT    NOT NULL,            -- normalized link ID
+	Short    TEXT    NOT NULL DEFAULT "", -- short name at the time of the edit
+	Long     TEXT    NOT NULL DEFAULT "", -- destination before the edit
+	Owner    TEXT    NOT NULL DEFAULT "", -- owner before the edit
+	Editor   TEXT    NOT NULL DEFAULT "", -- user who made the edit
+	Created  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')) -- unix seconds
+);
+
+CREATE INDEX HistoryByID ON History (ID);
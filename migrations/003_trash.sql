@@ -0,0 +1,23 @@
+-- Trash holds deleted links, along with their stats in TrashStats,
+-- until they are restored or purged.
+
+CREATE TABLE Trash (
+	ID        TEXT    PRIMARY KEY,         -- normalized version of Short
+	Short     TEXT    NOT NULL DEFAULT "",
+	Long      TEXT    NOT NULL DEFAULT "",
+	Created   INTEGER NOT NULL DEFAULT (strftime('%s', 'now')), -- unix seconds
+	LastEdit  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')), -- unix seconds
+	Owner     TEXT    NOT NULL DEFAULT "",
+	DeletedBy TEXT    NOT NULL DEFAULT "", -- user who deleted the link
+	Deleted   INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))  -- unix seconds
+);
+
+CREATE INDEX TrashByDeleted ON Trash (Deleted);
+
+CREATE TABLE TrashStats (
+	ID       TEXT    NOT NULL DEFAULT "",
+	Created  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')), -- unix seconds
+	Clicks   INTEGER
+);
+
+CREATE INDEX TrashStatsByID ON TrashStats (ID);
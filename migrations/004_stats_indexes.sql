@@ -0,0 +1,4 @@
+-- Indexes for loading and compacting click stats.
+
+CREATE INDEX StatsByID ON Stats (ID, Created);
+CREATE INDEX StatsByCreated ON Stats (Created);
@@ -0,0 +1,27 @@
+-- LinkSearch is a full-text index of link names and destinations,
+-- kept in sync with Links by triggers.
+--
+-- Links are saved with INSERT OR REPLACE, which does not fire delete
+-- triggers for the replaced row, so stale index entries are removed
+-- before each insert instead.
+
+CREATE VIRTUAL TABLE LinkSearch USING fts5(ID, Short, Long);
+
+INSERT INTO LinkSearch (ID, Short, Long) SELECT ID, Short, Long FROM Links;
+
+CREATE TRIGGER LinkSearchBeforeInsert BEFORE INSERT ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = NEW.ID;
+END;
+
+CREATE TRIGGER LinkSearchAfterInsert AFTER INSERT ON Links BEGIN
+	INSERT INTO LinkSearch (ID, Short, Long) VALUES (NEW.ID, NEW.Short, NEW.Long);
+END;
+
+CREATE TRIGGER LinkSearchAfterUpdate AFTER UPDATE ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = OLD.ID;
+	INSERT INTO LinkSearch (ID, Short, Long) VALUES (NEW.ID, NEW.Short, NEW.Long);
+END;
+
+CREATE TRIGGER LinkSearchAfterDelete AFTER DELETE ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = OLD.ID;
+END;
@@ -0,0 +1,31 @@
+-- Add a free-text description to links, and include it in the search index.
+
+ALTER TABLE Links ADD COLUMN Description TEXT NOT NULL DEFAULT '';
+ALTER TABLE Trash ADD COLUMN Description TEXT NOT NULL DEFAULT '';
+
+DROP TRIGGER LinkSearchBeforeInsert;
+DROP TRIGGER LinkSearchAfterInsert;
+DROP TRIGGER LinkSearchAfterUpdate;
+DROP TRIGGER LinkSearchAfterDelete;
+DROP TABLE LinkSearch;
+
+CREATE VIRTUAL TABLE LinkSearch USING fts5(ID, Short, Long, Description);
+
+INSERT INTO LinkSearch (ID, Short, Long, Description) SELECT ID, Short, Long, Description FROM Links;
+
+CREATE TRIGGER LinkSearchBeforeInsert BEFORE INSERT ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = NEW.ID;
+END;
+
+CREATE TRIGGER LinkSearchAfterInsert AFTER INSERT ON Links BEGIN
+	INSERT INTO LinkSearch (ID, Short, Long, Description) VALUES (NEW.ID, NEW.Short, NEW.Long, NEW.Description);
+END;
+
+CREATE TRIGGER LinkSearchAfterUpdate AFTER UPDATE ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = OLD.ID;
+	INSERT INTO LinkSearch (ID, Short, Long, Description) VALUES (NEW.ID, NEW.Short, NEW.Long, NEW.Description);
+END;
+
+CREATE TRIGGER LinkSearchAfterDelete AFTER DELETE ON Links BEGIN
+	DELETE FROM LinkSearch WHERE ID = OLD.ID;
+END;
@@ -0,0 +1,16 @@
+-- LinkTags holds the tags of each link, and TrashTags the tags of links
+-- in the trash.
+
+CREATE TABLE LinkTags (
+	ID  TEXT NOT NULL, -- normalized version of Short
+	Tag TEXT NOT NULL, -- lowercase
+	PRIMARY KEY (ID, Tag)
+);
+
+CREATE INDEX LinkTagsByTag ON LinkTags (Tag);
+
+CREATE TABLE TrashTags (
+	ID  TEXT NOT NULL,
+	Tag TEXT NOT NULL,
+	PRIMARY KEY (ID, Tag)
+);
@@ -0,0 +1,18 @@
+-- Aliases maps additional short names onto a link, and TrashAliases holds
+-- the aliases of links in the trash.
+
+CREATE TABLE Aliases (
+	ID     TEXT PRIMARY KEY, -- normalized version of Alias
+	Alias  TEXT NOT NULL,
+	LinkID TEXT NOT NULL     -- ID of the link the alias resolves to
+);
+
+CREATE INDEX AliasesByLinkID ON Aliases (LinkID);
+
+CREATE TABLE TrashAliases (
+	ID     TEXT NOT NULL,
+	Alias  TEXT NOT NULL,
+	LinkID TEXT NOT NULL
+);
+
+CREATE INDEX TrashAliasesByLinkID ON TrashAliases (LinkID);
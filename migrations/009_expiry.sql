@@ -0,0 +1,11 @@
+-- Add optional expiration times to links.
+--
+-- ExpiresAt is in unix seconds, with 0 meaning the link never expires.
+-- Expired is set once a link has passed its expiration time.
+
+ALTER TABLE Links ADD COLUMN ExpiresAt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE Links ADD COLUMN Expired INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE Trash ADD COLUMN ExpiresAt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE Trash ADD COLUMN Expired INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX LinksByExpiresAt ON Links (ExpiresAt) WHERE ExpiresAt > 0;
@@ -0,0 +1,15 @@
+-- CoOwners holds the additional owners of each link, and TrashCoOwners the
+-- co-owners of links in the trash. An owner is either a user login or a
+-- group name of the form "group:name".
+
+CREATE TABLE CoOwners (
+	ID    TEXT NOT NULL, -- normalized version of Short
+	Owner TEXT NOT NULL, -- user@domain or group:name
+	PRIMARY KEY (ID, Owner)
+);
+
+CREATE TABLE TrashCoOwners (
+	ID    TEXT NOT NULL,
+	Owner TEXT NOT NULL,
+	PRIMARY KEY (ID, Owner)
+);
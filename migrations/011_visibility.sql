@@ -0,0 +1,6 @@
+-- Visibility restricts who can see and resolve a link: '' for public links,
+-- 'owner' for links only visible to their owners, or 'access:name' for links
+-- visible to users granted access to name by the golink peer capability.
+
+ALTER TABLE Links ADD COLUMN Visibility TEXT NOT NULL DEFAULT '';
+ALTER TABLE Trash ADD COLUMN Visibility TEXT NOT NULL DEFAULT '';
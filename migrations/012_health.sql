@@ -0,0 +1,16 @@
+-- LinkHealth holds the result of the most recent check of each link's
+-- destination by the health checker.
+--
+-- Long is the destination that was checked, so that results for a link
+-- that has since been edited can be ignored. Status is 0 if no response was
+-- received, in which case Error says why.
+
+CREATE TABLE LinkHealth (
+	ID      TEXT    PRIMARY KEY,          -- normalized version of Short
+	Long    TEXT    NOT NULL DEFAULT '',
+	URL     TEXT    NOT NULL DEFAULT '',  -- the URL that was requested
+	Status  INTEGER NOT NULL DEFAULT 0,   -- HTTP status code
+	Error   TEXT    NOT NULL DEFAULT '',
+	Latency INTEGER NOT NULL DEFAULT 0,   -- milliseconds
+	Checked INTEGER NOT NULL              -- unix seconds
+);
@@ -0,0 +1,108 @@
+-- Initial PostgreSQL schema, equivalent to SQLite schema version 11.
+--
+-- Times are stored as unix seconds, as in SQLite, and IDs are the
+-- normalized link names returned by linkID.
+
+CREATE TABLE Links (
+	ID          TEXT    PRIMARY KEY,          -- normalized version of Short (foobar)
+	Short       TEXT    NOT NULL DEFAULT '',  -- user-provided Short name (Foo-Bar)
+	Long        TEXT    NOT NULL DEFAULT '',
+	Created     BIGINT  NOT NULL,             -- unix seconds
+	LastEdit    BIGINT  NOT NULL,             -- unix seconds
+	Owner       TEXT    NOT NULL DEFAULT '',
+	Description TEXT    NOT NULL DEFAULT '',
+	ExpiresAt   BIGINT  NOT NULL DEFAULT 0,   -- unix seconds, or 0 if the link never expires
+	Expired     BOOLEAN NOT NULL DEFAULT FALSE,
+	Visibility  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX LinksByOwner ON Links (LOWER(Owner));
+CREATE INDEX LinksByExpiresAt ON Links (ExpiresAt) WHERE ExpiresAt > 0;
+
+CREATE TABLE Stats (
+	ID      TEXT    NOT NULL,
+	Created BIGINT  NOT NULL, -- unix seconds
+	Clicks  INTEGER NOT NULL
+);
+
+CREATE INDEX StatsByID ON Stats (ID, Created);
+CREATE INDEX StatsByCreated ON Stats (Created);
+
+CREATE TABLE History (
+	RevID   BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	ID      TEXT   NOT NULL,             -- normalized link ID
+	Short   TEXT   NOT NULL DEFAULT '',  -- short name at the time of the edit
+	Long    TEXT   NOT NULL DEFAULT '',  -- destination before the edit
+	Owner   TEXT   NOT NULL DEFAULT '',  -- owner before the edit
+	Editor  TEXT   NOT NULL DEFAULT '',  -- user who made the edit
+	Created BIGINT NOT NULL              -- unix seconds
+);
+
+CREATE INDEX HistoryByID ON History (ID);
+
+CREATE TABLE LinkTags (
+	ID  TEXT NOT NULL,
+	Tag TEXT NOT NULL, -- lowercase
+	PRIMARY KEY (ID, Tag)
+);
+
+CREATE INDEX LinkTagsByTag ON LinkTags (Tag);
+
+CREATE TABLE Aliases (
+	ID     TEXT PRIMARY KEY, -- normalized version of Alias
+	Alias  TEXT NOT NULL,
+	LinkID TEXT NOT NULL     -- ID of the link the alias resolves to
+);
+
+CREATE INDEX AliasesByLinkID ON Aliases (LinkID);
+
+CREATE TABLE CoOwners (
+	ID    TEXT NOT NULL,
+	Owner TEXT NOT NULL, -- user@domain or group:name
+	PRIMARY KEY (ID, Owner)
+);
+
+CREATE TABLE Trash (
+	ID          TEXT    PRIMARY KEY,
+	Short       TEXT    NOT NULL DEFAULT '',
+	Long        TEXT    NOT NULL DEFAULT '',
+	Created     BIGINT  NOT NULL,
+	LastEdit    BIGINT  NOT NULL,
+	Owner       TEXT    NOT NULL DEFAULT '',
+	Description TEXT    NOT NULL DEFAULT '',
+	ExpiresAt   BIGINT  NOT NULL DEFAULT 0,
+	Expired     BOOLEAN NOT NULL DEFAULT FALSE,
+	Visibility  TEXT    NOT NULL DEFAULT '',
+	DeletedBy   TEXT    NOT NULL DEFAULT '', -- user who deleted the link
+	Deleted     BIGINT  NOT NULL             -- unix seconds
+);
+
+CREATE INDEX TrashByDeleted ON Trash (Deleted);
+
+CREATE TABLE TrashStats (
+	ID      TEXT    NOT NULL,
+	Created BIGINT  NOT NULL,
+	Clicks  INTEGER NOT NULL
+);
+
+CREATE INDEX TrashStatsByID ON TrashStats (ID);
+
+CREATE TABLE TrashTags (
+	ID  TEXT NOT NULL,
+	Tag TEXT NOT NULL,
+	PRIMARY KEY (ID, Tag)
+);
+
+CREATE TABLE TrashAliases (
+	ID     TEXT NOT NULL,
+	Alias  TEXT NOT NULL,
+	LinkID TEXT NOT NULL
+);
+
+CREATE INDEX TrashAliasesByLinkID ON TrashAliases (LinkID);
+
+CREATE TABLE TrashCoOwners (
+	ID    TEXT NOT NULL,
+	Owner TEXT NOT NULL,
+	PRIMARY KEY (ID, Owner)
+);
@@ -0,0 +1,12 @@
+-- LinkHealth holds the result of the most recent check of each link's
+-- destination by the health checker, as in SQLite schema version 12.
+
+CREATE TABLE LinkHealth (
+	ID      TEXT    PRIMARY KEY,          -- normalized link ID
+	Long    TEXT    NOT NULL DEFAULT '',  -- destination that was checked
+	URL     TEXT    NOT NULL DEFAULT '',  -- the URL that was requested
+	Status  INTEGER NOT NULL DEFAULT 0,   -- HTTP status code, or 0 if there was no response
+	Error   TEXT    NOT NULL DEFAULT '',  -- why there was no response
+	Latency BIGINT  NOT NULL DEFAULT 0,   -- milliseconds
+	Checked BIGINT  NOT NULL              -- unix seconds
+);
@@ -0,0 +1,933 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"tailscale.com/tstime"
+)
+
+// PostgresDB stores Links in a PostgreSQL database. Unlike SQLiteDB, the
+// database can be shared by several golink servers, so PostgresDB relies on
+// transactions rather than an in-process lock for consistency.
+type PostgresDB struct {
+	db *sql.DB
+
+	clock tstime.Clock // allow overriding time for tests
+}
+
+var _ LinkStore = (*PostgresDB)(nil)
+
+// NewPostgresDB returns a new PostgresDB that stores links in the PostgreSQL
+// database identified by dsn, which is either a URL or a list of key=value
+// connection settings.
+// The database schema is migrated to the latest version if needed.
+func NewPostgresDB(dsn string) (*PostgresDB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migratePostgres(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresDB{db: db}, nil
+}
+
+// Close closes the connections to the database.
+func (p *PostgresDB) Close() error {
+	return p.db.Close()
+}
+
+// Now returns the current time.
+func (p *PostgresDB) Now() time.Time {
+	return tstime.DefaultClock{Clock: p.clock}.Now()
+}
+
+// queryLinks runs query, which selects linkColumns from Links, and returns
+// the resulting links along with their details.
+func (p *PostgresDB) queryLinks(query string, args ...any) ([]*Link, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, p.attachDetails(p.db, links, linkDetails)
+}
+
+// attachDetails sets the Tags, Aliases, and CoOwners of each link from the
+// specified tables, loading only the details of those links.
+func (p *PostgresDB) attachDetails(q queryer, links []*Link, tables detailTables) error {
+	if len(links) == 0 {
+		return nil
+	}
+	ids := make([]string, len(links))
+	for i, link := range links {
+		ids[i] = linkID(link.Short)
+	}
+	tags, err := loadDetails(q, "SELECT ID, Tag FROM "+tables.tags+" WHERE ID = ANY($1) ORDER BY ID, Tag", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	coOwners, err := loadDetails(q, "SELECT ID, Owner FROM "+tables.coOwners+" WHERE ID = ANY($1) ORDER BY ID, Owner", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	aliases, err := loadDetails(q, "SELECT LinkID, Alias FROM "+tables.aliases+" WHERE LinkID = ANY($1) ORDER BY LinkID, ID", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for i, link := range links {
+		link.Tags = tags[ids[i]]
+		link.Aliases = aliases[ids[i]]
+		link.CoOwners = coOwners[ids[i]]
+	}
+	return nil
+}
+
+// LoadAll returns all stored Links.
+//
+// The caller owns the returned values.
+func (p *PostgresDB) LoadAll() ([]*Link, error) {
+	return p.queryLinks("SELECT " + linkColumns + " FROM Links")
+}
+
+// Load returns a Link by its short name.
+//
+// It returns fs.ErrNotExist if the link does not exist.
+//
+// The caller owns the returned value.
+func (p *PostgresDB) Load(short string) (*Link, error) {
+	links, err := p.queryLinks("SELECT "+linkColumns+" FROM Links WHERE ID = $1", linkID(short))
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return links[0], nil
+}
+
+// upsertLink is the statement used to save a link, replacing any existing
+// link with the same ID.
+var upsertLink = func() string {
+	cols := strings.Split(linkColumns, ", ")
+	params := make([]string, len(cols)+1)
+	set := make([]string, len(cols))
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	for i, col := range cols {
+		set[i] = col + " = EXCLUDED." + col
+	}
+	return "INSERT INTO Links (ID, " + linkColumns + ") VALUES (" + strings.Join(params, ", ") + ") ON CONFLICT (ID) DO UPDATE SET " + strings.Join(set, ", ")
+}()
+
+// Save saves a Link.
+func (p *PostgresDB) Save(link *Link) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := savePostgresLink(tx, link); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveWithRevision saves a Link and records rev, the version of the link
+// that it replaces, in a single transaction.
+func (p *PostgresDB) SaveWithRevision(link *Link, rev *Revision) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := savePostgresLink(tx, link); err != nil {
+		return err
+	}
+	if err := savePostgresRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savePostgresLink saves a Link and its details in tx.
+func savePostgresLink(tx *sql.Tx, link *Link) error {
+	id := linkID(link.Short)
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM Aliases WHERE ID = $1 AND LinkID != $1", id).Scan(&n); err != nil {
+		return err
+	} else if n > 0 {
+		return fmt.Errorf("%q is an alias: %w", link.Short, ErrNameInUse)
+	}
+	if _, err := tx.Exec(upsertLink, id, link.Short, link.Long, link.Created.Unix(), link.LastEdit.Unix(), link.Owner, link.Description, expiresUnix(link.ExpiresAt), link.Expired, link.Visibility); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM LinkTags WHERE ID = $1", id); err != nil {
+		return err
+	}
+	for _, tag := range cleanTags(link.Tags) {
+		if _, err := tx.Exec("INSERT INTO LinkTags (ID, Tag) VALUES ($1, $2)", id, tag); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM CoOwners WHERE ID = $1", id); err != nil {
+		return err
+	}
+	for _, owner := range cleanCoOwners(link.Owner, link.CoOwners) {
+		if _, err := tx.Exec("INSERT INTO CoOwners (ID, Owner) VALUES ($1, $2)", id, owner); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE LinkID = $1", id); err != nil {
+		return err
+	}
+	for _, alias := range cleanAliases(link.Short, link.Aliases) {
+		aid := linkID(alias)
+		if err := tx.QueryRow("SELECT (SELECT count(*) FROM Links WHERE ID = $1) + (SELECT count(*) FROM Aliases WHERE ID = $1)", aid).Scan(&n); err != nil {
+			return err
+		} else if n > 0 {
+			return fmt.Errorf("alias %q: %w", alias, ErrNameInUse)
+		}
+		if _, err := tx.Exec("INSERT INTO Aliases (ID, Alias, LinkID) VALUES ($1, $2, $3)", aid, alias, id); isPostgresUniqueViolation(err) {
+			// another server saved the same alias concurrently
+			return fmt.Errorf("alias %q: %w", alias, ErrNameInUse)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a Link using its short name.
+func (p *PostgresDB) Delete(short string) error {
+	id := linkID(short)
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM Links WHERE ID = $1", id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("expected to affect 1 row, affected %d", rows)
+	}
+	for _, query := range []string{
+		"DELETE FROM LinkTags WHERE ID = $1",
+		"DELETE FROM Aliases WHERE LinkID = $1",
+		"DELETE FROM CoOwners WHERE ID = $1",
+		"DELETE FROM LinkHealth WHERE ID = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadByAlias returns the Link that alias resolves to.
+//
+// It returns fs.ErrNotExist if there is no such alias.
+//
+// The caller owns the returned value.
+func (p *PostgresDB) LoadByAlias(alias string) (*Link, error) {
+	links, err := p.queryLinks("SELECT "+linkColumns+" FROM Links WHERE ID = (SELECT LinkID FROM Aliases WHERE ID = $1)", linkID(alias))
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return links[0], nil
+}
+
+// GetLinksByOwner returns all Links owned by the specified owner.
+func (p *PostgresDB) GetLinksByOwner(owner string) ([]*Link, error) {
+	return p.queryLinks("SELECT "+linkColumns+" FROM Links WHERE LOWER(Owner) = LOWER($1)", owner)
+}
+
+// GetLinksByTag returns all Links with the specified tag.
+func (p *PostgresDB) GetLinksByTag(tag string) ([]*Link, error) {
+	return p.queryLinks("SELECT "+linkColumns+" FROM Links WHERE ID IN (SELECT ID FROM LinkTags WHERE Tag = $1)", strings.ToLower(tag))
+}
+
+// LoadTags returns the number of links with each tag.
+func (p *PostgresDB) LoadTags() (map[string]int, error) {
+	rows, err := p.db.Query("SELECT Tag, count(*) FROM LinkTags GROUP BY Tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		tags[tag] = n
+	}
+	return tags, rows.Err()
+}
+
+// ExpireLinks marks links whose ExpiresAt is at or before now as expired,
+// returning the number of newly expired links.
+func (p *PostgresDB) ExpireLinks(now time.Time) (int, error) {
+	result, err := p.db.Exec("UPDATE Links SET Expired = TRUE WHERE ExpiresAt > 0 AND ExpiresAt <= $1 AND NOT Expired", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// GetExpiredLinks returns all Links that have been marked expired.
+func (p *PostgresDB) GetExpiredLinks() ([]*Link, error) {
+	return p.queryLinks("SELECT " + linkColumns + " FROM Links WHERE Expired")
+}
+
+// SearchLinks returns the Links matching all of the specified terms,
+// most relevant first.
+//
+// Like SQLiteDB, terms match the start of words. Matches in the link name
+// score highest, then the description, then the destination.
+//
+// The caller owns the returned values.
+func (p *PostgresDB) SearchLinks(terms []string) ([]LinkMatch, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var scores, conds []string
+	args := make([]any, len(terms))
+	for i, t := range terms {
+		// \m matches the start of a word
+		args[i] = `\m` + regexp.QuoteMeta(t)
+		scores = append(scores, fmt.Sprintf("(CASE WHEN Short ~* $%[1]d OR ID ~* $%[1]d THEN 10 ELSE 0 END + CASE WHEN Description ~* $%[1]d THEN 2 ELSE 0 END + CASE WHEN Long ~* $%[1]d THEN 1 ELSE 0 END)", i+1))
+		conds = append(conds, fmt.Sprintf("(Short ~* $%[1]d OR ID ~* $%[1]d OR Description ~* $%[1]d OR Long ~* $%[1]d)", i+1))
+	}
+	rows, err := p.db.Query("SELECT "+linkColumns+", CAST("+strings.Join(scores, " + ")+" AS DOUBLE PRECISION) AS Score FROM Links WHERE "+strings.Join(conds, " AND ")+" ORDER BY Score DESC, Short", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []LinkMatch
+	for rows.Next() {
+		var score float64
+		link, err := scanLink(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, LinkMatch{Link: link, Score: score})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	links := make([]*Link, len(matches))
+	for i, m := range matches {
+		links[i] = m.Link
+	}
+	return matches, p.attachDetails(p.db, links, linkDetails)
+}
+
+// LoadStats returns click stats for links.
+func (p *PostgresDB) LoadStats() (ClickStats, error) {
+	rows, err := p.db.Query("SELECT COALESCE(l.Short, ''), sum(s.Clicks) FROM Stats s LEFT JOIN Links l ON l.ID = s.ID GROUP BY s.ID, l.Short")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(ClickStats)
+	for rows.Next() {
+		var short string
+		var clicks int
+		if err := rows.Scan(&short, &clicks); err != nil {
+			return nil, err
+		}
+		stats[short] = clicks
+	}
+	return stats, rows.Err()
+}
+
+// SaveStats records click stats for links.  The provided map includes
+// incremental clicks that have occurred since the last time SaveStats
+// was called.
+func (p *PostgresDB) SaveStats(stats ClickStats) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := p.Now().Unix()
+	for short, clicks := range stats {
+		if _, err := tx.Exec("INSERT INTO Stats (ID, Created, Clicks) VALUES ($1, $2, $3)", linkID(short), now, clicks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveStatsRecords stores click stats entries as they are.
+func (p *PostgresDB) SaveStatsRecords(records []StatsRecord) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if _, err := tx.Exec("INSERT INTO Stats (ID, Created, Clicks) VALUES ($1, $2, $3)", r.ID, r.Created.Unix(), r.Clicks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadStatsRecords returns all stored click stats entries, ordered by
+// creation time and then link ID.
+func (p *PostgresDB) LoadStatsRecords() ([]StatsRecord, error) {
+	rows, err := p.db.Query("SELECT ID, Created, Clicks FROM Stats ORDER BY Created, ID")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []StatsRecord
+	for rows.Next() {
+		var r StatsRecord
+		var created int64
+		if err := rows.Scan(&r.ID, &created, &r.Clicks); err != nil {
+			return nil, err
+		}
+		r.Created = time.Unix(created, 0).UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// DeleteStats deletes click stats for a link.
+func (p *PostgresDB) DeleteStats(short string) error {
+	_, err := p.db.Exec("DELETE FROM Stats WHERE ID = $1", linkID(short))
+	return err
+}
+
+// CompactStats rolls up click stats entries into hourly and daily buckets.
+func (p *PostgresDB) CompactStats(hourlyBefore, dailyBefore time.Time) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize with other servers compacting the same database
+	if _, err := tx.Exec("LOCK TABLE Stats IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	if err := rollupPostgresStats(tx, hourlyBefore, time.Hour); err != nil {
+		return err
+	}
+	if err := rollupPostgresStats(tx, dailyBefore, 24*time.Hour); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollupPostgresStats combines the Stats entries created before the
+// specified time into one entry per link per bucket of the specified size,
+// like rollupStats does for SQLite.
+func rollupPostgresStats(tx *sql.Tx, before time.Time, bucket time.Duration) error {
+	size := int64(bucket / time.Second)
+	cutoff := statsBucket(before.Unix(), size) // never split a bucket
+
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{"CREATE TEMP TABLE StatsRollup (ID TEXT, Created BIGINT, Clicks BIGINT)", nil},
+		{`INSERT INTO StatsRollup (ID, Created, Clicks)
+			SELECT ID, Created - Created % $1, sum(Clicks) FROM Stats
+			WHERE Created < $2
+			GROUP BY 1, 2
+			HAVING count(*) > 1 OR min(Created) % $1 != 0`, []any{size, cutoff}},
+		{"DELETE FROM Stats WHERE Created < $2 AND (ID, Created - Created % $1) IN (SELECT ID, Created FROM StatsRollup)", []any{size, cutoff}},
+		{"INSERT INTO Stats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM StatsRollup", nil},
+		{"DROP TABLE StatsRollup", nil},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveRevision records a previous version of a link.
+func (p *PostgresDB) SaveRevision(rev *Revision) error {
+	return p.db.QueryRow(insertRevisionQuery, revisionLinkID(rev), rev.Short, rev.Long, rev.Owner, rev.Editor, rev.Created.Unix()).Scan(&rev.ID)
+}
+
+// insertRevisionQuery records a revision, returning its ID.
+const insertRevisionQuery = "INSERT INTO History (ID, Short, Long, Owner, Editor, Created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING RevID"
+
+// savePostgresRevision records a previous version of a link in tx.
+func savePostgresRevision(tx *sql.Tx, rev *Revision) error {
+	return tx.QueryRow(insertRevisionQuery, revisionLinkID(rev), rev.Short, rev.Long, rev.Owner, rev.Editor, rev.Created.Unix()).Scan(&rev.ID)
+}
+
+// LoadRevisions returns the recorded revisions of a link, newest first.
+func (p *PostgresDB) LoadRevisions(short string) ([]*Revision, error) {
+	id := linkID(short)
+	rows, err := p.db.Query("SELECT RevID, Short, Long, Owner, Editor, Created FROM History WHERE ID = $1 ORDER BY RevID DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRevisions(rows, id)
+}
+
+// TrashLink moves a Link and its click stats into the trash.
+// Any link with the same ID already in the trash is replaced.
+//
+// It returns fs.ErrNotExist if the link does not exist.
+func (p *PostgresDB) TrashLink(short, deletedBy string, deleted time.Time) error {
+	id := linkID(short)
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM Trash WHERE ID = $1", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO Trash (ID, "+linkColumns+", DeletedBy, Deleted) SELECT ID, "+linkColumns+", $1, $2 FROM Links WHERE ID = $3", deletedBy, deleted.Unix(), id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fs.ErrNotExist
+	}
+	for _, query := range []string{
+		"INSERT INTO TrashStats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM Stats WHERE ID = $1",
+		"DELETE FROM Stats WHERE ID = $1",
+		"DELETE FROM TrashTags WHERE ID = $1",
+		"INSERT INTO TrashTags (ID, Tag) SELECT ID, Tag FROM LinkTags WHERE ID = $1",
+		"DELETE FROM LinkTags WHERE ID = $1",
+		"DELETE FROM TrashAliases WHERE LinkID = $1",
+		"INSERT INTO TrashAliases (ID, Alias, LinkID) SELECT ID, Alias, LinkID FROM Aliases WHERE LinkID = $1",
+		"DELETE FROM Aliases WHERE LinkID = $1",
+		"DELETE FROM TrashCoOwners WHERE ID = $1",
+		"INSERT INTO TrashCoOwners (ID, Owner) SELECT ID, Owner FROM CoOwners WHERE ID = $1",
+		"DELETE FROM CoOwners WHERE ID = $1",
+		"DELETE FROM LinkHealth WHERE ID = $1",
+		"DELETE FROM Links WHERE ID = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadTrash returns all links in the trash.
+//
+// The caller owns the returned values.
+func (p *PostgresDB) LoadTrash() ([]*TrashedLink, error) {
+	rows, err := p.db.Query("SELECT " + linkColumns + ", DeletedBy, Deleted FROM Trash")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*TrashedLink
+	for rows.Next() {
+		link := new(TrashedLink)
+		var deleted int64
+		l, err := scanLink(rows, &link.DeletedBy, &deleted)
+		if err != nil {
+			return nil, err
+		}
+		link.Link = *l
+		link.Deleted = time.Unix(deleted, 0).UTC()
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	trashed := make([]*Link, len(links))
+	for i, link := range links {
+		trashed[i] = &link.Link
+	}
+	if err := p.attachDetails(p.db, trashed, trashDetails); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// RestoreLink moves a Link and its click stats out of the trash.
+func (p *PostgresDB) RestoreLink(short string) error {
+	id := linkID(short)
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT (SELECT count(*) FROM Links WHERE ID = $1) + (SELECT count(*) FROM Aliases WHERE ID = $1)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fs.ErrExist
+	}
+	result, err := tx.Exec("INSERT INTO Links (ID, "+linkColumns+") SELECT ID, "+linkColumns+" FROM Trash WHERE ID = $1", id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fs.ErrNotExist
+	}
+	for _, query := range []string{
+		"INSERT INTO Stats (ID, Created, Clicks) SELECT ID, Created, Clicks FROM TrashStats WHERE ID = $1",
+		"DELETE FROM TrashStats WHERE ID = $1",
+		"INSERT INTO LinkTags (ID, Tag) SELECT ID, Tag FROM TrashTags WHERE ID = $1",
+		"DELETE FROM TrashTags WHERE ID = $1",
+		// aliases that have since been taken by other links are not restored
+		"INSERT INTO Aliases (ID, Alias, LinkID) SELECT ID, Alias, LinkID FROM TrashAliases WHERE LinkID = $1 AND ID NOT IN (SELECT ID FROM Links) ON CONFLICT DO NOTHING",
+		"DELETE FROM TrashAliases WHERE LinkID = $1",
+		"INSERT INTO CoOwners (ID, Owner) SELECT ID, Owner FROM TrashCoOwners WHERE ID = $1",
+		"DELETE FROM TrashCoOwners WHERE ID = $1",
+		"DELETE FROM Trash WHERE ID = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash permanently removes links deleted before the specified time
+// from the trash, returning the number of links removed.
+func (p *PostgresDB) PurgeTrash(before time.Time) (int, error) {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM TrashStats WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < $1)",
+		"DELETE FROM TrashTags WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < $1)",
+		"DELETE FROM TrashAliases WHERE LinkID IN (SELECT ID FROM Trash WHERE Deleted < $1)",
+		"DELETE FROM TrashCoOwners WHERE ID IN (SELECT ID FROM Trash WHERE Deleted < $1)",
+	} {
+		if _, err := tx.Exec(query, before.Unix()); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM Trash WHERE Deleted < $1", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), tx.Commit()
+}
+
+// RenameLink renames a Link, moving its click stats and revisions to the
+// new name.
+func (p *PostgresDB) RenameLink(short, newShort string, keepAlias bool) error {
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, newID := linkID(short), linkID(newShort)
+	var oldShort string
+	err = tx.QueryRow("SELECT Short FROM Links WHERE ID = $1 FOR UPDATE", id).Scan(&oldShort)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fs.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	if newID == id {
+		// only the spelling of the name changed
+		if _, err := tx.Exec("UPDATE Links SET Short = $1 WHERE ID = $2", newShort, id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	var n int
+	if err := tx.QueryRow("SELECT (SELECT count(*) FROM Links WHERE ID = $1) + (SELECT count(*) FROM Aliases WHERE ID = $1 AND LinkID != $2)", newID, id).Scan(&n); err != nil {
+		return err
+	} else if n > 0 {
+		return fmt.Errorf("%q: %w", newShort, ErrNameInUse)
+	}
+	// the new name may have been an alias of the link itself
+	if _, err := tx.Exec("DELETE FROM Aliases WHERE ID = $1", newID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Links SET ID = $1, Short = $2 WHERE ID = $3", newID, newShort, id); isPostgresUniqueViolation(err) {
+		// another server saved a link with the new name concurrently
+		return fmt.Errorf("%q: %w", newShort, ErrNameInUse)
+	} else if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"UPDATE LinkTags SET ID = $1 WHERE ID = $2",
+		"UPDATE CoOwners SET ID = $1 WHERE ID = $2",
+		"UPDATE Aliases SET LinkID = $1 WHERE LinkID = $2",
+		"UPDATE Stats SET ID = $1 WHERE ID = $2",
+		"UPDATE History SET ID = $1 WHERE ID = $2",
+		"UPDATE LinkHealth SET ID = $1 WHERE ID = $2",
+	} {
+		if _, err := tx.Exec(query, newID, id); err != nil {
+			return err
+		}
+	}
+	if keepAlias {
+		if _, err := tx.Exec("INSERT INTO Aliases (ID, Alias, LinkID) VALUES ($1, $2, $3)", id, oldShort, newID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveLinkHealth records the result of checking the destination of a link.
+func (p *PostgresDB) SaveLinkHealth(h *LinkHealth) error {
+	_, err := p.db.Exec(`INSERT INTO LinkHealth (ID, Long, URL, Status, Error, Latency, Checked)
+		SELECT $1, $2, $3, $4::INTEGER, $5, $6::BIGINT, $7::BIGINT WHERE EXISTS (SELECT 1 FROM Links WHERE ID = $1)
+		ON CONFLICT (ID) DO UPDATE SET Long = EXCLUDED.Long, URL = EXCLUDED.URL, Status = EXCLUDED.Status,
+			Error = EXCLUDED.Error, Latency = EXCLUDED.Latency, Checked = EXCLUDED.Checked`,
+		h.ID, h.Long, h.URL, h.Status, h.Error, h.Latency.Milliseconds(), h.Checked.Unix())
+	return err
+}
+
+// LoadLinkHealth returns the most recent health check result of each link.
+func (p *PostgresDB) LoadLinkHealth() (map[string]*LinkHealth, error) {
+	rows, err := p.db.Query("SELECT ID, Long, URL, Status, Error, Latency, Checked FROM LinkHealth")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLinkHealth(rows)
+}
+
+// ImportLinks saves links in a single transaction, merging them with
+// existing links of the same name as specified by mode.
+func (p *PostgresDB) ImportLinks(links []*Link, mode ImportMode, editor string, dryRun bool) (*ImportReport, error) {
+	if _, err := ParseImportMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	tx, err := p.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	report := &ImportReport{DryRun: dryRun}
+	for _, link := range links {
+		prev := &Revision{Editor: editor, Created: now}
+		var lastEdit int64
+		err := tx.QueryRow("SELECT Short, Long, Owner, LastEdit FROM Links WHERE ID = $1 FOR UPDATE", linkID(link.Short)).Scan(&prev.Short, &prev.Long, &prev.Owner, &lastEdit)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		exists := err == nil
+		if !report.importLink(mode, link, exists, time.Unix(lastEdit, 0)) {
+			continue
+		}
+		if err := savePostgresLink(tx, link); err != nil {
+			return nil, fmt.Errorf("importing %q: %w", link.Short, err)
+		}
+		if exists {
+			if err := savePostgresRevision(tx, prev); err != nil {
+				return nil, fmt.Errorf("importing %q: %w", link.Short, err)
+			}
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// copiedTables are the tables copied by CopySQLiteToPostgres, along with
+// their columns.
+var copiedTables = []struct {
+	name    string
+	columns string
+}{
+	{"Links", "ID, " + linkColumns},
+	{"Stats", "ID, Created, Clicks"},
+	{"History", "RevID, ID, Short, Long, Owner, Editor, Created"},
+	{"LinkTags", "ID, Tag"},
+	{"Aliases", "ID, Alias, LinkID"},
+	{"CoOwners", "ID, Owner"},
+	{"Trash", "ID, " + linkColumns + ", DeletedBy, Deleted"},
+	{"TrashStats", "ID, Created, Clicks"},
+	{"TrashTags", "ID, Tag"},
+	{"TrashAliases", "ID, Alias, LinkID"},
+	{"TrashCoOwners", "ID, Owner"},
+	{"LinkHealth", "ID, Long, URL, Status, Error, Latency, Checked"},
+}
+
+// CopySQLiteToPostgres copies all links, stats, history, trash, and link
+// health from src into dst in a single transaction, returning the number of
+// links copied. The destination database must not contain any links, so
+// that an interrupted or repeated copy never merges two databases.
+func CopySQLiteToPostgres(ctx context.Context, src *SQLiteDB, dst *PostgresDB) (int, error) {
+	// read every table from the same snapshot of src
+	stx, err := src.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer stx.Rollback()
+
+	tx, err := dst.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM Links").Scan(&existing); err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, fmt.Errorf("destination database already has %d links", existing)
+	}
+
+	var links int
+	for _, table := range copiedTables {
+		n, err := copyTable(ctx, stx, tx, table.name, table.columns)
+		if err != nil {
+			return 0, fmt.Errorf("copying %s: %w", table.name, err)
+		}
+		if table.name == "Links" {
+			links = n
+		}
+	}
+	// continue numbering revisions after the copied ones
+	if _, err := tx.ExecContext(ctx, "SELECT setval(pg_get_serial_sequence('History', 'revid'), COALESCE(max(RevID), 0) + 1, false) FROM History"); err != nil {
+		return 0, err
+	}
+	return links, tx.Commit()
+}
+
+// copyTable copies the specified columns of all rows in table from the
+// SQLite database src to the same table in tx, returning the number of
+// rows copied.
+func copyTable(ctx context.Context, src *sql.Tx, tx *sql.Tx, table, columns string) (int, error) {
+	cols := strings.Split(columns, ", ")
+	rows, err := src.QueryContext(ctx, "SELECT "+columns+" FROM "+table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(strings.ToLower(table), lowerAll(cols)...))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		for i, col := range cols {
+			if col == "Expired" {
+				// SQLite stores booleans as integers
+				vals[i] = vals[i] != int64(0)
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, vals...); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// flush the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// lowerAll returns ss with each string lowercased, which is how PostgreSQL
+// stores unquoted identifiers.
+func lowerAll(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = strings.ToLower(s)
+	}
+	return out
+}
+
+// isPostgresUniqueViolation reports whether err is a PostgreSQL
+// unique_violation error.
+func isPostgresUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
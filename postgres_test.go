@@ -0,0 +1,236 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"tailscale.com/tstest"
+)
+
+// testPostgres returns the connection string of a PostgreSQL server to test
+// against. If $GOLINK_TEST_POSTGRES_DSN is set, that server is used.
+// Otherwise a temporary server is started if initdb and pg_ctl are in $PATH,
+// and stopped when the test finishes. The test is skipped if neither is
+// available.
+func testPostgres(t *testing.T) string {
+	t.Helper()
+	if dsn := os.Getenv("GOLINK_TEST_POSTGRES_DSN"); dsn != "" {
+		return dsn
+	}
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("PostgreSQL not available: set GOLINK_TEST_POSTGRES_DSN or add initdb to $PATH")
+	}
+	pgctl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		t.Skip("PostgreSQL not available: set GOLINK_TEST_POSTGRES_DSN or add pg_ctl to $PATH")
+	}
+
+	// Unix socket paths are limited in length, so avoid t.TempDir.
+	dir, err := os.MkdirTemp("", "golink-pg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data := path.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+		// initdb refuses to run as root, as in some containers
+		t.Skipf("initdb: %v\n%s", err, out)
+	}
+
+	// pick a free port for the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)
+	if out, err := exec.Command(pgctl, "-D", data, "-o", opts, "-l", path.Join(dir, "log"), "-w", "start").CombinedOutput(); err != nil {
+		log, _ := os.ReadFile(path.Join(dir, "log"))
+		t.Fatalf("pg_ctl start: %v\n%s\n%s", err, out, log)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgctl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+	})
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+}
+
+// newPostgresSchema creates an empty schema in the server at dsn, dropped
+// when the test finishes, and returns a connection string that uses it.
+func newPostgresSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "golink_test_" + strings.ToLower(rand.Text())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping test schema: %v", err)
+		}
+	})
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// newTestPostgresDB returns a PostgresDB using a new, empty schema.
+func newTestPostgresDB(t *testing.T, dsn string) *PostgresDB {
+	t.Helper()
+	db, err := NewPostgresDB(newPostgresSchema(t, dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_PostgresDB(t *testing.T) {
+	dsn := testPostgres(t)
+	runStoreTests(t, func(t *testing.T, clock *tstest.Clock) LinkStore {
+		db := newTestPostgresDB(t, dsn)
+		db.clock = clock
+		return db
+	})
+}
+
+// Test that migrating an up-to-date database is a no-op, and that golink
+// refuses to open a database newer than it supports.
+func TestMigratePostgres(t *testing.T) {
+	dsn := newPostgresSchema(t, testPostgres(t))
+	for range 2 {
+		db, err := NewPostgresDB(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+
+	raw, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	var version int
+	if err := raw.QueryRow("SELECT Version FROM SchemaVersion").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != postgresSchemaVersion {
+		t.Errorf("schema version = %d; want %d", version, postgresSchemaVersion)
+	}
+
+	if _, err := raw.Exec("UPDATE SchemaVersion SET Version = $1", postgresSchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPostgresDB(dsn); err == nil || !strings.Contains(err.Error(), "newer than supported") {
+		t.Errorf("NewPostgresDB on newer database: err = %v; want schema version error", err)
+	}
+}
+
+func TestCopySQLiteToPostgres(t *testing.T) {
+	src, err := NewSQLiteDB(path.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	links := []*Link{
+		{Short: "Foo-Bar", Long: "http://foo/", Created: created, LastEdit: created, Owner: "foo@example.com", Description: "foo", Tags: []string{"oncall"}, Aliases: []string{"fb"}, CoOwners: []string{"group:infra"}, ExpiresAt: created, Expired: true, Visibility: "owner"},
+		{Short: "baz", Long: "http://baz/", Created: created, LastEdit: created, Owner: "bar@example.com"},
+		{Short: "gone", Long: "http://gone/", Created: created, LastEdit: created, Owner: "bar@example.com", Tags: []string{"old"}},
+	}
+	for _, link := range links {
+		if err := src.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.SaveStats(ClickStats{"foobar": 3, "baz": 1, "gone": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveRevision(&Revision{Short: "baz", Long: "http://old-baz/", Owner: "bar@example.com", Editor: "bar@example.com", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.TrashLink("gone", "bar@example.com", created); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveLinkHealth(&LinkHealth{ID: "baz", Long: "http://baz/", URL: "http://baz/", Status: 404, Latency: 25 * time.Millisecond, Checked: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestPostgresDB(t, testPostgres(t))
+	n, err := CopySQLiteToPostgres(context.Background(), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("CopySQLiteToPostgres copied %d links; want 2", n)
+	}
+
+	// both stores return the same data
+	sortLinks := cmpopts.SortSlices(func(a, b *Link) bool {
+		return a.Short < b.Short
+	})
+	checks := []struct {
+		name string
+		load func(LinkStore) (any, error)
+	}{
+		{"LoadAll", func(s LinkStore) (any, error) { return s.LoadAll() }},
+		{"LoadStatsRecords", func(s LinkStore) (any, error) { return s.LoadStatsRecords() }},
+		{"LoadRevisions", func(s LinkStore) (any, error) { return s.LoadRevisions("baz") }},
+		{"LoadTrash", func(s LinkStore) (any, error) { return s.LoadTrash() }},
+		{"LoadByAlias", func(s LinkStore) (any, error) { return s.LoadByAlias("FB") }},
+		{"LoadLinkHealth", func(s LinkStore) (any, error) { return s.LoadLinkHealth() }},
+	}
+	for _, c := range checks {
+		want, err := c.load(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.load(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got, sortLinks); diff != "" {
+			t.Errorf("%s diff (-sqlite +postgres):\n%s", c.name, diff)
+		}
+	}
+
+	// new revisions are numbered after the copied ones
+	rev := &Revision{Short: "baz", Created: created}
+	if err := dst.SaveRevision(rev); err != nil {
+		t.Fatal(err)
+	}
+	if rev.ID != 2 {
+		t.Errorf("new revision ID = %d; want 2", rev.ID)
+	}
+
+	if _, err := CopySQLiteToPostgres(context.Background(), src, dst); err == nil {
+		t.Error("copying into a database with links succeeded; want error")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by
+// writeSnapshot.
+//
+// A snapshot is a JSON lines file. Version 1 snapshots contain only Link
+// records. Since version 2, a snapshot starts with a header record and may
+// contain stats and revision records after the links. Records other than
+// links are objects with a Type field naming which of their other fields
+// is set, so that readers of version 1 snapshots see them as links with no
+// short name, which they ignore.
+const snapshotVersion = 2
+
+// Types of snapshot records that are not links.
+const (
+	snapshotHeaderType   = "header"
+	snapshotStatsType    = "stats"
+	snapshotRevisionType = "revision"
+)
+
+// snapshotHeader describes a snapshot.
+type snapshotHeader struct {
+	Version       int       // snapshot format version
+	SchemaVersion int       // SQLite schema version of the golink that wrote it
+	Exported      time.Time // when the snapshot was written
+}
+
+// snapshotRecord is a snapshot record that is not a link.
+type snapshotRecord struct {
+	Type     string
+	Header   *snapshotHeader `json:",omitempty"`
+	Stats    *StatsRecord    `json:",omitempty"`
+	Revision *Revision       `json:",omitempty"`
+}
+
+// snapshotOptions are the optional contents of a snapshot.
+type snapshotOptions struct {
+	// Clicks, if non-nil, holds the total clicks for each link, which are
+	// included in a Clicks field of the link records for reference. They
+	// are not restored; use Stats for that.
+	Clicks ClickStats
+
+	// Stats are click stats records of the links to include.
+	Stats []StatsRecord
+
+	// Revisions are revisions of the links to include, oldest first.
+	Revisions []*Revision
+}
+
+// writeSnapshot writes a snapshot of links, sorted by short name, to w,
+// followed by any stats and revisions in opts.
+func writeSnapshot(w io.Writer, links []*Link, opts snapshotOptions) error {
+	encoder := json.NewEncoder(w)
+	header := &snapshotHeader{
+		Version:       snapshotVersion,
+		SchemaVersion: schemaVersion,
+		Exported:      time.Now().UTC(),
+	}
+	if err := encoder.Encode(snapshotRecord{Type: snapshotHeaderType, Header: header}); err != nil {
+		return err
+	}
+	for _, link := range sortedLinks(links) {
+		var v any = link
+		if opts.Clicks != nil {
+			v = struct {
+				*Link
+				Clicks int
+			}{link, opts.Clicks[link.Short]}
+		}
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+	}
+	for _, rec := range opts.Stats {
+		if err := encoder.Encode(snapshotRecord{Type: snapshotStatsType, Stats: &rec}); err != nil {
+			return err
+		}
+	}
+	for _, rev := range opts.Revisions {
+		if err := encoder.Encode(snapshotRecord{Type: snapshotRevisionType, Revision: rev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnapshotOptions loads the click stats records and revisions of links
+// from db, if requested, for writeSnapshot.
+func loadSnapshotOptions(db LinkStore, links []*Link, withStats, withHistory bool) (snapshotOptions, error) {
+	var opts snapshotOptions
+	ids := make(map[string]bool, len(links))
+	for _, link := range links {
+		ids[linkID(link.Short)] = true
+	}
+	if withStats {
+		records, err := db.LoadStatsRecords()
+		if err != nil {
+			return opts, err
+		}
+		for _, rec := range records {
+			if ids[rec.ID] {
+				opts.Stats = append(opts.Stats, rec)
+			}
+		}
+	}
+	if withHistory {
+		for _, link := range links {
+			revs, err := db.LoadRevisions(link.Short)
+			if err != nil {
+				return opts, err
+			}
+			opts.Revisions = append(opts.Revisions, revs...)
+		}
+		sort.Slice(opts.Revisions, func(i, j int) bool {
+			return opts.Revisions[i].ID < opts.Revisions[j].ID
+		})
+	}
+	return opts, nil
+}
+
+// sortedLinks returns a copy of links sorted by short name.
+func sortedLinks(links []*Link) []*Link {
+	links = slices.Clone(links)
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Short < links[j].Short
+	})
+	return links
+}
+
+// snapshotData is the content of a snapshot read by readSnapshot.
+type snapshotData struct {
+	Header    *snapshotHeader // nil for version 1 snapshots
+	Links     []importRow     // each link, or why its line could not be read
+	Stats     []StatsRecord
+	Revisions []*Revision // in the order they were written
+}
+
+// readSnapshot reads a snapshot in any supported version of the format.
+// Blank lines are ignored. Links that cannot be parsed are reported in the
+// snapshot's Links; other malformed records fail the whole read.
+func readSnapshot(r io.Reader) (*snapshotData, error) {
+	snap := new(snapshotData)
+	bs := bufio.NewScanner(r)
+	for line := 1; bs.Scan(); line++ {
+		b := bs.Bytes()
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		var rec snapshotRecord
+		if err := json.Unmarshal(b, &rec); err != nil || rec.Type == "" {
+			// a link, possibly malformed
+			link := new(Link)
+			if err := json.Unmarshal(b, link); err != nil {
+				snap.Links = append(snap.Links, importRow{Row: line, Err: err})
+				continue
+			}
+			snap.Links = append(snap.Links, importRow{Row: line, Link: link})
+			continue
+		}
+		switch {
+		case rec.Type == snapshotHeaderType && rec.Header != nil:
+			if snap.Header != nil {
+				return nil, fmt.Errorf("line %d: duplicate snapshot header", line)
+			}
+			if rec.Header.Version > snapshotVersion {
+				return nil, fmt.Errorf("snapshot version %d is newer than supported version %d; upgrade golink", rec.Header.Version, snapshotVersion)
+			}
+			snap.Header = rec.Header
+		case rec.Type == snapshotStatsType && rec.Stats != nil:
+			snap.Stats = append(snap.Stats, *rec.Stats)
+		case rec.Type == snapshotRevisionType && rec.Revision != nil:
+			snap.Revisions = append(snap.Revisions, rec.Revision)
+		default:
+			return nil, fmt.Errorf("line %d: invalid %q record", line, rec.Type)
+		}
+	}
+	return snap, bs.Err()
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 Tailscale Inc & Contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package golink
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	created := time.Date(2022, 06, 02, 1, 2, 3, 0, time.UTC)
+	db := NewMemoryDB()
+	s := newTestServer(t, db)
+	db.Save(&Link{Short: "who", Long: "http://who/", Owner: "foo@example.com", Created: created, LastEdit: created})
+	db.Save(&Link{Short: "docs", Long: "http://docs/", Owner: "foo@example.com", Created: created, LastEdit: created})
+	db.SaveStats(ClickStats{"who": 3, "docs": 1})
+	// made before the link was renamed from whom
+	db.SaveRevision(&Revision{Short: "whom", Long: "http://who/v1", Owner: "foo@example.com", Editor: "foo@example.com", Created: created, LinkID: "who"})
+	db.SaveRevision(&Revision{Short: "who", Long: "http://who/v2", Owner: "foo@example.com", Editor: "bar@example.com", Created: created.Add(time.Hour)})
+
+	r := httptest.NewRequest("GET", "/.export?stats=true&history=true", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	snapshot := w.Body.Bytes()
+
+	snap, err := readSnapshot(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Header == nil || snap.Header.Version != snapshotVersion || snap.Header.SchemaVersion != schemaVersion {
+		t.Errorf("snapshot header = %+v; want version %d, schema %d", snap.Header, snapshotVersion, schemaVersion)
+	}
+	if len(snap.Links) != 2 || len(snap.Stats) != 2 || len(snap.Revisions) != 2 {
+		t.Fatalf("snapshot has %d links, %d stats, %d revisions; want 2, 2, 2", len(snap.Links), len(snap.Stats), len(snap.Revisions))
+	}
+
+	// restore into an empty database in which docs already exists
+	db = NewMemoryDB()
+	s = newTestServer(t, db)
+	db.Save(&Link{Short: "docs", Long: "http://docs/new", Owner: "bar@example.com", Created: created, LastEdit: created})
+	s.opts.Snapshot = snapshot
+	if err := s.restoreSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := db.Load("who")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Long != "http://who/" {
+		t.Errorf("restored who = %q; want %q", link.Long, "http://who/")
+	}
+	if link, _ := db.Load("docs"); link.Long != "http://docs/new" {
+		t.Errorf("existing docs = %q; want it unchanged", link.Long)
+	}
+
+	// only the stats and history of restored links are restored
+	clicks, err := db.LoadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ClickStats{"who": 3}, clicks); diff != "" {
+		t.Errorf("restored stats diff (-want +got):\n%s", diff)
+	}
+	revs, err := db.LoadRevisions("who")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRevs := []*Revision{
+		{Short: "who", Long: "http://who/v2", Owner: "foo@example.com", Editor: "bar@example.com", Created: created.Add(time.Hour)},
+		{Short: "whom", Long: "http://who/v1", Owner: "foo@example.com", Editor: "foo@example.com", Created: created, LinkID: "who"},
+	}
+	if diff := cmp.Diff(wantRevs, revs, cmpopts.IgnoreFields(Revision{}, "ID")); diff != "" {
+		t.Errorf("restored revisions diff (-want +got):\n%s", diff)
+	}
+	if revs, _ := db.LoadRevisions("docs"); len(revs) != 0 {
+		t.Errorf("existing docs has %d revisions; want 0", len(revs))
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	t.Run("version 1", func(t *testing.T) {
+		snap, err := readSnapshot(strings.NewReader(`{"Short":"who","Long":"http://who/"}
+
+{"Short":"docs","Long":"http://docs/"}
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.Header != nil || len(snap.Links) != 2 || snap.Links[1].Row != 3 {
+			t.Errorf("readSnapshot(version 1) = %+v; want 2 links and no header", snap)
+		}
+	})
+
+	errorTests := []struct {
+		name, input, want string
+	}{
+		{"newer version", `{"Type":"header","Header":{"Version":99}}`, "snapshot version 99 is newer"},
+		{"duplicate header", `{"Type":"header","Header":{"Version":2}}
+{"Type":"header","Header":{"Version":2}}`, "line 2: duplicate snapshot header"},
+		{"unknown type", `{"Type":"trash"}`, `line 1: invalid "trash" record`},
+		{"missing stats", `{"Type":"stats"}`, `line 1: invalid "stats" record`},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSnapshot(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readSnapshot error = %v; want %q", err, tt.want)
+			}
+		})
+	}
+}
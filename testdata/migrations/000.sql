@@ -0,0 +1,23 @@
+-- A database created before schema versioning was introduced,
+-- with tables created by the original schema.sql and user_version 0.
+
+CREATE TABLE Links (
+	ID       TEXT    PRIMARY KEY,
+	Short    TEXT    NOT NULL DEFAULT "",
+	Long     TEXT    NOT NULL DEFAULT "",
+	Created  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+	LastEdit INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+	Owner	 TEXT    NOT NULL DEFAULT ""
+);
+
+CREATE TABLE Stats (
+	ID       TEXT    NOT NULL DEFAULT "",
+	Created  INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+	Clicks   INTEGER
+);
+
+INSERT INTO Links (ID, Short, Long, Created, LastEdit, Owner) VALUES
+	('fixture', 'Fixture', 'http://fixture/', 1654131723, 1654131723, 'foo@example.com');
+INSERT INTO Stats (ID, Created, Clicks) VALUES
+	('fixture', 1654131723, 2),
+	('fixture', 1654131783, 3);
@@ -0,0 +1,5 @@
+INSERT INTO Links (ID, Short, Long, Created, LastEdit, Owner) VALUES
+	('fixture', 'Fixture', 'http://fixture/', 1654131723, 1654131723, 'foo@example.com');
+INSERT INTO Stats (ID, Created, Clicks) VALUES
+	('fixture', 1654131723, 2),
+	('fixture', 1654131783, 3);
@@ -0,0 +1,7 @@
+INSERT INTO Links (ID, Short, Long, Created, LastEdit, Owner) VALUES
+	('fixture', 'Fixture', 'http://fixture/', 1654131723, 1654131723, 'foo@example.com');
+INSERT INTO Stats (ID, Created, Clicks) VALUES
+	('fixture', 1654131723, 2),
+	('fixture', 1654131783, 3);
+INSERT INTO History (ID, Short, Long, Owner, Editor, Created) VALUES
+	('fixture', 'Fixture', 'http://old-fixture/', 'foo@example.com', 'foo@example.com', 1654131723);
@@ -0,0 +1,11 @@
+INSERT INTO Links (ID, Short, Long, Created, LastEdit, Owner, Description) VALUES
+	('fixture', 'Fixture', 'http://fixture/', 1654131723, 1654131723, 'foo@example.com', 'A fixture link');
+INSERT INTO Stats (ID, Created, Clicks) VALUES
+	('fixture', 1654131723, 2),
+	('fixture', 1654131783, 3);
+INSERT INTO History (ID, Short, Long, Owner, Editor, Created) VALUES
+	('fixture', 'Fixture', 'http://old-fixture/', 'foo@example.com', 'foo@example.com', 1654131723);
+INSERT INTO Trash (ID, Short, Long, Created, LastEdit, Owner, Description, DeletedBy, Deleted) VALUES
+	('trashed', 'Trashed', 'http://trashed/', 1654131723, 1654131723, 'foo@example.com', 'A trashed link', 'foo@example.com', 1654131783);
+INSERT INTO TrashStats (ID, Created, Clicks) VALUES
+	('trashed', 1654131723, 1);
@@ -0,0 +1,25 @@
+INSERT INTO Links (ID, Short, Long, Created, LastEdit, Owner, Description, ExpiresAt, Expired) VALUES
+	('fixture', 'Fixture', 'http://fixture/', 1654131723, 1654131723, 'foo@example.com', 'A fixture link', 1969660800, 0);
+INSERT INTO Stats (ID, Created, Clicks) VALUES
+	('fixture', 1654131723, 2),
+	('fixture', 1654131783, 3);
+INSERT INTO History (ID, Short, Long, Owner, Editor, Created) VALUES
+	('fixture', 'Fixture', 'http://old-fixture/', 'foo@example.com', 'foo@example.com', 1654131723);
+INSERT INTO Trash (ID, Short, Long, Created, LastEdit, Owner, Description, DeletedBy, Deleted) VALUES
+	('trashed', 'Trashed', 'http://trashed/', 1654131723, 1654131723, 'foo@example.com', 'A trashed link', 'foo@example.com', 1654131783);
+INSERT INTO TrashStats (ID, Created, Clicks) VALUES
+	('trashed', 1654131723, 1);
+INSERT INTO LinkTags (ID, Tag) VALUES
+	('fixture', 'oncall'),
+	('fixture', 'team:infra');
+INSERT INTO TrashTags (ID, Tag) VALUES
+	('trashed', 'deprecated');
+INSERT INTO Aliases (ID, Alias, LinkID) VALUES
+	('fix', 'Fix', 'fixture');
+INSERT INTO TrashAliases (ID, Alias, LinkID) VALUES
+	('trash', 'trash', 'trashed');
+INSERT INTO CoOwners (ID, Owner) VALUES
+	('fixture', 'bar@example.com'),
+	('fixture', 'group:infra');
+INSERT INTO TrashCoOwners (ID, Owner) VALUES
+	('trashed', 'bar@example.com');